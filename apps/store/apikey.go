@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/apikeys"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+const apiKeyUsage = `usage:
+  store [flags] apikey add [-rate r] [-burst b] <name>
+  store [flags] apikey list
+  store [flags] apikey revoke <name>`
+
+// Manage the api keys of the tcp server
+func runApiKeyCommand(ctx context.Context, a *app.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "add":
+		var rate float64
+		var burst int
+		flags := flag.NewFlagSet("apikey add", flag.ContinueOnError)
+		flags.Float64Var(&rate, "rate", 0, "Requests per second (-key-rate if 0)")
+		flags.IntVar(&burst, "burst", 0, "Burst of requests (-key-burst if 0)")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New(apiKeyUsage)
+		}
+		key, err := apikeys.Add(ctx, a, flags.Arg(0), rate, burst)
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+
+	case "list":
+		keys, err := apikeys.List(ctx, a)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tRATE\tBURST\tCREATED")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%g\t%d\t%s\n", k.Name, k.Rate, k.Burst, time.Unix(k.Created, 0).Format(time.DateTime))
+		}
+		w.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+		ok, err := apikeys.Revoke(ctx, a, args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no api key named %s", args[1])
+		}
+
+	default:
+		return errors.New(apiKeyUsage)
+	}
+	return nil
+}
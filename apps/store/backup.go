@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/backups"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+const backupUsage = `usage:
+  store [flags] backup create
+  store [flags] backup list
+  store [flags] backup restore <name>`
+
+// Create and list the snapshots of the database
+func runBackupCommand(ctx context.Context, a *app.App, args []string) error {
+	if len(args) != 1 {
+		return errors.New(backupUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		s, err := backups.Create(ctx, a, a.Config.Backups.Dir, a.Config.Backups.Keep)
+		if err != nil {
+			return err
+		}
+		fmt.Println(s.Name)
+
+	case "list":
+		snapshots, err := backups.List(a.Config.Backups.Dir)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTIME\tSIZE")
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", s.Name, s.Time.Local().Format(time.DateTime), s.Size)
+		}
+		w.Flush()
+
+	default:
+		return errors.New(backupUsage)
+	}
+	return nil
+}
+
+// Replace the database by a snapshot
+// WARN: the store must be stopped
+func runBackupRestoreCommand(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New(backupUsage)
+	}
+	if cfg.DbDriver != "sqlite" {
+		return database.ErrSqliteOnly
+	}
+	err := backups.Restore(context.Background(), cfg.Backups.Dir, args[0], cfg.Db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database restored from %s, the previous database is %s.old\n", args[0], cfg.Db)
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+)
+
+// The flags override the config file, their defaults are the ones of the
+// config
+func registerFlags(cfg *config.Config) {
+	flag.BoolVar(&cfg.Workers.Histories, "history", cfg.Workers.Histories, "Enable histories update")
+	flag.BoolVar(&cfg.Workers.Orders, "order", cfg.Workers.Orders, "Enable orders update")
+	flag.BoolVar(&cfg.Workers.Metrics, "metric", cfg.Workers.Metrics, "Enable metrics update")
+	flag.BoolVar(&cfg.Workers.Structures, "structure", cfg.Workers.Structures, "Enable fetching of public player structures (requires ssoClientId, ssoClientSecret and ssoRefreshToken)")
+	flag.BoolVar(&cfg.Workers.Backups, "backup", cfg.Workers.Backups, "Enable scheduled database backups")
+	flag.BoolVar(&cfg.Histories.SkipFilled, "history-skip-filled", cfg.Histories.SkipFilled, "Compute histories rolling indicators over real trading days only, ignoring filled gap days")
+	flag.BoolVar(&cfg.Servers.Socket, "socket", cfg.Servers.Socket, "Enable unix socket server")
+	flag.BoolVar(&cfg.Servers.Tcp, "tcp", cfg.Servers.Tcp, "Enable tcp server")
+	flag.BoolVar(&cfg.Servers.Grpc, "grpc", cfg.Servers.Grpc, "Enable gRPC server")
+	flag.BoolVar(&cfg.Servers.Victoria, "victoria", cfg.Servers.Victoria, "Enable victoria metric server")
+	flag.StringVar(&cfg.Servers.SocketPath, "socket-path", cfg.Servers.SocketPath, "Path for the socket of the unix socket server")
+	flag.StringVar(&cfg.Servers.GrpcSocketPath, "grpc-socket-path", cfg.Servers.GrpcSocketPath, "Path for the socket of the gRPC server")
+	flag.StringVar(&cfg.DbDriver, "db-driver", cfg.DbDriver, "Database driver, sqlite or postgres")
+	flag.StringVar(&cfg.Db, "db", cfg.Db, "Path sqlite database or postgres connection string")
+	flag.StringVar(&cfg.Backups.Dir, "backup-dir", cfg.Backups.Dir, "Directory of the database snapshots")
+	flag.StringVar(&cfg.Regions.Groups, "region-groups", cfg.Regions.Groups, "Path to a json file defining the region groups of aggregated histories (default groups if empty)")
+	flag.StringVar(&cfg.MarketGroups, "market-groups", cfg.MarketGroups, "Path to the market-group.json file of the website esi cache ($ESI_CACHE/market-group.json if empty)")
+	flag.Func("metric-estimators", "Comma separated price estimators computed along the best price (top5pct, median5, minisk) (default \"top5pct,median5,minisk\")", func(value string) error {
+		cfg.Metrics.Estimators = nil
+		if value != "" {
+			cfg.Metrics.Estimators = strings.Split(value, ",")
+		}
+		return nil
+	})
+	flag.Func("metric-hubs", "Comma separated station ids of the trade hubs with location metrics (jita, amarr, dodixie, rens and hek if empty)", func(value string) error {
+		ids, err := parseIds(value)
+		cfg.Metrics.Hubs = ids
+		return err
+	})
+	flag.Func("metric-basket", "Comma separated type ids of the basket of the market price index (minerals and plex if empty)", func(value string) error {
+		ids, err := parseIds(value)
+		cfg.Metrics.Basket = ids
+		return err
+	})
+	flag.Func("trusted-proxies", "Comma separated addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For header is trusted", func(value string) error {
+		cfg.Api.TrustedProxies = nil
+		if value != "" {
+			cfg.Api.TrustedProxies = strings.Split(value, ",")
+		}
+		return nil
+	})
+	flag.IntVar(&cfg.Servers.TcpPort, "tcp-port", cfg.Servers.TcpPort, "Tcp server port")
+	flag.BoolVar(&cfg.Api.KeyRequired, "api-key-required", cfg.Api.KeyRequired, "Reject the tcp requests without api key")
+	flag.Float64Var(&cfg.Api.IpRate, "ip-rate", cfg.Api.IpRate, "Requests per second per ip of the tcp requests without api key (0 for no limit)")
+	flag.IntVar(&cfg.Api.IpBurst, "ip-burst", cfg.Api.IpBurst, "Burst of requests per ip of the tcp requests without api key")
+	flag.Float64Var(&cfg.Api.KeyRate, "key-rate", cfg.Api.KeyRate, "Default requests per second per api key of the tcp requests (0 for no limit)")
+	flag.IntVar(&cfg.Api.KeyBurst, "key-burst", cfg.Api.KeyBurst, "Default burst of requests per api key of the tcp requests")
+	flag.IntVar(&cfg.Histories.MarketWeeklyAfter, "market-weekly-after", cfg.Histories.MarketWeeklyAfter, "Days without orders nor trades after which a market history is fetched weekly")
+	flag.IntVar(&cfg.Histories.MarketRetireAfter, "market-retire-after", cfg.Histories.MarketRetireAfter, "Days without orders nor trades after which a market history is no longer fetched")
+	flag.IntVar(&cfg.Histories.MarketMaxNotFound, "market-max-not-found", cfg.Histories.MarketMaxNotFound, "Consecutive 404 history responses after which a market history is fetched weekly")
+	flag.IntVar(&cfg.Histories.MarketRetireNotFound, "market-retire-not-found", cfg.Histories.MarketRetireNotFound, "Consecutive 404 history responses after which a market history is no longer fetched")
+	flag.IntVar(&cfg.Metrics.IntradayRetention, "intraday-retention", cfg.Metrics.IntradayRetention, "Days of hourly intraday prices kept in db")
+}
+
+func parseIds(value string) ([]int, error) {
+	var ids []int
+	if value == "" {
+		return ids, nil
+	}
+	for _, s := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -3,12 +3,26 @@ module github.com/raph5/eve-market-browser/apps/store
 go 1.22.3
 
 require (
+	// config file
+	github.com/BurntSushi/toml v1.4.0
+	// victoria metrics
+	github.com/VictoriaMetrics/metrics v1.35.2
+	// history blobs compression
+	github.com/klauspost/compress v1.18.0
+	// postgres
+	github.com/lib/pq v1.10.9
 	// sqlite
 	github.com/mattn/go-sqlite3 v1.14.22
+	// grpc
+	google.golang.org/grpc v1.66.3
+	google.golang.org/protobuf v1.34.2
+)
 
-	// victoria metrics
-	github.com/VictoriaMetrics/metrics v1.35.2
+require (
 	github.com/valyala/fastrand v1.1.0 // indirect
 	github.com/valyala/histogram v1.2.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
 )
@@ -4,15 +4,21 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/anomalies"
+	"github.com/raph5/eve-market-browser/apps/store/items/backups"
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
 	"github.com/raph5/eve-market-browser/apps/store/items/histories"
 	"github.com/raph5/eve-market-browser/apps/store/items/locations"
 	"github.com/raph5/eve-market-browser/apps/store/items/orders"
+	"github.com/raph5/eve-market-browser/apps/store/items/status"
 	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 )
 
 var (
@@ -20,21 +26,22 @@ var (
 	historyStatus = metrics.NewCounter("store_history_status_info")
 )
 
-func runOrdersHoardling(ctx context.Context) {
-	structuresEnabled := ctx.Value("structuresEnabled").(bool)
+func runOrdersHoardling(ctx context.Context, a *app.App) {
+	structuresEnabled := a.Config.Workers.Structures
 
 	for ctx.Err() == nil {
-		now := time.Now()
-		expiration, err := timerecord.Get(ctx, "OrdersExpiration")
+		now := a.Now()
+		expiration, err := timerecord.Get(ctx, a, "OrdersExpiration")
 		if err != nil {
 			log.Printf("orders hoardling error: timerecord get: %v", err)
+			status.ReportError(a, status.OrdersWorker, fmt.Errorf("timerecord get: %w", err))
 			break
 		}
 
 		delta := expiration.Sub(now)
 		if delta > 0 {
 			orderStatus.Set(1)
-			log.Print("Orders hoardling: up to date")
+			logProgress(a, "Orders hoardling: up to date")
 
 			err := sleep(ctx, delta)
 			if err == nil {
@@ -44,20 +51,25 @@ func runOrdersHoardling(ctx context.Context) {
 		}
 
 		orderStatus.Set(0)
-		log.Print("Orders hoardling: downloading orders and locations")
+		logProgress(a, "Orders hoardling: downloading orders and locations")
 
-		err = orders.Download(ctx)
+		downloaded, err := orders.Download(ctx, a)
 		if err != nil {
 			log.Printf("Orders hoardling error: orders download: %v", err)
-			log.Print("Order hoardling: 2 minutes backoff")
-			sleep(ctx, 2*time.Minute)
+			status.ReportError(a, status.OrdersWorker, fmt.Errorf("orders download: %w", err))
+			backoff := a.Current().Schedule.OrdersBackoff
+			logProgress(a, "Order hoardling: %s backoff", backoff)
+			sleep(ctx, backoff)
 			continue
 		}
+		events.PublishOrders(a, a.Now(), downloaded)
+		status.ReportSuccess(a, status.OrdersWorker)
 
 		if structuresEnabled {
-			err = locations.PopulateStructure(ctx)
+			err = locations.PopulateStructure(ctx, a)
 			if err != nil {
 				log.Printf("Orders hoardling error: locations populate structures: %v", err)
+				status.ReportError(a, status.OrdersWorker, fmt.Errorf("locations populate structures: %w", err))
 				if ctx.Err() != nil {
 					break
 				}
@@ -68,15 +80,18 @@ func runOrdersHoardling(ctx context.Context) {
 		// header provided by the esi.
 		// The probleme with the current implementation is that orders data can
 		// change will Im fetching the orders batch
+		// NOTE: a new interval is used from the next expiration
+		interval := a.Current().Schedule.OrdersInterval
 		var newExpiration time.Time
 		if expiration.IsZero() {
-			newExpiration = time.Now().Add(10 * time.Minute)
+			newExpiration = a.Now().Add(interval)
 		} else {
-			newExpiration = expiration.Add(-delta.Truncate(10*time.Minute) + 10*time.Minute)
+			newExpiration = expiration.Add(-delta.Truncate(interval) + interval)
 		}
-		err = timerecord.Set(ctx, "OrdersExpiration", newExpiration)
+		err = timerecord.Set(ctx, a, "OrdersExpiration", newExpiration)
 		if err != nil {
 			log.Printf("Orders hoardling error: timerecord set: %v", err)
+			status.ReportError(a, status.OrdersWorker, fmt.Errorf("timerecord set: %w", err))
 			break
 		}
 	}
@@ -84,19 +99,20 @@ func runOrdersHoardling(ctx context.Context) {
 	log.Print("Orders hoardling: stopping")
 }
 
-func runHistoriesHoardling(ctx context.Context) {
+func runHistoriesHoardling(ctx context.Context, a *app.App) {
 	for ctx.Err() == nil {
-		now := time.Now()
-		expiration, err := timerecord.Get(ctx, "HistoriesExpiration")
+		now := a.Now()
+		expiration, err := timerecord.Get(ctx, a, "HistoriesExpiration")
 		if err != nil {
 			log.Printf("Histories hoardling error: timerecord get: %v", err)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("timerecord get: %w", err))
 			break
 		}
 
 		delta := expiration.Sub(now)
 		if delta > 0 {
 			historyStatus.Set(1)
-			log.Print("Histories hoardling: up to date")
+			logProgress(a, "Histories hoardling: up to date")
 
 			err := sleep(ctx, delta)
 			if err == nil {
@@ -105,39 +121,66 @@ func runHistoriesHoardling(ctx context.Context) {
 			continue
 		}
 
-		day := time.Now()
+		day := a.Now()
 		historyStatus.Set(0)
-		log.Print("Histories hoardling: downloading histories")
+		logProgress(a, "Histories hoardling: downloading histories")
 
-		err = activemarkets.Populate(ctx)
+		err = activemarkets.Populate(ctx, a)
 		if err != nil {
 			log.Printf("Histories hoardling error: active types populate: %v", err)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("active types populate: %w", err))
 			continue
 		}
 
-		err = histories.Download(ctx)
+		err = histories.Download(ctx, a, day)
 		if err != nil {
 			log.Printf("Histories hoardling error: histories download: %v", err)
-			log.Print("Histories hoardling: 5 minutes backoff")
-			sleep(ctx, 5*time.Minute)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("histories download: %w", err))
+			backoff := a.Current().Schedule.HistoriesBackoff
+			logProgress(a, "Histories hoardling: %s backoff", backoff)
+			sleep(ctx, backoff)
 			continue
 		}
 
-		err = histories.ComputeGobalHistories(ctx, day)
+		err = histories.ComputeGobalHistories(ctx, a, day)
 		if err != nil {
 			log.Printf("Histories hoardling error: compute global histories: %v", err)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("compute global histories: %w", err))
 			if ctx.Err() != nil {
 				break
 			}
+		} else {
+			events.PublishHistories(a, day)
+			status.ReportSuccess(a, status.HistoriesWorker)
 		}
 
-		elevenFifteenTomorrow := time.Date(now.Year(), now.Month(), now.Day(), 11, 15, 0, 0, now.Location())
-		if elevenFifteenTomorrow.Before(now) {
-			elevenFifteenTomorrow = elevenFifteenTomorrow.AddDate(0, 0, 1)
+		err = anomalies.Detect(ctx, a, day)
+		if err != nil {
+			log.Printf("Histories hoardling error: anomalies detection: %v", err)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("anomalies detection: %w", err))
+			if ctx.Err() != nil {
+				break
+			}
 		}
-		err = timerecord.Set(ctx, "HistoriesExpiration", elevenFifteenTomorrow)
+
+		err = histories.Compact(ctx, a)
+		if err != nil {
+			log.Printf("Histories hoardling error: histories compaction: %v", err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		schedule := a.Current().Schedule
+		hour, minute := schedule.HistoriesClock()
+		nextRun := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+		if nextRun.Before(now) {
+			nextRun = nextRun.AddDate(0, 0, 1)
+		}
+		err = timerecord.Set(ctx, a, "HistoriesExpiration", nextRun)
 		if err != nil {
 			log.Printf("Histories hoardling error: timerecord set: %v", err)
+			status.ReportError(a, status.HistoriesWorker, fmt.Errorf("timerecord set: %w", err))
 			break
 		}
 	}
@@ -145,6 +188,52 @@ func runHistoriesHoardling(ctx context.Context) {
 	log.Print("Histories hoardling: stopping")
 }
 
+func runBackupsHoardling(ctx context.Context, a *app.App) {
+	dir, keep := a.Config.Backups.Dir, a.Config.Backups.Keep
+
+	for ctx.Err() == nil {
+		now := a.Now()
+		expiration, err := timerecord.Get(ctx, a, "BackupsExpiration")
+		if err != nil {
+			log.Printf("Backups hoardling error: timerecord get: %v", err)
+			break
+		}
+
+		delta := expiration.Sub(now)
+		if delta > 0 {
+			logProgress(a, "Backups hoardling: up to date")
+			sleep(ctx, delta)
+			continue
+		}
+
+		logProgress(a, "Backups hoardling: creating a snapshot")
+		snapshot, err := backups.Create(ctx, a, dir, keep)
+		if err != nil {
+			// NOTE: no backoff, a failing backup is retried at the next interval
+			log.Printf("Backups hoardling error: create snapshot: %v", err)
+		} else {
+			logProgress(a, "Backups hoardling: snapshot %s created (%d bytes)", snapshot.Name, snapshot.Size)
+		}
+
+		interval := a.Current().Schedule.BackupsInterval
+		err = timerecord.Set(ctx, a, "BackupsExpiration", a.Now().Add(interval))
+		if err != nil {
+			log.Printf("Backups hoardling error: timerecord set: %v", err)
+			break
+		}
+	}
+
+	log.Print("Backups hoardling: stopping")
+}
+
+// Progress messages of the workers, they are not logged with log_level error
+func logProgress(a *app.App, format string, v ...any) {
+	if a.Current().LogLevel == "error" {
+		return
+	}
+	log.Printf(format, v...)
+}
+
 func sleep(ctx context.Context, duration time.Duration) error {
 	timer := time.NewTimer(duration)
 	select {
@@ -1,13 +1,21 @@
 // Active markets are the couples type, region for which we decide to fetch the
 // history.
+//
+// Each active market has a lifecycle tracked in the ActiveMarketState table.
+// Markets that stop trading or that the esi does not know about are demoted to
+// weekly polling and then retired, according to the Rules. An admin can
+// override the status of a market.
 
 package activemarkets
 
 import (
 	"context"
+	"fmt"
 	"time"
 
-	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
 )
 
 type ActiveMarket struct {
@@ -15,90 +23,73 @@ type ActiveMarket struct {
 	RegionId int
 }
 
-func Populate(ctx context.Context) error {
-	db := ctx.Value("db").(*database.DB)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
-
-	populateQuery := `
-  INSERT OR IGNORE INTO ActiveMarket
-    SELECT DISTINCT TypeId, RegionId FROM "Order";
-  `
-	_, err := db.Exec(timeoutCtx, populateQuery)
-	if err != nil {
-		return err
-	}
-
-	return nil
+type Rules struct {
+	// Time without orders nor trades after which a market is polled weekly
+	WeeklyAfter time.Duration
+	// Time without orders nor trades after which a market is retired
+	RetireAfter time.Duration
+	// Consecutive 404 or 400 history responses after which a market is polled
+	// weekly
+	MaxNotFound int
+	// Consecutive 404 or 400 history responses after which a market is
+	// retired, the weekly polls count
+	RetireNotFound int
 }
 
-func Count(ctx context.Context) (int, error) {
-	db := ctx.Value("db").(*database.DB)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
-
-	var count int
-	err := db.QueryRow(timeoutCtx, "SELECT COUNT(*) FROM ActiveMarket").Scan(&count)
-	if err != nil {
-		return 0, err
+func rulesOf(cfg config.Config) Rules {
+	return Rules{
+		WeeklyAfter:    time.Duration(cfg.Histories.MarketWeeklyAfter) * 24 * time.Hour,
+		RetireAfter:    time.Duration(cfg.Histories.MarketRetireAfter) * 24 * time.Hour,
+		MaxNotFound:    cfg.Histories.MarketMaxNotFound,
+		RetireNotFound: cfg.Histories.MarketRetireNotFound,
 	}
-
-	return count, nil
 }
 
-func GetTypesId(ctx context.Context) ([]int, error) {
-	db := ctx.Value("db").(*database.DB)
-	activeMarkets := make([]int, 0, 1024)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
-
-	rows, err := db.Query(timeoutCtx, "SELECT DISTINCT TypeId FROM ActiveMarket")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// Outcome of a history fetch
+type HistoryReport struct {
+	TypeId   int
+	RegionId int
+	NotFound bool
+	// Date of the last day with volume in the history, zero if none
+	LastTrade time.Time
+	// Isk traded on the last day of the history
+	LastValue float64
+}
 
-	for rows.Next() {
-		activeMarkets = append(activeMarkets, 0)
-		err = rows.Scan(&activeMarkets[len(activeMarkets)-1])
-		if err != nil {
-			return nil, err
-		}
-	}
+const (
+	StatusActive  = "active"
+	StatusWeekly  = "weekly"
+	StatusRetired = "retired"
+)
 
-	err = rows.Err()
-	if err != nil {
-		return nil, err
-	}
+// Markets that traded more isk than that on their last history day are
+// downloaded first by the history scheduler
+const ImportantTradedValue = 1e9
 
-	return activeMarkets, nil
+// Add the markets of the current orders to the active markets, mark them as
+// seen and update the status of every market. Only the orders of the
+// downloaded regions are read, the orders of the regions removed from the
+// config are stale.
+func Populate(ctx context.Context, a *app.App) error {
+	return storeOf(a).Populate(ctx, regions.Downloaded(a), rulesOf(a.Config))
 }
 
-func GetChunk(ctx context.Context, offset int, limit int) ([]ActiveMarket, error) {
-	db := ctx.Value("db").(*database.DB)
-	activeMarkets := make([]ActiveMarket, 0, limit)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
-	defer cancel()
-
-	rows, err := db.Query(timeoutCtx, "SELECT TypeId, RegionId FROM ActiveMarket LIMIT ? OFFSET ?", limit, offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+func ReportHistories(ctx context.Context, a *app.App, reports []HistoryReport) error {
+	return storeOf(a).ReportHistories(ctx, reports)
+}
 
-	for rows.Next() {
-		activeMarkets = activeMarkets[:len(activeMarkets)+1]
-		at := &activeMarkets[len(activeMarkets)-1]
-		err = rows.Scan(&at.TypeId, &at.RegionId)
-		if err != nil {
-			return nil, err
-		}
-	}
+func ValidStatus(status string) bool {
+	return status == StatusActive || status == StatusWeekly || status == StatusRetired
+}
 
-	err = rows.Err()
-	if err != nil {
-		return nil, err
+func setOverride(ctx context.Context, a *app.App, market ActiveMarket, override string) error {
+	if override != "" && !ValidStatus(override) {
+		return fmt.Errorf("invalid status %s", override)
 	}
+	return storeOf(a).SetOverride(ctx, market, override)
+}
 
-	return activeMarkets, nil
+// Types with at least one market that is not retired
+func GetTypesId(ctx context.Context, a *app.App) ([]int, error) {
+	return storeOf(a).GetTypesId(ctx)
 }
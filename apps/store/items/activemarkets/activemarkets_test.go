@@ -0,0 +1,145 @@
+package activemarkets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/marketgroups"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+)
+
+func TestGetTypesIdSkipsRetired(t *testing.T) {
+	stores, ctx := apptest.Databases(t, "ActiveMarket", "ActiveMarketState")
+	for driver, a := range stores {
+		markets := map[ActiveMarket]string{
+			{TypeId: 34, RegionId: 10000002}: StatusActive,
+			{TypeId: 35, RegionId: 10000002}: StatusRetired,
+			{TypeId: 36, RegionId: 10000002}: StatusRetired,
+			{TypeId: 36, RegionId: 10000043}: StatusWeekly,
+		}
+		for m, status := range markets {
+			err := setOverride(ctx, a, m, status)
+			if err != nil {
+				t.Fatalf("%s: %v", driver, err)
+			}
+		}
+
+		typeIds, err := GetTypesId(ctx, a)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		slices.Sort(typeIds)
+		if !slices.Equal(typeIds, []int{34, 36}) {
+			t.Fatalf("%s: expected the types 34 and 36, got %v", driver, typeIds)
+		}
+	}
+}
+
+func TestPopulate(t *testing.T) {
+	cfg := config.Default()
+	cfg.Regions.Downloaded = []int{10000002}
+	a, ctx := apptest.NewWithOptions(t, apptest.Options{Config: &cfg})
+	db := a.DB
+	now := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	a.Clock = func() time.Time { return now }
+
+	// the orders of 10000043 were downloaded before the region was removed
+	insertQuery := `INSERT INTO "Order" (Id, RegionId, TypeId) VALUES (?,?,?)`
+	_, err := db.Exec(ctx, insertQuery, 1, 10000002, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(ctx, insertQuery, 2, 10000043, 35)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Populate(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	err = db.QueryRow(ctx, "SELECT COUNT(*) FROM ActiveMarket WHERE TypeId = ? AND RegionId = ?", 35, 10000043).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected the market of a removed region to be left out")
+	}
+
+	// a market the esi keeps refusing is retired, even with orders
+	market := ActiveMarket{TypeId: 34, RegionId: 10000002}
+	status := func() string {
+		var status string
+		err := db.QueryRow(ctx, "SELECT Status FROM ActiveMarketState WHERE TypeId = ? AND RegionId = ?", market.TypeId, market.RegionId).Scan(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	for i := 1; i <= cfg.Histories.MarketRetireNotFound; i++ {
+		err = ReportHistories(ctx, a, []HistoryReport{{TypeId: market.TypeId, RegionId: market.RegionId, NotFound: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Populate(ctx, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := StatusActive
+		if i >= cfg.Histories.MarketRetireNotFound {
+			expected = StatusRetired
+		} else if i >= cfg.Histories.MarketMaxNotFound {
+			expected = StatusWeekly
+		}
+		if got := status(); got != expected {
+			t.Fatalf("after %d not found: expected status %s, got %s", i, expected, got)
+		}
+	}
+}
+
+func TestRecordRequest(t *testing.T) {
+	types := make([]int, maxRequestedTypes+10)
+	for i := range types {
+		types[i] = i + 1
+	}
+	groupsJson, err := json.Marshal([]map[string]any{{"id": 4, "childsId": []int{}, "types": types}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupsPath := filepath.Join(t.TempDir(), "market-group.json")
+	err = os.WriteFile(groupsPath, groupsJson, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = marketgroups.Load(groupsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := app.New(nil, nil, config.Default())
+	RecordRequest(a, -1)
+	RecordRequest(a, len(types)+1)
+	for _, typeId := range types {
+		RecordRequest(a, typeId)
+	}
+
+	requested := RequestedTypes(a)
+	if len(requested) != maxRequestedTypes {
+		t.Fatalf("expected %d requested types, got %d", maxRequestedTypes, len(requested))
+	}
+	if WasRequested(a, -1) || WasRequested(a, len(types)+1) {
+		t.Fatal("unknown type recorded")
+	}
+
+	// the requests expire after the retention
+	a.Clock = func() time.Time { return time.Now().Add(requestRetention) }
+	if WasRequested(a, 1) || len(RequestedTypes(a)) != 0 {
+		t.Error("expired requests kept")
+	}
+}
@@ -0,0 +1,107 @@
+package activemarkets
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+type apiActiveMarket struct {
+	TypeId        int     `json:"typeId"`
+	RegionId      int     `json:"regionId"`
+	LastSeen      int64   `json:"lastSeen"`
+	LastHistory   int64   `json:"lastHistory"`
+	NotFoundCount int     `json:"notFoundCount"`
+	Status        string  `json:"status"`
+	Override      *string `json:"override"`
+}
+
+// Admin handler to inspect the active markets with GET and to override the
+// status of a market with POST ?type=&region=&override= where override is
+// active, weekly, retired or none to remove the override
+func CreateAdminHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		switch r.Method {
+		case http.MethodGet:
+			typeId, err := strconv.Atoi(query.Get("type"))
+			if err != nil && query.Get("type") != "" {
+				openapi.ParamError(w, "type", "is invalid integer")
+				return
+			}
+			regionId, err := strconv.Atoi(query.Get("region"))
+			if err != nil && query.Get("region") != "" {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+			status := query.Get("status")
+			if status != "" && !ValidStatus(status) {
+				openapi.ParamError(w, "status", "is not a valid status")
+				return
+			}
+			limit := 1000
+			if query.Get("limit") != "" {
+				limit, err = strconv.Atoi(query.Get("limit"))
+				if err != nil || limit <= 0 {
+					openapi.ParamError(w, "limit", "is invalid positive integer")
+					return
+				}
+			}
+
+			markets, err := storeOf(a).List(timeoutCtx, typeId, regionId, status, limit)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(markets)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+
+		case http.MethodPost:
+			typeId, err := strconv.Atoi(query.Get("type"))
+			if err != nil {
+				openapi.ParamError(w, "type", "is invalid integer")
+				return
+			}
+			regionId, err := strconv.Atoi(query.Get("region"))
+			if err != nil {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+			override := query.Get("override")
+			if override == "none" {
+				override = ""
+			} else if !ValidStatus(override) {
+				openapi.ParamError(w, "override", "must be active, weekly, retired or none")
+				return
+			}
+
+			err = setOverride(timeoutCtx, a, ActiveMarket{TypeId: typeId, RegionId: regionId}, override)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			log.Printf("Active market type %d in region %d override set to %q", typeId, regionId, override)
+			w.WriteHeader(204)
+
+		default:
+			http.Error(w, "Method not allowed", 405)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package activemarkets
+
+import (
+	"context"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+// Repository of the active markets and of their lifecycle
+type marketStore interface {
+	// Add the markets of the orders of the downloaded regions, mark them as seen
+	// and update the status of every market
+	Populate(ctx context.Context, downloaded []int, rules Rules) error
+	ReportHistories(ctx context.Context, reports []HistoryReport) error
+	// Add the market if it is unknown, override is empty to remove the
+	// override
+	SetOverride(ctx context.Context, market ActiveMarket, override string) error
+	GetTypesId(ctx context.Context) ([]int, error)
+	// Markets of typeId in regionId with the status, the zero values disable
+	// the filters
+	List(ctx context.Context, typeId int, regionId int, status string, limit int) ([]apiActiveMarket, error)
+}
+
+func storeOf(a *app.App) marketStore {
+	return database.ForDriver[marketStore](a.DB, sqliteMarkets{a: a}, postgresMarkets{sqliteMarkets{a: a}})
+}
+
+type sqliteMarkets struct {
+	a *app.App
+}
+
+func (s sqliteMarkets) Populate(ctx context.Context, downloaded []int, rules Rules) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	downloadedArgs := make([]any, len(downloaded))
+	for i, regionId := range downloaded {
+		downloadedArgs[i] = regionId
+	}
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	populateQuery := `
+  INSERT INTO ActiveMarket
+    SELECT DISTINCT TypeId, RegionId FROM "Order" WHERE RegionId IN ` + database.InList(len(downloaded)) + `
+    ON CONFLICT DO NOTHING;
+  `
+	_, err = tx.Exec(timeoutCtx, populateQuery, downloadedArgs...)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: markets that were active before the lifecycle tracking existed
+	// start with a fresh LastSeen to give them a chance
+	now := s.a.Now().Unix()
+	stateQuery := `
+  INSERT INTO ActiveMarketState
+    SELECT TypeId, RegionId, CAST(? AS BIGINT), 0, 0, CAST(? AS TEXT), NULL FROM ActiveMarket WHERE true
+    ON CONFLICT DO NOTHING;
+  `
+	_, err = tx.Exec(timeoutCtx, stateQuery, now, StatusActive)
+	if err != nil {
+		return err
+	}
+
+	seenQuery := `
+  UPDATE ActiveMarketState SET LastSeen = ?
+    WHERE (TypeId, RegionId) IN (
+      SELECT TypeId, RegionId FROM "Order" WHERE RegionId IN ` + database.InList(len(downloaded)) + `
+    );
+  `
+	_, err = tx.Exec(timeoutCtx, seenQuery, append([]any{now}, downloadedArgs...)...)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: only the rows whose status changes are written
+	statusCase := `CASE
+    WHEN (LastSeen < ? AND LastHistory < ?) OR NotFoundCount >= ? THEN ?
+    WHEN (LastSeen < ? AND LastHistory < ?) OR NotFoundCount >= ? THEN ?
+    ELSE ? END`
+	statusArgs := []any{
+		now - int64(rules.RetireAfter.Seconds()),
+		now - int64(rules.RetireAfter.Seconds()),
+		rules.RetireNotFound,
+		StatusRetired,
+		now - int64(rules.WeeklyAfter.Seconds()),
+		now - int64(rules.WeeklyAfter.Seconds()),
+		rules.MaxNotFound,
+		StatusWeekly,
+		StatusActive,
+	}
+	statusQuery := `
+  UPDATE ActiveMarketState SET Status = ` + statusCase + `
+    WHERE Status != ` + statusCase + `;
+  `
+	_, err = tx.Exec(timeoutCtx, statusQuery, append(statusArgs, statusArgs...)...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMarkets) ReportHistories(ctx context.Context, reports []HistoryReport) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	notFoundStmt, err := tx.PrepareWrite(timeoutCtx, `
+  UPDATE ActiveMarketState SET NotFoundCount = NotFoundCount + 1
+    WHERE TypeId = ? AND RegionId = ?;
+  `)
+	if err != nil {
+		return err
+	}
+	defer notFoundStmt.Close()
+
+	foundStmt, err := tx.PrepareWrite(timeoutCtx, `
+  UPDATE ActiveMarketState SET NotFoundCount = 0, LastHistory = CASE WHEN LastHistory > ? THEN LastHistory ELSE ? END
+    WHERE TypeId = ? AND RegionId = ?;
+  `)
+	if err != nil {
+		return err
+	}
+	defer foundStmt.Close()
+
+	valueStmt, err := tx.PrepareWrite(timeoutCtx, `
+  INSERT INTO ActiveMarketValue VALUES (?,?,?)
+    ON CONFLICT (TypeId, RegionId) DO UPDATE SET TradedValue = excluded.TradedValue;
+  `)
+	if err != nil {
+		return err
+	}
+	defer valueStmt.Close()
+
+	for _, r := range reports {
+		if r.NotFound {
+			_, err = notFoundStmt.Exec(timeoutCtx, r.TypeId, r.RegionId)
+		} else {
+			var lastTrade int64
+			if !r.LastTrade.IsZero() {
+				lastTrade = r.LastTrade.Unix()
+			}
+			_, err = foundStmt.Exec(timeoutCtx, lastTrade, lastTrade, r.TypeId, r.RegionId)
+			if err != nil {
+				return err
+			}
+			_, err = valueStmt.Exec(timeoutCtx, r.TypeId, r.RegionId, r.LastValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMarkets) SetOverride(ctx context.Context, market ActiveMarket, override string) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the market may be added by the admin
+	_, err = tx.Exec(timeoutCtx, "INSERT INTO ActiveMarket VALUES (?,?) ON CONFLICT DO NOTHING", market.TypeId, market.RegionId)
+	if err != nil {
+		return err
+	}
+
+	var overrideValue any
+	if override != "" {
+		overrideValue = override
+	}
+	upsertQuery := `
+  INSERT INTO ActiveMarketState VALUES (?,?,?,0,0,?,?)
+    ON CONFLICT (TypeId, RegionId) DO UPDATE SET Override = excluded.Override;
+  `
+	_, err = tx.Exec(
+		timeoutCtx,
+		upsertQuery,
+		market.TypeId,
+		market.RegionId,
+		s.a.Now().Unix(),
+		StatusActive,
+		overrideValue,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMarkets) GetTypesId(ctx context.Context) ([]int, error) {
+	db := s.a.DB
+	activeMarkets := make([]int, 0, 1024)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT DISTINCT a.TypeId FROM ActiveMarket a
+    LEFT JOIN ActiveMarketState s ON a.TypeId = s.TypeId AND a.RegionId = s.RegionId
+    WHERE COALESCE(s.Override, s.Status, ?) != ?;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, StatusActive, StatusRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		activeMarkets = append(activeMarkets, 0)
+		err = rows.Scan(&activeMarkets[len(activeMarkets)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return activeMarkets, nil
+}
+
+func (s sqliteMarkets) List(ctx context.Context, typeId int, regionId int, status string, limit int) ([]apiActiveMarket, error) {
+	db := s.a.DB
+
+	selectQuery := `
+  SELECT TypeId, RegionId, LastSeen, LastHistory, NotFoundCount, Status, Override
+    FROM ActiveMarketState
+    WHERE (? = 0 OR TypeId = ?)
+    AND (? = 0 OR RegionId = ?)
+    AND (? = '' OR COALESCE(Override, Status) = ?)
+    LIMIT ?;
+  `
+	rows, err := db.Query(ctx, selectQuery, typeId, typeId, regionId, regionId, status, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markets := make([]apiActiveMarket, 0)
+	for rows.Next() {
+		var m apiActiveMarket
+		err = rows.Scan(&m.TypeId, &m.RegionId, &m.LastSeen, &m.LastHistory, &m.NotFoundCount, &m.Status, &m.Override)
+		if err != nil {
+			return nil, err
+		}
+		markets = append(markets, m)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return markets, nil
+}
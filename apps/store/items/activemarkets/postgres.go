@@ -0,0 +1,7 @@
+package activemarkets
+
+// NOTE: the queries of the active markets run as is on postgres, the
+// parameters of the select lists are cast for its type inference
+type postgresMarkets struct {
+	sqliteMarkets
+}
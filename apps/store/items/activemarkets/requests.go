@@ -0,0 +1,82 @@
+package activemarkets
+
+import (
+	"sync"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/marketgroups"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// Types requested by the users, they are downloaded first by the history
+// scheduler. The record is kept in memory and lost on restart.
+type requests struct {
+	mu    sync.Mutex
+	types map[int]time.Time
+}
+
+func getRequests(a *app.App) *requests {
+	return app.State(a, "activemarkets", func() *requests {
+		return &requests{types: make(map[int]time.Time)}
+	})
+}
+
+const requestRetention = 7 * 24 * time.Hour
+
+// NOTE: the requested types are bound in the plan query of the history runs,
+// the cap keeps the query small
+const maxRequestedTypes = 1000
+
+// Record a request of typeId. The ids that are not market types are ignored,
+// as all the ids when the market groups are not loaded. When the record is
+// full the requests of new types are ignored until old ones expire.
+func RecordRequest(a *app.App, typeId int) {
+	ok, err := marketgroups.IsMarketType(typeId)
+	if err != nil || !ok {
+		return
+	}
+
+	r := getRequests(a)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := a.Now()
+	_, recorded := r.types[typeId]
+	if !recorded && len(r.types) >= maxRequestedTypes {
+		r.forgetExpired(now)
+		if len(r.types) >= maxRequestedTypes {
+			return
+		}
+	}
+	r.types[typeId] = now
+}
+
+// WARN: r.mu must be locked
+func (r *requests) forgetExpired(now time.Time) {
+	for typeId, t := range r.types {
+		if now.Sub(t) >= requestRetention {
+			delete(r.types, typeId)
+		}
+	}
+}
+
+func WasRequested(a *app.App, typeId int) bool {
+	r := getRequests(a)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.types[typeId]
+	return ok && a.Now().Sub(t) < requestRetention
+}
+
+// Return the types requested during the retention period and forget the
+// older ones
+func RequestedTypes(a *app.App) []int {
+	r := getRequests(a)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.forgetExpired(a.Now())
+	typeIds := make([]int, 0, len(r.types))
+	for typeId := range r.types {
+		typeIds = append(typeIds, typeId)
+	}
+	return typeIds
+}
@@ -0,0 +1,252 @@
+// Alerts are watch rules on the order books, like "best sell of type X at
+// location Y below Z". The rules are stored in the AlertRule table and checked
+// after every orders download. When a rule triggers, a message is posted to
+// its webhook. The payload carries both the "content" field of Discord and
+// the "text" field of Slack so that both kinds of webhook work.
+//
+// A rule is delivered at most once per cooldown (deduplication), a rule that
+// keeps triggering is delivered again after each cooldown. The webhooks are
+// posted in the background so that a slow webhook does not delay the orders
+// refresh.
+
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type dbOrder = shared.DbOrder
+
+type Rule struct {
+	Id       int64  `json:"id"`
+	Kind     string `json:"kind"`
+	TypeId   int    `json:"typeId"`
+	RegionId int    `json:"regionId"`
+	// 0 to watch the whole region
+	LocationId int     `json:"locationId"`
+	Threshold  float64 `json:"threshold"`
+	Webhook    string  `json:"webhook"`
+	Cooldown   int64   `json:"cooldown"` // Seconds
+	// Epoch seconds and value of the last delivery
+	LastTriggered int64   `json:"lastTriggered"`
+	LastValue     float64 `json:"lastValue"`
+}
+
+const (
+	SellBelow   = "sell-below"   // best sell price below threshold
+	BuyAbove    = "buy-above"    // best buy price above threshold
+	SpreadAbove = "spread-above" // spread above threshold percents
+)
+
+const defaultCooldown = 3600
+const webhookTimeout = 10 * time.Second
+
+// Whether the deliveries of the previous check are still running
+func getDelivering(a *app.App) *atomic.Bool {
+	return app.State(a, "alerts", func() *atomic.Bool { return &atomic.Bool{} })
+}
+
+func ValidKind(kind string) bool {
+	return kind == SellBelow || kind == BuyAbove || kind == SpreadAbove
+}
+
+type payload struct {
+	Content string `json:"content"` // Discord
+	Text    string `json:"text"`    // Slack
+	Alert   alert  `json:"alert"`
+}
+
+type alert struct {
+	RuleId     int64   `json:"ruleId"`
+	Kind       string  `json:"kind"`
+	TypeId     int     `json:"typeId"`
+	RegionId   int     `json:"regionId"`
+	LocationId int     `json:"locationId"`
+	Threshold  float64 `json:"threshold"`
+	Value      float64 `json:"value"`
+	Time       int64   `json:"time"` // Epoch Seconds
+}
+
+type bestPrices struct {
+	buy  float64 // 0 if no buy order
+	sell float64 // 0 if no sell order
+}
+
+// Check the rules against the orders of the last download and deliver the
+// triggered ones in the background. The rules triggered while the deliveries
+// of the previous check are running are delivered at the next check.
+func Check(ctx context.Context, a *app.App, retrivalTime time.Time, orders []dbOrder) error {
+	rules, err := dbGetRules(ctx, a, 0)
+	if err != nil {
+		return fmt.Errorf("get rules: %w", err)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	prices := computeBestPrices(rules, orders)
+	triggered := make([]delivery, 0)
+	for i := range rules {
+		rule := &rules[i]
+		value, ok := evaluate(rule, prices[rule.Id])
+		if !ok || !shouldDeliver(rule, retrivalTime) {
+			continue
+		}
+		triggered = append(triggered, delivery{webhook: rule.Webhook, alert: alert{
+			RuleId:     rule.Id,
+			Kind:       rule.Kind,
+			TypeId:     rule.TypeId,
+			RegionId:   rule.RegionId,
+			LocationId: rule.LocationId,
+			Threshold:  rule.Threshold,
+			Value:      value,
+			Time:       retrivalTime.Unix(),
+		}})
+	}
+	if len(triggered) == 0 {
+		return nil
+	}
+
+	delivering := getDelivering(a)
+	if !delivering.CompareAndSwap(false, true) {
+		log.Printf("Alerts: previous deliveries still running, %d alerts postponed", len(triggered))
+		return nil
+	}
+	go func() {
+		defer delivering.Store(false)
+		deliverAll(ctx, a, triggered, retrivalTime)
+	}()
+	return nil
+}
+
+type delivery struct {
+	webhook string
+	alert   alert
+}
+
+func deliverAll(ctx context.Context, a *app.App, deliveries []delivery, retrivalTime time.Time) {
+	client := &http.Client{Timeout: webhookTimeout}
+	delivered := 0
+	for _, d := range deliveries {
+		err := deliver(ctx, client, d.webhook, d.alert)
+		if err != nil {
+			// the rule is not marked as triggered so the delivery is retried at the
+			// next download
+			log.Printf("Alerts: rule %d delivery failed: %v", d.alert.RuleId, err)
+			continue
+		}
+		err = dbSetRuleTriggered(ctx, a, d.alert.RuleId, retrivalTime, d.alert.Value)
+		if err != nil {
+			log.Printf("Alerts: set rule %d triggered: %v", d.alert.RuleId, err)
+			continue
+		}
+		delivered++
+	}
+
+	if delivered > 0 {
+		log.Printf("Alerts: %d alerts delivered", delivered)
+	}
+}
+
+// Best prices of the market watched by each rule
+func computeBestPrices(rules []Rule, orders []dbOrder) map[int64]bestPrices {
+	rulesByType := make(map[int][]*Rule)
+	for i := range rules {
+		rulesByType[rules[i].TypeId] = append(rulesByType[rules[i].TypeId], &rules[i])
+	}
+
+	prices := make(map[int64]bestPrices, len(rules))
+	for i := range orders {
+		o := &orders[i]
+		for _, rule := range rulesByType[o.TypeId] {
+			if o.RegionId != rule.RegionId || (rule.LocationId != 0 && o.LocationId != rule.LocationId) {
+				continue
+			}
+			p := prices[rule.Id]
+			if o.IsBuyOrder && o.Price > p.buy {
+				p.buy = o.Price
+			} else if !o.IsBuyOrder && (p.sell == 0 || o.Price < p.sell) {
+				p.sell = o.Price
+			}
+			prices[rule.Id] = p
+		}
+	}
+
+	return prices
+}
+
+// Return the value watched by the rule and whether the rule is triggered
+func evaluate(rule *Rule, p bestPrices) (float64, bool) {
+	switch rule.Kind {
+	case SellBelow:
+		return p.sell, p.sell != 0 && p.sell < rule.Threshold
+	case BuyAbove:
+		return p.buy, p.buy != 0 && p.buy > rule.Threshold
+	case SpreadAbove:
+		if p.buy == 0 || p.sell == 0 {
+			return 0, false
+		}
+		spread := (p.sell - p.buy) / p.sell * 100
+		return spread, spread > rule.Threshold
+	default:
+		return 0, false
+	}
+}
+
+func shouldDeliver(rule *Rule, now time.Time) bool {
+	if rule.LastTriggered == 0 {
+		return true
+	}
+	return now.Unix() >= rule.LastTriggered+rule.Cooldown
+}
+
+func deliver(ctx context.Context, client *http.Client, webhook string, a alert) error {
+	message := formatMessage(a)
+	body, err := json.Marshal(payload{Content: message, Text: message, Alert: a})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+func formatMessage(a alert) string {
+	market := fmt.Sprintf("type %d in region %d", a.TypeId, a.RegionId)
+	if a.LocationId != 0 {
+		market = fmt.Sprintf("type %d at location %d", a.TypeId, a.LocationId)
+	}
+	switch a.Kind {
+	case SellBelow:
+		return fmt.Sprintf("Best sell of %s is %.2f ISK, below %.2f ISK", market, a.Value, a.Threshold)
+	case BuyAbove:
+		return fmt.Sprintf("Best buy of %s is %.2f ISK, above %.2f ISK", market, a.Value, a.Threshold)
+	case SpreadAbove:
+		return fmt.Sprintf("Spread of %s is %.1f%%, above %.1f%%", market, a.Value, a.Threshold)
+	default:
+		return fmt.Sprintf("Alert %s on %s", a.Kind, market)
+	}
+}
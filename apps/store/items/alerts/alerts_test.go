@@ -0,0 +1,166 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Id: 1, Kind: SellBelow, TypeId: 34, RegionId: 10000002, Threshold: 5},
+		{Id: 2, Kind: BuyAbove, TypeId: 34, RegionId: 10000002, LocationId: 60003760, Threshold: 5},
+		{Id: 3, Kind: SpreadAbove, TypeId: 34, RegionId: 10000002, Threshold: 10},
+		{Id: 4, Kind: SellBelow, TypeId: 35, RegionId: 10000002, Threshold: 5},
+	}
+	orders := []dbOrder{
+		{TypeId: 34, RegionId: 10000002, LocationId: 60003760, Price: 4.5},
+		{TypeId: 34, RegionId: 10000002, LocationId: 60008494, Price: 6},
+		{TypeId: 34, RegionId: 10000002, LocationId: 60003760, Price: 4, IsBuyOrder: true},
+		{TypeId: 34, RegionId: 10000002, LocationId: 60008494, Price: 4.2, IsBuyOrder: true},
+		{TypeId: 34, RegionId: 10000043, LocationId: 60008494, Price: 1},
+	}
+
+	prices := computeBestPrices(rules, orders)
+	tests := []struct {
+		value     float64
+		triggered bool
+	}{
+		{4.5, true},
+		{4, false},
+		{(4.5 - 4.2) / 4.5 * 100, false},
+		{0, false},
+	}
+	for i, test := range tests {
+		value, triggered := evaluate(&rules[i], prices[rules[i].Id])
+		if math.Abs(value-test.value) > 1e-9 || triggered != test.triggered {
+			t.Errorf("rule %d: got %v %v, want %v %v", rules[i].Id, value, triggered, test.value, test.triggered)
+		}
+	}
+}
+
+func TestShouldDeliver(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rule := Rule{Cooldown: 3600}
+	if !shouldDeliver(&rule, now) {
+		t.Error("a rule never delivered should be delivered")
+	}
+
+	rule.LastTriggered = now.Unix() - 7200
+	rule.LastValue = 4
+	if !shouldDeliver(&rule, now) {
+		t.Error("a rule still triggered after the cooldown should be delivered again")
+	}
+
+	rule.LastTriggered = now.Unix() - 60
+	if shouldDeliver(&rule, now) {
+		t.Error("a rule should not be delivered during the cooldown")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var received payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&received)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	a := alert{RuleId: 1, Kind: SellBelow, TypeId: 34, RegionId: 10000002, Threshold: 5, Value: 4.5}
+	err := deliver(context.Background(), server.Client(), server.URL, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Alert != a {
+		t.Errorf("got alert %v, want %v", received.Alert, a)
+	}
+	if received.Content == "" || received.Content != received.Text {
+		t.Errorf("expected the same message in content and text, got %q and %q", received.Content, received.Text)
+	}
+	if !strings.Contains(received.Content, "4.50") {
+		t.Errorf("expected the value in the message, got %q", received.Content)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer failing.Close()
+	err = deliver(context.Background(), failing.Client(), failing.URL, a)
+	if err == nil {
+		t.Error("expected an error on a failing webhook")
+	}
+}
+
+func TestCheckDeliversInBackground(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	a, ctx := apptest.New(t)
+
+	rule := Rule{Kind: SellBelow, TypeId: 34, RegionId: 10000002, Threshold: 5, Webhook: server.URL, Cooldown: 3600}
+	id, err := dbInsertRule(ctx, a, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.Id = id
+
+	// the webhook hangs until release, Check must not wait for it
+	orders := []dbOrder{{OrderId: 1, TypeId: 34, RegionId: 10000002, Price: 4.5}}
+	err = Check(ctx, a, time.Unix(1700000000, 0), orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+
+	for getDelivering(a).Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	delivered, err := dbGetRule(ctx, a, rule.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivered.LastTriggered != 1700000000 || delivered.LastValue != 4.5 {
+		t.Errorf("expected the rule to be marked as delivered, got %+v", delivered)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := parseRule(strings.NewReader(`{"kind":"sell-below","typeId":34,"regionId":10000002,"threshold":5,"webhook":"https://discord.com/api/webhooks/1/a","lastTriggered":12}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Cooldown != defaultCooldown || rule.LastTriggered != 0 {
+		t.Errorf("unexpected rule %v", rule)
+	}
+
+	invalid := []string{
+		`{"kind":"nope","typeId":34,"regionId":10000002,"threshold":5,"webhook":"https://a.b"}`,
+		`{"kind":"sell-below","regionId":10000002,"threshold":5,"webhook":"https://a.b"}`,
+		`{"kind":"sell-below","typeId":34,"regionId":10000002,"threshold":5,"webhook":"file:///etc/passwd"}`,
+		`{"kind":"sell-below","typeId":34,"regionId":10000002,"threshold":-1,"webhook":"https://a.b"}`,
+	}
+	for _, body := range invalid {
+		if _, err := parseRule(strings.NewReader(body)); err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+// Admin handler to manage the alert rules:
+//   - GET ?id= to get a rule or ?type= to list the rules, type is optional
+//   - POST with a json rule as body to create a rule
+//   - PUT ?id= with a json rule as body to replace a rule
+//   - DELETE ?id= to delete a rule
+//
+// NOTE: the handler is admin only because the store posts to the webhooks,
+// a public endpoint would let anyone make the store send requests anywhere.
+func CreateAdminHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		var id int64
+		var err error
+		if query.Get("id") != "" {
+			id, err = strconv.ParseInt(query.Get("id"), 10, 64)
+			if err != nil {
+				openapi.ParamError(w, "id", "is invalid integer")
+				return
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			var response any
+			if id != 0 {
+				rule, err := dbGetRule(timeoutCtx, a, id)
+				if err != nil {
+					log.Printf("Internal server error: %v", err)
+					http.Error(w, "Internal server error", 500)
+					return
+				}
+				if rule == nil {
+					http.Error(w, "Rule not found", 404)
+					return
+				}
+				response = rule
+			} else {
+				typeId, err := strconv.Atoi(query.Get("type"))
+				if err != nil && query.Get("type") != "" {
+					openapi.ParamError(w, "type", "is invalid integer")
+					return
+				}
+				rules, err := dbGetRules(timeoutCtx, a, typeId)
+				if err != nil {
+					log.Printf("Internal server error: %v", err)
+					http.Error(w, "Internal server error", 500)
+					return
+				}
+				response = rules
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(response)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+
+		case http.MethodPost:
+			rule, err := parseRule(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Bad request: %v", err), 400)
+				return
+			}
+			rule.Id, err = dbInsertRule(timeoutCtx, a, rule)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			log.Printf("Alert rule %d created", rule.Id)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(201)
+			err = json.NewEncoder(w).Encode(rule)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				return
+			}
+
+		case http.MethodPut:
+			if id == 0 {
+				openapi.ParamError(w, "id", "is required")
+				return
+			}
+			rule, err := parseRule(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Bad request: %v", err), 400)
+				return
+			}
+			rule.Id = id
+			ok, err := dbUpdateRule(timeoutCtx, a, rule)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			if !ok {
+				http.Error(w, "Rule not found", 404)
+				return
+			}
+			log.Printf("Alert rule %d updated", id)
+			w.WriteHeader(204)
+
+		case http.MethodDelete:
+			if id == 0 {
+				openapi.ParamError(w, "id", "is required")
+				return
+			}
+			ok, err := dbDeleteRule(timeoutCtx, a, id)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			if !ok {
+				http.Error(w, "Rule not found", 404)
+				return
+			}
+			log.Printf("Alert rule %d deleted", id)
+			w.WriteHeader(204)
+
+		default:
+			http.Error(w, "Method not allowed", 405)
+		}
+	}
+}
+
+// Parse and validate a rule, the id and the delivery state are ignored
+func parseRule(body io.Reader) (Rule, error) {
+	var rule Rule
+	err := json.NewDecoder(io.LimitReader(body, 1<<16)).Decode(&rule)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid json rule: %w", err)
+	}
+	rule.Id = 0
+	rule.LastTriggered = 0
+	rule.LastValue = 0
+
+	if !ValidKind(rule.Kind) {
+		return Rule{}, fmt.Errorf("kind must be %s, %s or %s", SellBelow, BuyAbove, SpreadAbove)
+	}
+	if rule.TypeId <= 0 {
+		return Rule{}, fmt.Errorf("typeId is required")
+	}
+	if rule.RegionId <= 0 {
+		return Rule{}, fmt.Errorf("regionId is required")
+	}
+	if rule.LocationId < 0 {
+		return Rule{}, fmt.Errorf("locationId must be positive")
+	}
+	if rule.Threshold <= 0 {
+		return Rule{}, fmt.Errorf("threshold must be positive")
+	}
+	webhook, err := url.Parse(rule.Webhook)
+	if err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") || webhook.Host == "" {
+		return Rule{}, fmt.Errorf("webhook must be an http or https url")
+	}
+	if rule.Cooldown < 0 {
+		return Rule{}, fmt.Errorf("cooldown must be positive")
+	}
+	if rule.Cooldown == 0 {
+		rule.Cooldown = defaultCooldown
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,139 @@
+package alerts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// typeId 0 to get all the rules
+func dbGetRules(ctx context.Context, a *app.App, typeId int) ([]Rule, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT Id, Kind, TypeId, RegionId, LocationId, Threshold, Webhook, Cooldown, LastTriggered, LastValue
+    FROM AlertRule
+    WHERE (? = 0 OR TypeId = ?)
+    ORDER BY Id;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, typeId, typeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]Rule, 0)
+	for rows.Next() {
+		var r Rule
+		err = rows.Scan(&r.Id, &r.Kind, &r.TypeId, &r.RegionId, &r.LocationId, &r.Threshold, &r.Webhook, &r.Cooldown, &r.LastTriggered, &r.LastValue)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// WARN: nillable return value
+func dbGetRule(ctx context.Context, a *app.App, id int64) (*Rule, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	selectQuery := `
+  SELECT Id, Kind, TypeId, RegionId, LocationId, Threshold, Webhook, Cooldown, LastTriggered, LastValue
+    FROM AlertRule WHERE Id = ?;
+  `
+	var r Rule
+	err := db.QueryRow(timeoutCtx, selectQuery, id).Scan(&r.Id, &r.Kind, &r.TypeId, &r.RegionId, &r.LocationId, &r.Threshold, &r.Webhook, &r.Cooldown, &r.LastTriggered, &r.LastValue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func dbInsertRule(ctx context.Context, a *app.App, r Rule) (int64, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	insertQuery := `
+  INSERT INTO AlertRule (Kind, TypeId, RegionId, LocationId, Threshold, Webhook, Cooldown, LastTriggered, LastValue)
+    VALUES (?,?,?,?,?,?,?,0,0)
+    RETURNING Id;
+  `
+	var id int64
+	err := db.ExecReturning(timeoutCtx, insertQuery, r.Kind, r.TypeId, r.RegionId, r.LocationId, r.Threshold, r.Webhook, r.Cooldown).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Updating a rule resets its delivery state. Returns false if the rule does
+// not exist.
+func dbUpdateRule(ctx context.Context, a *app.App, r Rule) (bool, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	updateQuery := `
+  UPDATE AlertRule
+    SET Kind = ?, TypeId = ?, RegionId = ?, LocationId = ?, Threshold = ?, Webhook = ?, Cooldown = ?, LastTriggered = 0, LastValue = 0
+    WHERE Id = ?;
+  `
+	result, err := db.Exec(timeoutCtx, updateQuery, r.Kind, r.TypeId, r.RegionId, r.LocationId, r.Threshold, r.Webhook, r.Cooldown, r.Id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// Returns false if the rule does not exist
+func dbDeleteRule(ctx context.Context, a *app.App, id int64) (bool, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(timeoutCtx, "DELETE FROM AlertRule WHERE Id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func dbSetRuleTriggered(ctx context.Context, a *app.App, id int64, t time.Time, value float64) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	updateQuery := "UPDATE AlertRule SET LastTriggered = ?, LastValue = ? WHERE Id = ?"
+	_, err := db.Exec(timeoutCtx, updateQuery, t.Unix(), value, id)
+	return err
+}
@@ -0,0 +1,298 @@
+// Anomalies are suspicious market behaviours that traders should be warned
+// about. They are stored in the Anomaly table and are of three kinds:
+//
+//   - price-spike: a history day with a highest or lowest price far from the
+//     average on a low volume, the trace of orders filled at silly prices
+//   - buy-above-history: a buy order priced far above the recent history, the
+//     classic margin trading scam
+//   - sell-wipe and buy-wipe: most of one side of an order book disappearing
+//     between two order snapshots
+//
+// The history anomalies are detected once a day by Detect after the global
+// histories computation. The order book wipes are detected by CheckOrders at
+// each orders download.
+
+package anomalies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
+)
+
+type dbOrder = shared.DbOrder
+type dbHistory = shared.DbHistory
+type historyDay = shared.DbHistoryDay
+
+type Anomaly struct {
+	Kind     string `json:"kind"`
+	TypeId   int    `json:"typeId"`
+	RegionId int    `json:"regionId"`
+	Time     int64  `json:"time"` // Epoch Seconds
+	// Value is the suspicious value and Reference the value it was expected to
+	// be close to
+	Value     float64 `json:"value"`
+	Reference float64 `json:"reference"`
+	Volume    int64   `json:"volume"`
+}
+
+const (
+	PriceSpike      = "price-spike"
+	BuyAboveHistory = "buy-above-history"
+	SellWipe        = "sell-wipe"
+	BuyWipe         = "buy-wipe"
+)
+
+// price-spike thresholds: highest above spikeRatio * average (or lowest below
+// average / spikeRatio) with a volume below lowVolumeRatio * the average
+// volume of the previous baselineDays days
+const spikeRatio = 3
+const lowVolumeRatio = 0.2
+const baselineDays = 20
+
+// buy-above-history threshold: best buy above buyAboveRatio * the highest price
+// of the last recentDays days
+const buyAboveRatio = 1.5
+const recentDays = 30
+
+// wipe thresholds: a side of at least minWipeOrders orders that keeps less
+// than wipeRatio of its orders and of its volume
+const minWipeOrders = 5
+const wipeRatio = 0.2
+
+// Anomalies are kept retention long
+const retention = 90 * 24 * time.Hour
+
+func ValidKind(kind string) bool {
+	return kind == PriceSpike || kind == BuyAboveHistory || kind == SellWipe || kind == BuyWipe
+}
+
+// Look for the history anomalies of the active markets and store them
+func Detect(ctx context.Context, a *app.App, day time.Time) error {
+	typeIds, err := activemarkets.GetTypesId(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	anomalies := make([]Anomaly, 0, 256)
+	for _, typeId := range typeIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		histories, err := shared.Histories(a).GetOfType(ctx, typeId)
+		if err != nil {
+			log.Printf("Anomalies: can't get histories of type %d: %v", typeId, err)
+			continue
+		}
+		bestBuys, err := dbGetBestBuysOfType(ctx, a, typeId)
+		if err != nil {
+			log.Printf("Anomalies: can't get best buys of type %d: %v", typeId, err)
+			continue
+		}
+
+		for _, history := range histories {
+			var days []historyDay
+			err = json.Unmarshal(history.History, &days)
+			if err != nil {
+				log.Printf("Anomalies: invalid history of type %d in region %d: %v", typeId, history.RegionId, err)
+				continue
+			}
+
+			anomaly, err := detectPriceSpike(typeId, history.RegionId, days)
+			if err != nil {
+				log.Printf("Anomalies: type %d in region %d: %v", typeId, history.RegionId, err)
+			} else if anomaly != nil {
+				anomalies = append(anomalies, *anomaly)
+			}
+
+			if bestBuy, ok := bestBuys[history.RegionId]; ok {
+				anomaly = detectBuyAboveHistory(typeId, history.RegionId, days, bestBuy, day)
+				if anomaly != nil {
+					anomalies = append(anomalies, *anomaly)
+				}
+			}
+		}
+	}
+
+	err = dbInsertAnomalies(ctx, a, anomalies)
+	if err != nil {
+		return fmt.Errorf("insert anomalies: %w", err)
+	}
+	err = dbDeleteAnomaliesBefore(ctx, a, day.Add(-retention))
+	if err != nil {
+		return fmt.Errorf("delete old anomalies: %w", err)
+	}
+
+	log.Printf("Anomalies: %d history anomalies detected", len(anomalies))
+	return nil
+}
+
+// WARN: nillable return value
+func detectPriceSpike(typeId int, regionId int, days []historyDay) (*Anomaly, error) {
+	// filled days are not trades and are skipped
+	last := len(days) - 1
+	for last >= 0 && days[last].Filled {
+		last--
+	}
+	if last < 1 {
+		return nil, nil
+	}
+	d := days[last]
+
+	var baselineVolume int64 = 0
+	tradedDays := 0
+	for _, b := range days[max(0, last-baselineDays):last] {
+		if !b.Filled {
+			baselineVolume += b.Volume
+			tradedDays++
+		}
+	}
+	if tradedDays == 0 {
+		return nil, nil
+	}
+	averageVolume := float64(baselineVolume) / float64(tradedDays)
+	if float64(d.Volume) > lowVolumeRatio*averageVolume {
+		return nil, nil
+	}
+
+	var value float64
+	if d.Highest > spikeRatio*d.Average {
+		value = d.Highest
+	} else if d.Lowest < d.Average/spikeRatio {
+		value = d.Lowest
+	} else {
+		return nil, nil
+	}
+
+	date, err := time.Parse(esi.DateLayout, d.Date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid history date: %w", err)
+	}
+	return &Anomaly{
+		Kind:      PriceSpike,
+		TypeId:    typeId,
+		RegionId:  regionId,
+		Time:      date.Unix(),
+		Value:     value,
+		Reference: d.Average,
+		Volume:    d.Volume,
+	}, nil
+}
+
+// WARN: nillable return value
+func detectBuyAboveHistory(typeId int, regionId int, days []historyDay, bestBuy float64, day time.Time) *Anomaly {
+	var reference float64 = 0
+	for _, d := range days[max(0, len(days)-recentDays):] {
+		if d.Volume > 0 && d.Highest > reference {
+			reference = d.Highest
+		}
+	}
+	// without recent trades there is no reference to compare with
+	if reference == 0 || bestBuy <= buyAboveRatio*reference {
+		return nil
+	}
+
+	return &Anomaly{
+		Kind:      BuyAboveHistory,
+		TypeId:    typeId,
+		RegionId:  regionId,
+		Time:      day.Unix(),
+		Value:     bestBuy,
+		Reference: reference,
+	}
+}
+
+type bookSide struct {
+	orders int
+	volume int64
+}
+
+type bookKey struct {
+	typeId   int
+	regionId int
+	isBuy    bool
+}
+
+// Sides of the order books of the last orders snapshot
+type lastBooks struct {
+	mu    sync.Mutex
+	books map[bookKey]bookSide
+}
+
+func getLastBooks(a *app.App) *lastBooks {
+	return app.State(a, "anomalies", func() *lastBooks { return &lastBooks{} })
+}
+
+// Compare the order books of orders with the ones of the previous call and
+// store the wipes. The first call after a restart only records the books.
+// The books of the regions that are no longer downloaded are forgotten.
+func CheckOrders(ctx context.Context, a *app.App, retrivalTime time.Time, downloaded []int, orders []dbOrder) error {
+	books := make(map[bookKey]bookSide, len(orders)/4)
+	for i := range orders {
+		o := &orders[i]
+		key := bookKey{o.TypeId, o.RegionId, o.IsBuyOrder}
+		side := books[key]
+		side.orders++
+		side.volume += int64(o.VolumeRemain)
+		books[key] = side
+	}
+
+	last := getLastBooks(a)
+	last.mu.Lock()
+	previousBooks := last.books
+	last.books = books
+	last.mu.Unlock()
+	if previousBooks == nil {
+		return nil
+	}
+	for key := range previousBooks {
+		if !slices.Contains(downloaded, key.regionId) {
+			delete(previousBooks, key)
+		}
+	}
+
+	anomalies := detectWipes(previousBooks, books, retrivalTime)
+	if len(anomalies) > 0 {
+		log.Printf("Anomalies: %d order book wipes detected", len(anomalies))
+	}
+	return dbInsertAnomalies(ctx, a, anomalies)
+}
+
+func detectWipes(previousBooks map[bookKey]bookSide, books map[bookKey]bookSide, retrivalTime time.Time) []Anomaly {
+	anomalies := make([]Anomaly, 0)
+	for key, previous := range previousBooks {
+		if previous.orders < minWipeOrders {
+			continue
+		}
+		current := books[key]
+		if float64(current.orders) >= wipeRatio*float64(previous.orders) ||
+			float64(current.volume) >= wipeRatio*float64(previous.volume) {
+			continue
+		}
+
+		kind := SellWipe
+		if key.isBuy {
+			kind = BuyWipe
+		}
+		anomalies = append(anomalies, Anomaly{
+			Kind:      kind,
+			TypeId:    key.typeId,
+			RegionId:  key.regionId,
+			Time:      retrivalTime.Unix(),
+			Value:     float64(current.volume),
+			Reference: float64(previous.volume),
+			Volume:    previous.volume - current.volume,
+		})
+	}
+	return anomalies
+}
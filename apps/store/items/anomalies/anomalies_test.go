@@ -0,0 +1,153 @@
+package anomalies
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+)
+
+func makeDays(n int, average float64, volume int64) []historyDay {
+	days := make([]historyDay, n)
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range days {
+		days[i] = historyDay{
+			Average: average,
+			Date:    date.AddDate(0, 0, i).Format("2006-01-02"),
+			Highest: average * 1.1,
+			Lowest:  average * 0.9,
+			Volume:  volume,
+		}
+	}
+	return days
+}
+
+func TestPriceSpike(t *testing.T) {
+	days := makeDays(25, 100, 1000)
+	anomaly, err := detectPriceSpike(34, 10000002, days)
+	if err != nil || anomaly != nil {
+		t.Fatalf("expected no anomaly, got %v %v", anomaly, err)
+	}
+
+	days[24].Highest = 500
+	days[24].Volume = 10
+	anomaly, err = detectPriceSpike(34, 10000002, days)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anomaly == nil || anomaly.Kind != PriceSpike || anomaly.Value != 500 || anomaly.Reference != 100 {
+		t.Fatalf("expected a price spike of 500, got %v", anomaly)
+	}
+
+	// the spike is still found behind filled days
+	days = append(days, historyDay{Average: 100, Date: "2024-01-26", Highest: 100, Lowest: 100, Filled: true})
+	anomaly, _ = detectPriceSpike(34, 10000002, days)
+	if anomaly == nil || anomaly.Time != time.Date(2024, 1, 25, 0, 0, 0, 0, time.UTC).Unix() {
+		t.Fatalf("expected the spike of 2024-01-25, got %v", anomaly)
+	}
+
+	// filled days are left out of the baseline volume
+	for i := 10; i < 24; i++ {
+		days[i] = historyDay{Average: 100, Date: days[i].Date, Highest: 100, Lowest: 100, Filled: true}
+	}
+	days[24].Volume = 150
+	anomaly, _ = detectPriceSpike(34, 10000002, days)
+	if anomaly == nil {
+		t.Fatalf("expected the spike of 2024-01-25 behind filled baseline days")
+	}
+
+	// a high volume day is a real move
+	days[24].Volume = 1000
+	anomaly, _ = detectPriceSpike(34, 10000002, days)
+	if anomaly != nil {
+		t.Fatalf("expected no anomaly on a high volume day, got %v", anomaly)
+	}
+}
+
+func TestBuyAboveHistory(t *testing.T) {
+	day := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	days := makeDays(40, 100, 1000)
+
+	if a := detectBuyAboveHistory(34, 10000002, days, 150, day); a != nil {
+		t.Fatalf("expected no anomaly, got %v", a)
+	}
+	a := detectBuyAboveHistory(34, 10000002, days, 200, day)
+	if a == nil || a.Kind != BuyAboveHistory || a.Reference != days[39].Highest {
+		t.Fatalf("expected a buy above history anomaly, got %v", a)
+	}
+
+	// old trades are not a reference
+	for i := 10; i < 40; i++ {
+		days[i].Volume = 0
+	}
+	if a := detectBuyAboveHistory(34, 10000002, days, 200, day); a != nil {
+		t.Fatalf("expected no anomaly without recent trades, got %v", a)
+	}
+}
+
+func TestWipes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	sell := bookKey{34, 10000002, false}
+	buy := bookKey{34, 10000002, true}
+	small := bookKey{35, 10000002, false}
+	previous := map[bookKey]bookSide{
+		sell:  {orders: 10, volume: 1000},
+		buy:   {orders: 10, volume: 1000},
+		small: {orders: 2, volume: 1000},
+	}
+	current := map[bookKey]bookSide{
+		sell: {orders: 1, volume: 50},
+		// a single big order is left, it's not a wipe
+		buy: {orders: 1, volume: 900},
+	}
+
+	anomalies := detectWipes(previous, current, now)
+	if len(anomalies) != 1 {
+		t.Fatalf("expected 1 wipe, got %s", fmt.Sprint(anomalies))
+	}
+	a := anomalies[0]
+	if a.Kind != SellWipe || a.Volume != 950 || a.Time != now.Unix() {
+		t.Fatalf("unexpected wipe %v", a)
+	}
+}
+
+func TestCheckOrdersDroppedRegion(t *testing.T) {
+	a, ctx := apptest.New(t)
+	db := a.DB
+
+	orders := make([]dbOrder, 0, 2*minWipeOrders)
+	for i := 0; i < 2*minWipeOrders; i++ {
+		regionId := 10000002
+		if i%2 == 1 {
+			regionId = 10000043
+		}
+		orders = append(orders, dbOrder{OrderId: i, RegionId: regionId, TypeId: 34, VolumeRemain: 100})
+	}
+	now := time.Unix(1700000000, 0)
+	err := CheckOrders(ctx, a, now, []int{10000002, 10000043}, orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the domain is no longer downloaded, its book is not wiped
+	forgeOrders := make([]dbOrder, 0, minWipeOrders)
+	for _, o := range orders {
+		if o.RegionId == 10000002 {
+			forgeOrders = append(forgeOrders, o)
+		}
+	}
+	err = CheckOrders(ctx, a, now.Add(time.Minute), []int{10000002}, forgeOrders)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	err = db.QueryRow(ctx, "SELECT COUNT(*) FROM Anomaly").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no wipe, got %d anomalies", count)
+	}
+}
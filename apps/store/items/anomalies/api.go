@@ -0,0 +1,98 @@
+package anomalies
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+// Serve /anomalies?type=&region=&kind=&since=&limit=
+// All params are optional. since is in epoch seconds and defaults to 7 days
+// ago.
+func CreateHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		q := anomalyQuery{
+			kind:  query.Get("kind"),
+			since: a.Now().Add(-7 * 24 * time.Hour),
+			limit: 100,
+		}
+		var err error
+		if query.Get("type") != "" {
+			q.typeId, err = strconv.Atoi(query.Get("type"))
+			if err != nil {
+				openapi.ParamError(w, "type", "is invalid integer")
+				return
+			}
+		}
+		if query.Get("region") != "" {
+			q.regionId, err = strconv.Atoi(query.Get("region"))
+			if err != nil {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+		}
+		if q.kind != "" && !ValidKind(q.kind) {
+			openapi.ParamError(w, "kind", "must be price-spike, buy-above-history, sell-wipe or buy-wipe")
+			return
+		}
+		if query.Get("since") != "" {
+			since, err := strconv.ParseInt(query.Get("since"), 10, 64)
+			if err != nil {
+				openapi.ParamError(w, "since", "is invalid epoch seconds")
+				return
+			}
+			q.since = time.Unix(since, 0)
+		}
+		if query.Get("limit") != "" {
+			q.limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || q.limit <= 0 || q.limit > 1000 {
+				openapi.ParamError(w, "limit", "must be an integer between 1 and 1000")
+				return
+			}
+		}
+
+		anomalies, err := dbGetAnomalies(timeoutCtx, a, q)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(anomalies)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	return []openapi.Operation{
+		{
+			Path:    "/anomalies",
+			Summary: "Suspicious market behaviours, most recent first",
+			Params: []openapi.Param{
+				{Name: "type", Type: "integer", Description: "Type id"},
+				{Name: "region", Type: "integer", Description: "Region id"},
+				{Name: "kind", Type: "string", Enum: []string{PriceSpike, BuyAboveHistory, SellWipe, BuyWipe}},
+				{Name: "since", Type: "integer", Description: "Epoch seconds, 7 days ago by default"},
+				{Name: "limit", Type: "integer", Description: "Between 1 and 1000, 100 by default"},
+			},
+			Response: []Anomaly{},
+			Handler:  CreateHandler(ctx, a),
+		},
+	}
+}
@@ -0,0 +1,145 @@
+package anomalies
+
+import (
+	"context"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// Highest buy order price of typeId by region
+func dbGetBestBuysOfType(ctx context.Context, a *app.App, typeId int) (map[int]float64, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT RegionId, MAX(Price) FROM "Order"
+    WHERE TypeId = ? AND IsBuyOrder = 1
+    GROUP BY RegionId;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, typeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bestBuys := make(map[int]float64)
+	for rows.Next() {
+		var regionId int
+		var price float64
+		err = rows.Scan(&regionId, &price)
+		if err != nil {
+			return nil, err
+		}
+		bestBuys[regionId] = price
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return bestBuys, nil
+}
+
+// Anomalies already stored are ignored
+func dbInsertAnomalies(ctx context.Context, a *app.App, anomalies []Anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+  INSERT INTO Anomaly (Kind, TypeId, RegionId, Time, Value, Reference, Volume)
+    VALUES (?,?,?,?,?,?,?)
+    ON CONFLICT DO NOTHING;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, insertQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, a := range anomalies {
+		_, err = stmt.Exec(timeoutCtx, a.Kind, a.TypeId, a.RegionId, a.Time, a.Value, a.Reference, a.Volume)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func dbDeleteAnomaliesBefore(ctx context.Context, a *app.App, before time.Time) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	_, err := db.Exec(timeoutCtx, "DELETE FROM Anomaly WHERE Time < ?", before.Unix())
+	return err
+}
+
+type anomalyQuery struct {
+	typeId   int    // 0 to disable the filter
+	regionId int    // 0 to disable the filter
+	kind     string // empty to disable the filter
+	since    time.Time
+	limit    int
+}
+
+// Most recent anomalies first
+func dbGetAnomalies(ctx context.Context, a *app.App, q anomalyQuery) ([]Anomaly, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	anomalies := make([]Anomaly, 0, q.limit)
+
+	selectQuery := `
+  SELECT Kind, TypeId, RegionId, Time, Value, Reference, Volume FROM Anomaly
+    WHERE (? = 0 OR TypeId = ?)
+    AND (? = 0 OR RegionId = ?)
+    AND (? = '' OR Kind = ?)
+    AND Time >= ?
+    ORDER BY Time DESC
+    LIMIT ?;
+  `
+	rows, err := db.Query(
+		timeoutCtx,
+		selectQuery,
+		q.typeId, q.typeId,
+		q.regionId, q.regionId,
+		q.kind, q.kind,
+		q.since.Unix(),
+		q.limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Anomaly
+		err = rows.Scan(&a.Kind, &a.TypeId, &a.RegionId, &a.Time, &a.Value, &a.Reference, &a.Volume)
+		if err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
@@ -0,0 +1,142 @@
+// Api keys identify the clients of the tcp server. The keys are created with
+// the apikey command of the store and only their sha256 is kept in db.
+//
+// The requests of the tcp server go through the Middleware that rate limits
+// them with token buckets, per key for the requests with a key and per ip for
+// the others, and counts the requests and their duration of each key.
+
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type Key struct {
+	Name    string
+	Rate    float64 // Requests per second, 0 for the default rate
+	Burst   int     // 0 for the default burst
+	Created int64   // Epoch Seconds
+	hash    string
+}
+
+// The keys are reloaded periodically as they are managed by another process
+const keysTTL = time.Minute
+
+// Delay before the next reload when a reload fails
+const keysRetry = 5 * time.Second
+
+type keyCache struct {
+	mu      sync.Mutex
+	keys    map[string]Key // by hash, nil until the first load
+	loaded  time.Time      // time of the next reload minus keysTTL
+	loading chan struct{}  // closed at the end of the reload, nil if none runs
+}
+
+func getKeyCache(a *app.App) *keyCache {
+	return app.State(a, "apikeys", func() *keyCache { return &keyCache{} })
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create a key named name and return it. This is the only time the key is
+// known.
+func Add(ctx context.Context, a *app.App, name string, rate float64, burst int) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("empty key name")
+	}
+	if rate < 0 || burst < 0 {
+		return "", fmt.Errorf("negative rate or burst")
+	}
+	secret := make([]byte, 24)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("generating key: %w", err)
+	}
+	key := "emb_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	err = dbAddKey(ctx, a, Key{
+		Name:    name,
+		Rate:    rate,
+		Burst:   burst,
+		Created: a.Now().Unix(),
+		hash:    hashKey(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("adding key: %w", err)
+	}
+	return key, nil
+}
+
+func List(ctx context.Context, a *app.App) ([]Key, error) {
+	return dbGetKeys(ctx, a)
+}
+
+// ok is false if there is no key with that name
+func Revoke(ctx context.Context, a *app.App, name string) (ok bool, err error) {
+	return dbDeleteKey(ctx, a, name)
+}
+
+// WARN: nillable return value, nil if the key is unknown
+func lookup(ctx context.Context, a *app.App, key string) *Key {
+	cache := getKeyCache(a)
+	cache.mu.Lock()
+	loading := cache.loading
+	if loading == nil && a.Now().Sub(cache.loaded) > keysTTL {
+		loading = make(chan struct{})
+		cache.loading = loading
+		cache.mu.Unlock()
+		cache.reload(ctx, a)
+		cache.mu.Lock()
+	} else if loading != nil && cache.keys == nil {
+		// NOTE: the other lookups wait for the first load only, then they use
+		// the previous keys during the reloads
+		cache.mu.Unlock()
+		select {
+		case <-loading:
+		case <-ctx.Done():
+		}
+		cache.mu.Lock()
+	}
+	k, ok := cache.keys[hashKey(key)]
+	cache.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return &k
+}
+
+// The db is queried without the lock of the cache. The previous keys are kept
+// if the query fails and the reload is retried after keysRetry.
+func (cache *keyCache) reload(ctx context.Context, a *app.App) {
+	list, err := dbGetKeys(ctx, a)
+	now := a.Now()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if err != nil {
+		log.Printf("Api keys: %v", err)
+		cache.loaded = now.Add(keysRetry - keysTTL)
+	} else {
+		cache.keys = make(map[string]Key, len(list))
+		for _, k := range list {
+			cache.keys[k.hash] = k
+		}
+		cache.loaded = now
+	}
+	close(cache.loading)
+	cache.loading = nil
+}
@@ -0,0 +1,152 @@
+package apikeys
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter()
+	now := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", 1, 3, now); !ok {
+			t.Fatalf("request %d of the burst was limited", i)
+		}
+	}
+	ok, retryAfter := l.allow("a", 1, 3, now)
+	if ok || retryAfter != time.Second {
+		t.Errorf("expected the 4th request to wait 1s, got %v %v", ok, retryAfter)
+	}
+	if ok, _ := l.allow("b", 1, 3, now); !ok {
+		t.Error("the clients should have their own bucket")
+	}
+	if ok, _ := l.allow("a", 1, 3, now.Add(time.Second)); !ok {
+		t.Error("a token should be refilled after 1s")
+	}
+
+	// the full buckets are forgotten
+	l.allow("c", 1, 3, now.Add(time.Hour))
+	if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+		t.Errorf("expected only the bucket c to remain, got %v", l.buckets)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	a := app.New(nil, nil, config.Config{Api: config.Api{IpRate: 1, IpBurst: 2}})
+	cache := getKeyCache(a)
+	cache.keys = map[string]Key{hashKey("emb_test"): {Name: "test", Rate: 1, Burst: 1}}
+	cache.loaded = a.Now()
+	handler := Middleware(context.Background(), a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	request := func(key string) int {
+		return requestFrom(handler, key, "192.0.2.1:1234", nil)
+	}
+
+	for i, want := range []int{200, 200, 429} {
+		if code := request(""); code != want {
+			t.Errorf("anonymous request %d: got %d, want %d", i, code, want)
+		}
+	}
+	for i, want := range []int{200, 429} {
+		if code := request("emb_test"); code != want {
+			t.Errorf("key request %d: got %d, want %d", i, code, want)
+		}
+	}
+	// the invalid keys count in the limit of the ip
+	if code := request("emb_unknown"); code != 429 {
+		t.Errorf("unknown key of a limited ip: got %d, want 429", code)
+	}
+	for i, want := range []int{401, 401, 429} {
+		if code := requestFrom(handler, "emb_unknown", "192.0.2.2:1234", nil); code != want {
+			t.Errorf("unknown key %d: got %d, want %d", i, code, want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	a, ctx := apptest.New(t)
+	now := time.Unix(1700000000, 0)
+	a.Clock = func() time.Time { return now }
+
+	first, err := Add(ctx, a, "first", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup(ctx, a, first) == nil {
+		t.Fatal("expected the first key to be found")
+	}
+
+	// the previous keys are kept when the reload fails
+	_, err = a.DB.Exec(ctx, "ALTER TABLE ApiKey RENAME TO ApiKeyMoved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(keysTTL + time.Second)
+	if lookup(ctx, a, first) == nil {
+		t.Fatal("expected the first key to be kept after a failed reload")
+	}
+
+	// and the reload is retried without waiting for the ttl
+	_, err = a.DB.Exec(ctx, "ALTER TABLE ApiKeyMoved RENAME TO ApiKey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Add(ctx, a, "second", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(keysRetry + time.Second)
+	if lookup(ctx, a, second) == nil {
+		t.Fatal("expected the second key to be found after the retry")
+	}
+}
+
+func requestFrom(handler http.Handler, key string, remoteAddr string, header http.Header) int {
+	r := httptest.NewRequest("GET", "/order", nil)
+	r.RemoteAddr = remoteAddr
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	if key != "" {
+		r.Header.Set("X-Api-Key", key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestClientIp(t *testing.T) {
+	proxies := parseProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	tests := []struct {
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"198.51.100.7:1234", nil, "198.51.100.7"},
+		{"198.51.100.7:1234", http.Header{"X-Forwarded-For": {"203.0.113.9"}}, "198.51.100.7"},
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", http.Header{"X-Real-Ip": {"203.0.113.9"}}, "203.0.113.9"},
+		{"192.0.2.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1, 203.0.113.9, 10.1.2.3"}}, "203.0.113.9"},
+		{"192.0.2.1:1234", http.Header{"X-Forwarded-For": {"1.1.1.1", "203.0.113.9"}}, "203.0.113.9"},
+		{"192.0.2.1:1234", http.Header{"X-Forwarded-For": {"10.1.2.3"}}, "10.1.2.3"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/order", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header = test.header
+		if r.Header == nil {
+			r.Header = http.Header{}
+		}
+		if ip := clientIp(r, proxies); ip != test.want {
+			t.Errorf("%s %v: got %s, want %s", test.remoteAddr, test.header, ip, test.want)
+		}
+	}
+}
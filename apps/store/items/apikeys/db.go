@@ -0,0 +1,64 @@
+package apikeys
+
+import (
+	"context"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+func dbGetKeys(ctx context.Context, a *app.App) ([]Key, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := db.Query(timeoutCtx, "SELECT Name, Hash, Rate, Burst, Created FROM ApiKey ORDER BY Name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]Key, 0)
+	for rows.Next() {
+		var k Key
+		err = rows.Scan(&k.Name, &k.hash, &k.Rate, &k.Burst, &k.Created)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func dbAddKey(ctx context.Context, a *app.App, k Key) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	insertQuery := "INSERT INTO ApiKey (Name, Hash, Rate, Burst, Created) VALUES (?,?,?,?,?)"
+	_, err := db.Exec(timeoutCtx, insertQuery, k.Name, k.hash, k.Rate, k.Burst, k.Created)
+	return err
+}
+
+// ok is false if there is no key with that name
+func dbDeleteKey(ctx context.Context, a *app.App, name string) (ok bool, err error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := db.Exec(timeoutCtx, "DELETE FROM ApiKey WHERE Name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,155 @@
+package apikeys
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/victoria"
+)
+
+// Authenticate, rate limit and count the requests according to the api
+// config
+func Middleware(ctx context.Context, a *app.App, next http.Handler) http.Handler {
+	policy := a.Config.Api
+	proxies := parseProxies(policy.TrustedProxies)
+	l := newLimiter()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		now := a.Now()
+		name := "anonymous"
+		var ok bool
+		var retryAfter time.Duration
+
+		ip := clientIp(r, proxies)
+		if key := requestKey(r); key != "" {
+			timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+			k := lookup(timeoutCtx, a, key)
+			cancel()
+			if k == nil {
+				// the invalid keys count in the ip limit to slow down guessing
+				ok, retryAfter = l.allow("ip:"+ip, policy.IpRate, policy.IpBurst, now)
+				if !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					http.Error(w, "Too many requests", 429)
+					return
+				}
+				http.Error(w, "Unauthorized: invalid api key", 401)
+				return
+			}
+			name = k.Name
+			rate, burst := k.Rate, k.Burst
+			if rate == 0 {
+				rate = policy.KeyRate
+			}
+			if burst == 0 {
+				burst = policy.KeyBurst
+			}
+			ok, retryAfter = l.allow("key:"+k.Name, rate, burst, now)
+		} else {
+			if policy.KeyRequired {
+				http.Error(w, "Unauthorized: api key required", 401)
+				return
+			}
+			ok, retryAfter = l.allow("ip:"+ip, policy.IpRate, policy.IpBurst, now)
+		}
+
+		label := victoria.Escape(name)
+		if !ok {
+			metrics.GetOrCreateCounter(fmt.Sprintf(`store_api_rate_limited_total{key="%s"}`, label)).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too many requests", 429)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+
+		metrics.GetOrCreateCounter(fmt.Sprintf(`store_api_request_total{key="%s"}`, label)).Inc()
+		metrics.GetOrCreateFloatCounter(fmt.Sprintf(`store_api_request_duration{key="%s"}`, label)).Add(time.Since(start).Seconds())
+	})
+}
+
+// NOTE: the config is validated, invalid entries are ignored
+func parseProxies(trusted []string) []netip.Prefix {
+	proxies := make([]netip.Prefix, 0, len(trusted))
+	for _, proxy := range trusted {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies
+}
+
+func isTrusted(ip string, proxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ip of the client. The X-Forwarded-For and X-Real-IP headers are only read
+// from the trusted proxies, the client ip is the last address of
+// X-Forwarded-For that is not a trusted proxy.
+func clientIp(r *http.Request, proxies []netip.Prefix) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrusted(ip, proxies) {
+		return ip
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if realIp := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIp != "" {
+			return realIp
+		}
+		return ip
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrusted(hop, proxies) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// The key is read from the X-Api-Key header or the bearer token.
+// NOTE: the key is never read from the url as it ends up in the logs
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package backups
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type apiSnapshot struct {
+	Name string `json:"name"`
+	Time int64  `json:"time"`
+	Size int64  `json:"size"`
+}
+
+// Admin handler to list the snapshots with GET and to take a snapshot with
+// POST. The restore is only available from the command line as the store must
+// be stopped.
+func CreateAdminHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	dir, keep := a.Config.Backups.Dir, a.Config.Backups.Keep
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var snapshots []Snapshot
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			snapshots, err = List(dir)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+
+		case http.MethodPost:
+			s, err := Create(ctx, a, dir, keep)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			log.Printf("Backup %s created", s.Name)
+			snapshots = []Snapshot{s}
+
+		default:
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+
+		apiSnapshots := make([]apiSnapshot, len(snapshots))
+		for i, s := range snapshots {
+			apiSnapshots[i] = apiSnapshot{Name: s.Name, Time: s.Time.Unix(), Size: s.Size}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(apiSnapshots)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Rotated gzip snapshots of the database made with VACUUM INTO. Each snapshot
+// is checked with PRAGMA integrity_check before it is kept.
+
+package backups
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+type Snapshot struct {
+	Name string
+	Time time.Time
+	Size int64 // Compressed size in bytes
+	seq  int   // Order of the snapshots made in the same second
+}
+
+const snapshotPrefix = "store-"
+const snapshotSuffix = ".db.gz"
+const snapshotTimeLayout = "20060102-150405"
+
+var ErrInvalidSnapshot = errors.New("Invalid snapshot name")
+var ErrPreviousRestore = errors.New("the database kept by a previous restore must be moved away first")
+
+// Only one backup at a time, the worker and the admin handler share it
+type backupLock struct {
+	mu sync.Mutex
+}
+
+func getBackupLock(a *app.App) *backupLock {
+	return app.State(a, "backups", func() *backupLock { return &backupLock{} })
+}
+
+// Snapshot the database into dir and remove the oldest snapshots to keep
+// only keep of them. Returns the new snapshot.
+func Create(ctx context.Context, a *app.App, dir string, keep int) (Snapshot, error) {
+	lock := getBackupLock(a)
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	now := a.Now().UTC()
+	// NOTE: the snapshots made in the same second are numbered
+	seq := 1
+	name := snapshotName(now, seq)
+	for {
+		_, err = os.Lstat(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return Snapshot{}, err
+		}
+		seq++
+		name = snapshotName(now, seq)
+	}
+	tmpPath := filepath.Join(dir, name+".tmp")
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	err = a.DB.VacuumInto(timeoutCtx, tmpPath)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("vacuum into: %w", err)
+	}
+	err = database.CheckIntegrity(ctx, tmpPath)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	size, err := compress(tmpPath, filepath.Join(dir, name))
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("compress: %w", err)
+	}
+
+	snapshots, err := List(dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for keep > 0 && len(snapshots) > keep {
+		err = os.Remove(filepath.Join(dir, snapshots[0].Name))
+		if err != nil {
+			log.Printf("Backups: can't remove old snapshot: %v", err)
+		}
+		snapshots = snapshots[1:]
+	}
+
+	return Snapshot{Name: name, Time: now.Truncate(time.Second), Size: size, seq: seq}, nil
+}
+
+// Snapshots of dir from oldest to newest
+func List(dir string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		t, seq, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Name: e.Name(), Time: t, Size: info.Size(), seq: seq})
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		return a.seq - b.seq
+	})
+	return snapshots, nil
+}
+
+// Replace the database at dbPath by the snapshot name of dir. The current
+// database is kept as dbPath.old. The restore is refused while the database
+// is open and while the database kept by a previous restore is there.
+// WARN: the store must be stopped
+func Restore(ctx context.Context, dir string, name string, dbPath string) error {
+	if _, _, ok := parseName(name); !ok {
+		return ErrInvalidSnapshot
+	}
+	_, err := os.Lstat(dbPath + ".old")
+	if err == nil {
+		return ErrPreviousRestore
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = database.CheckUnused(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := dbPath + ".restore"
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	err = decompress(filepath.Join(dir, name), tmpPath)
+	if err != nil {
+		return fmt.Errorf("decompress: %w", err)
+	}
+	err = database.CheckIntegrity(ctx, tmpPath)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: the wal of the current database must not be applied to the
+	// snapshot
+	err = os.Rename(dbPath, dbPath+".old")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Rename(dbPath+suffix, dbPath+".old"+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(tmpPath, dbPath)
+}
+
+// store-20060102-150405.db.gz, then store-20060102-150405-2.db.gz for the
+// second snapshot of the same second
+func snapshotName(t time.Time, seq int) string {
+	if seq <= 1 {
+		return snapshotPrefix + t.Format(snapshotTimeLayout) + snapshotSuffix
+	}
+	return fmt.Sprintf("%s%s-%d%s", snapshotPrefix, t.Format(snapshotTimeLayout), seq, snapshotSuffix)
+}
+
+func parseName(name string) (t time.Time, seq int, ok bool) {
+	if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+		return time.Time{}, 0, false
+	}
+	stamp := name[len(snapshotPrefix) : len(name)-len(snapshotSuffix)]
+	seq = 1
+	if len(stamp) > len(snapshotTimeLayout) {
+		if stamp[len(snapshotTimeLayout)] != '-' {
+			return time.Time{}, 0, false
+		}
+		var err error
+		seq, err = strconv.Atoi(stamp[len(snapshotTimeLayout)+1:])
+		if err != nil || seq < 2 {
+			return time.Time{}, 0, false
+		}
+		stamp = stamp[:len(snapshotTimeLayout)]
+	}
+	t, err := time.Parse(snapshotTimeLayout, stamp)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return t, seq, snapshotName(t, seq) == name
+}
+
+func compress(src string, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return 0, err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, err
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func decompress(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, zr)
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
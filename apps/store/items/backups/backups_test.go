@@ -0,0 +1,104 @@
+package backups
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+func TestBackupRestore(t *testing.T) {
+	tmp := t.TempDir()
+	dbPath := filepath.Join(tmp, "db.sqlite")
+	dir := filepath.Join(tmp, "backups")
+	a, ctx := apptest.NewWithOptions(t, apptest.Options{DbPath: dbPath})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.Clock = func() time.Time { return now }
+
+	_, err := a.DB.Exec(ctx, `INSERT INTO TimeRecord VALUES ('Test', 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last Snapshot
+	for i := 0; i < 3; i++ {
+		last, err = Create(ctx, a, dir, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+	snapshots, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1] != last {
+		t.Fatalf("expected the 2 last snapshots, got %v", snapshots)
+	}
+
+	// restore over a database without the test record
+	restoredPath := filepath.Join(tmp, "restored.sqlite")
+	restoredDb, err := database.Init(restoredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredDb.Close()
+	err = Restore(ctx, dir, last.Name, restoredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredDb, err = database.Open(restoredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredDb.Close()
+	var recordTime int
+	err = restoredDb.QueryRow(ctx, `SELECT Time FROM TimeRecord WHERE "Key" = 'Test'`).Scan(&recordTime)
+	if err != nil || recordTime != 1 {
+		t.Errorf("expected the restored record, got %d %v", recordTime, err)
+	}
+
+	if err = Restore(ctx, dir, "../db.sqlite", restoredPath); err != ErrInvalidSnapshot {
+		t.Errorf("expected an invalid snapshot error, got %v", err)
+	}
+	// the database kept by the first restore is not overwritten
+	if err = Restore(ctx, dir, last.Name, restoredPath); err != ErrPreviousRestore {
+		t.Errorf("expected a previous restore error, got %v", err)
+	}
+	// the database of the running store is not replaced
+	if err = Restore(ctx, dir, last.Name, dbPath); err != database.ErrInUse {
+		t.Errorf("expected a database in use error, got %v", err)
+	}
+}
+
+func TestSnapshotsSameSecond(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	a, ctx := apptest.New(t)
+	a.Clock = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+
+	names := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		snapshot, err := Create(ctx, a, dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, snapshot.Name)
+	}
+	snapshots, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("expected 3 snapshots, got %v", snapshots)
+	}
+	for i, s := range snapshots {
+		if s.Name != names[i] {
+			t.Errorf("snapshot %d: got %s, want %s", i, s.Name, names[i])
+		}
+	}
+	if names[1] != "store-20240101-120000-2.db.gz" {
+		t.Errorf("unexpected name %s", names[1])
+	}
+}
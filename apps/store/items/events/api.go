@@ -0,0 +1,115 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+const keepaliveInterval = 30 * time.Second
+
+// Serve /events?type=&region= as a server-sent events stream. Both params are
+// optional, region filters the orders and market events and type subscribes
+// to the market events of that type. The stream starts with the last orders
+// event so that clients know how fresh the orders are.
+func CreateHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	h := getHub(a)
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var typeId, regionId int
+		var err error
+		if query.Get("type") != "" {
+			typeId, err = strconv.Atoi(query.Get("type"))
+			if err != nil {
+				openapi.ParamError(w, "type", "is invalid integer")
+				return
+			}
+		}
+		if query.Get("region") != "" {
+			regionId, err = strconv.Atoi(query.Get("region"))
+			if err != nil {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			log.Print("Internal server error: response writer does not support flushing")
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		s := h.subscribe(typeId, regionId)
+		if s == nil {
+			http.Error(w, "Too many event subscribers", 503)
+			return
+		}
+		defer h.unsubscribe(s)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
+		w.WriteHeader(200)
+
+		last := LastOrders(a)
+		if last.Time != 0 {
+			err = writeEvent(w, last)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepalive := time.NewTicker(keepaliveInterval)
+		defer keepalive.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				_, err = fmt.Fprint(w, ": keepalive\n\n")
+			case e := <-s.ch:
+				err = writeEvent(w, e)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+	return err
+}
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	return []openapi.Operation{
+		{
+			Path:    "/events",
+			Summary: "Server-sent events announcing fresh data, the data of each event is an Event",
+			Params: []openapi.Param{
+				{Name: "type", Type: "integer", Description: "Subscribe to the market events of a type"},
+				{Name: "region", Type: "integer", Description: "Only receive the orders and market events of a region"},
+			},
+			ContentType: "text/event-stream",
+			Response:    Event{},
+			Handler:     CreateHandler(ctx, a),
+		},
+	}
+}
@@ -0,0 +1,241 @@
+// The events are announcements of fresh data streamed to the clients with
+// server-sent events on /events so that they don't need to poll:
+//
+//   - orders: the orders of a region were replaced
+//   - histories: the daily histories run finished
+//   - market: the order book of a type in a region changed, only sent to the
+//     clients subscribed to that type
+//
+// The orders and histories events are published by the hoardlings and the
+// market events by orders.Download.
+
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type dbOrder = shared.DbOrder
+
+type Event struct {
+	Kind     string `json:"kind"`
+	Time     int64  `json:"time"` // Epoch Seconds
+	RegionId int    `json:"regionId,omitempty"`
+	TypeId   int    `json:"typeId,omitempty"`
+	Date     string `json:"date,omitempty"` // histories only
+	// market only
+	BestBuy    float64 `json:"bestBuy,omitempty"`
+	BestSell   float64 `json:"bestSell,omitempty"`
+	OrderCount int     `json:"orderCount,omitempty"`
+}
+
+const (
+	OrdersEvent    = "orders"
+	HistoriesEvent = "histories"
+	MarketEvent    = "market"
+)
+
+// Events are dropped for the subscribers that don't read them fast enough
+const subscriberBuffer = 256
+const maxSubscribers = 1000
+
+var droppedEvents = metrics.NewCounter("store_events_dropped_total")
+
+type subscriber struct {
+	ch       chan Event
+	typeId   int // 0 to disable the filter
+	regionId int // 0 to disable the filter
+}
+
+type marketKey struct {
+	typeId   int
+	regionId int
+}
+
+type marketSummary struct {
+	bestBuy    float64
+	bestSell   float64
+	orderCount int
+	volume     int64
+}
+
+// Subscribers of the events of an app
+type hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	lastOrders  Event
+	// Summaries of the subscribed markets at the last orders download
+	lastMarkets map[marketKey]marketSummary
+}
+
+// Subscribers of all the apps of the process
+var subscriberCount atomic.Int64
+
+func init() {
+	metrics.NewGauge("store_events_subscribers", func() float64 {
+		return float64(subscriberCount.Load())
+	})
+}
+
+func newHub() *hub {
+	return &hub{
+		subscribers: make(map[*subscriber]struct{}),
+		lastMarkets: make(map[marketKey]marketSummary),
+	}
+}
+
+func getHub(a *app.App) *hub {
+	return app.State(a, "events", newHub)
+}
+
+// WARN: nillable return value, nil if there are too many subscribers
+func (h *hub) subscribe(typeId int, regionId int) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) >= maxSubscribers {
+		return nil
+	}
+	s := &subscriber{
+		ch:       make(chan Event, subscriberBuffer),
+		typeId:   typeId,
+		regionId: regionId,
+	}
+	h.subscribers[s] = struct{}{}
+	subscriberCount.Add(1)
+	return s
+}
+
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		subscriberCount.Add(-1)
+	}
+}
+
+// Last orders event, its time is 0 before the first orders download
+func LastOrders(a *app.App) Event {
+	h := getHub(a)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastOrders
+}
+
+// Subscribe to the events from outside of the package, used by the gRPC order
+// streams. ok is false if there are too many subscribers.
+func Subscribe(a *app.App, typeId int, regionId int) (events <-chan Event, cancel func(), ok bool) {
+	h := getHub(a)
+	s := h.subscribe(typeId, regionId)
+	if s == nil {
+		return nil, nil, false
+	}
+	return s.ch, func() { h.unsubscribe(s) }, true
+}
+
+func (s *subscriber) wants(e Event) bool {
+	if s.regionId != 0 && e.RegionId != 0 && e.RegionId != s.regionId {
+		return false
+	}
+	if e.Kind == MarketEvent {
+		return s.typeId == e.TypeId
+	}
+	return true
+}
+
+// NOTE: h.mu must be held
+func (h *hub) publish(e Event) {
+	for s := range h.subscribers {
+		if !s.wants(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			droppedEvents.Inc()
+		}
+	}
+}
+
+// Announce that the orders of the regions were replaced
+func PublishOrders(a *app.App, retrivalTime time.Time, regionIds []int) {
+	h := getHub(a)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, regionId := range regionIds {
+		h.publish(Event{Kind: OrdersEvent, Time: retrivalTime.Unix(), RegionId: regionId})
+	}
+	h.lastOrders = Event{Kind: OrdersEvent, Time: retrivalTime.Unix()}
+}
+
+// Announce that the histories of day are available
+func PublishHistories(a *app.App, day time.Time) {
+	h := getHub(a)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(Event{Kind: HistoriesEvent, Time: a.Now().Unix(), Date: day.Format("2006-01-02")})
+}
+
+// Announce the changes of the subscribed markets since the previous call.
+// Only the types subscribed to are summarized, so a market newly subscribed to
+// is announced from the second download.
+func PublishMarketChanges(a *app.App, retrivalTime time.Time, orders []dbOrder) {
+	h := getHub(a)
+	h.mu.Lock()
+	types := make(map[int]struct{})
+	for s := range h.subscribers {
+		if s.typeId != 0 {
+			types[s.typeId] = struct{}{}
+		}
+	}
+	h.mu.Unlock()
+
+	markets := make(map[marketKey]marketSummary)
+	for i := range orders {
+		o := &orders[i]
+		if _, ok := types[o.TypeId]; !ok {
+			continue
+		}
+		key := marketKey{o.TypeId, o.RegionId}
+		m := markets[key]
+		if o.IsBuyOrder && o.Price > m.bestBuy {
+			m.bestBuy = o.Price
+		} else if !o.IsBuyOrder && (m.bestSell == 0 || o.Price < m.bestSell) {
+			m.bestSell = o.Price
+		}
+		m.orderCount++
+		m.volume += int64(o.VolumeRemain)
+		markets[key] = m
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, m := range markets {
+		previous, ok := h.lastMarkets[key]
+		if ok && previous != m {
+			h.publish(Event{
+				Kind:       MarketEvent,
+				Time:       retrivalTime.Unix(),
+				RegionId:   key.regionId,
+				TypeId:     key.typeId,
+				BestBuy:    m.bestBuy,
+				BestSell:   m.bestSell,
+				OrderCount: m.orderCount,
+			})
+		}
+	}
+	// markets whose orders all disappeared
+	for key := range h.lastMarkets {
+		_, subscribed := types[key.typeId]
+		if _, ok := markets[key]; !ok && subscribed {
+			h.publish(Event{Kind: MarketEvent, Time: retrivalTime.Unix(), RegionId: key.regionId, TypeId: key.typeId})
+		}
+	}
+	h.lastMarkets = markets
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+)
+
+func receive(t *testing.T, s *subscriber) *Event {
+	t.Helper()
+	select {
+	case e := <-s.ch:
+		return &e
+	default:
+		return nil
+	}
+}
+
+func TestMarketChanges(t *testing.T) {
+	a := app.New(nil, nil, config.Default())
+	h := getHub(a)
+	s := h.subscribe(34, 10000002)
+	defer h.unsubscribe(s)
+	all := h.subscribe(0, 0)
+	defer h.unsubscribe(all)
+	now := time.Unix(1700000000, 0)
+
+	orders := []dbOrder{
+		{TypeId: 34, RegionId: 10000002, Price: 5},
+		{TypeId: 34, RegionId: 10000002, Price: 4, IsBuyOrder: true},
+		{TypeId: 35, RegionId: 10000002, Price: 7},
+	}
+	PublishMarketChanges(a, now, orders)
+	if e := receive(t, s); e != nil {
+		t.Fatalf("expected no event on the first download, got %v", e)
+	}
+
+	PublishMarketChanges(a, now, orders)
+	if e := receive(t, s); e != nil {
+		t.Fatalf("expected no event without change, got %v", e)
+	}
+
+	orders[0].Price = 4.5
+	PublishMarketChanges(a, now, orders)
+	e := receive(t, s)
+	if e == nil || e.Kind != MarketEvent || e.TypeId != 34 || e.BestSell != 4.5 || e.BestBuy != 4 || e.OrderCount != 2 {
+		t.Fatalf("unexpected market event %v", e)
+	}
+	if e := receive(t, all); e != nil {
+		t.Fatalf("expected no market event without type subscription, got %v", e)
+	}
+
+	PublishMarketChanges(a, now, orders[2:])
+	e = receive(t, s)
+	if e == nil || e.OrderCount != 0 {
+		t.Fatalf("expected an empty market event, got %v", e)
+	}
+}
+
+func TestRegionFilter(t *testing.T) {
+	a := app.New(nil, nil, config.Default())
+	h := getHub(a)
+	s := h.subscribe(0, 10000002)
+	defer h.unsubscribe(s)
+
+	PublishOrders(a, time.Unix(1700000000, 0), []int{10000002, 10000043})
+	e := receive(t, s)
+	if e == nil || e.RegionId != 10000002 {
+		t.Fatalf("unexpected orders event %v", e)
+	}
+	if e := receive(t, s); e != nil {
+		t.Fatalf("expected a single orders event, got %v", e)
+	}
+
+	PublishHistories(a, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	e = receive(t, s)
+	if e == nil || e.Kind != HistoriesEvent || e.Date != "2024-01-02" {
+		t.Fatalf("unexpected histories event %v", e)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := app.New(nil, nil, config.Default())
+	server := httptest.NewServer(CreateHandler(ctx, a))
+	defer server.Close()
+
+	PublishOrders(a, time.Unix(1700000000, 0), nil)
+	response, err := server.Client().Get(server.URL + "?region=10000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", response.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(response.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	first := readEvent()
+	if !strings.HasPrefix(first, "event: orders\ndata: ") || !strings.Contains(first, `"time":1700000000`) {
+		t.Fatalf("unexpected first event %q", first)
+	}
+
+	PublishOrders(a, time.Unix(1700000600, 0), []int{10000043, 10000002})
+	second := readEvent()
+	if !strings.Contains(second, `"regionId":10000002`) {
+		t.Fatalf("unexpected second event %q", second)
+	}
+}
+
+func TestAppsIsolated(t *testing.T) {
+	a := app.New(nil, nil, config.Default())
+	b := app.New(nil, nil, config.Default())
+	events, cancel, ok := Subscribe(b, 0, 0)
+	if !ok {
+		t.Fatal("subscription refused")
+	}
+	defer cancel()
+
+	PublishOrders(a, time.Unix(1700000000, 0), []int{10000002})
+	select {
+	case e := <-events:
+		t.Fatalf("received the event of another app %v", e)
+	default:
+	}
+	if LastOrders(b).Time != 0 {
+		t.Error("the last orders event of another app is shared")
+	}
+}
@@ -2,21 +2,25 @@ package histories
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/marketgroups"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/httpcache"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
 )
 
-// NOTE: even though I could split the fonction in two api and db function,
-// I don't do it for the sake of performance.
-func CreateHandler(ctx context.Context) http.HandlerFunc {
-	db := ctx.Value("db").(*database.DB)
-
+func CreateHandler(ctx context.Context, a *app.App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
@@ -24,32 +28,184 @@ func CreateHandler(ctx context.Context) http.HandlerFunc {
 		query := r.URL.Query()
 		typeId, err := strconv.Atoi(query.Get("type"))
 		if err != nil {
-			http.Error(w, `Bad request: param "type" is invalid integer`, 400)
+			openapi.ParamError(w, "type", "is invalid integer")
+			return
+		}
+		activemarkets.RecordRequest(a, typeId)
+		var regionId int
+		if groupName := query.Get("group"); groupName != "" {
+			group, ok := regions.GetGroup(a, groupName)
+			if !ok {
+				openapi.ParamError(w, "group", "is not a known region group")
+				return
+			}
+			regionId = group.Id
+		} else {
+			regionId, err = strconv.Atoi(query.Get("region"))
+			if err != nil {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+		}
+
+		lastRefresh, err := LastRefresh(timeoutCtx, a)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
 			return
 		}
-		regionId, err := strconv.Atoi(query.Get("region"))
+		nextRefresh, err := timerecord.Get(timeoutCtx, a, "HistoriesExpiration")
 		if err != nil {
-			http.Error(w, `Bad request: param "region" is invalid integer`, 400)
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
 			return
 		}
 
-		var historyJson []byte
-		historyQuery := `
-    SELECT HistoryJson FROM History
-      WHERE TypeId = ? AND RegionId = ?;
-    `
-		err = db.QueryRow(timeoutCtx, historyQuery, typeId, regionId).Scan(&historyJson)
-		if err != nil && errors.Is(err, sql.ErrNoRows) {
+		// NOTE: the refresh time is recorded at the end of the run, the histories
+		// written by the run in progress keep the etag of the previous run
+		httpcache.SetFreshness(w, lastRefresh)
+		if !lastRefresh.IsZero() {
+			httpcache.SetMaxAge(w, nextRefresh, a.Config.Api.KeyRequired)
+			if httpcache.NotModified(w, r, fmt.Sprintf(`"%d"`, lastRefresh.Unix())) {
+				return
+			}
+		}
+
+		historyJson, err := shared.Histories(a).Get(timeoutCtx, typeId, regionId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		if historyJson == nil {
 			log.Printf("History for type %d in region %d is not available", typeId, regionId)
 			http.Error(w, "History not available", 404)
 			return
-		} else if err != nil {
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(historyJson)
+	}
+}
+
+// Serve /screener?region=&sort=&order=&minValue=&marketGroup=&limit=
+// region can be replaced by group like in /history. sort is dayChange,
+// weekChange, volumeRatio or breakout and order is desc or asc. minValue is the
+// minimum ISK traded during the last day.
+func CreateScreenerHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		q := screenerQuery{sort: "dayChange", limit: 50}
+		var err error
+		if groupName := query.Get("group"); groupName != "" {
+			group, ok := regions.GetGroup(a, groupName)
+			if !ok {
+				openapi.ParamError(w, "group", "is not a known region group")
+				return
+			}
+			q.regionId = group.Id
+		} else {
+			q.regionId, err = strconv.Atoi(query.Get("region"))
+			if err != nil {
+				openapi.ParamError(w, "region", "is invalid integer")
+				return
+			}
+		}
+		if sort := query.Get("sort"); sort != "" {
+			if _, ok := screenerSortColumns[sort]; !ok && sort != "breakout" {
+				openapi.ParamError(w, "sort", "must be dayChange, weekChange, volumeRatio or breakout")
+				return
+			}
+			q.sort = sort
+		}
+		switch query.Get("order") {
+		case "", "desc":
+		case "asc":
+			q.ascend = true
+		default:
+			openapi.ParamError(w, "order", "must be asc or desc")
+			return
+		}
+		if query.Get("minValue") != "" {
+			q.minValue, err = strconv.ParseFloat(query.Get("minValue"), 64)
+			if err != nil {
+				openapi.ParamError(w, "minValue", "is invalid number")
+				return
+			}
+		}
+		if query.Get("marketGroup") != "" {
+			marketGroupId, err := strconv.Atoi(query.Get("marketGroup"))
+			if err != nil {
+				openapi.ParamError(w, "marketGroup", "is invalid integer")
+				return
+			}
+			types, ok, err := marketgroups.GetTypes(marketGroupId)
+			if errors.Is(err, marketgroups.ErrNotLoaded) {
+				http.Error(w, "Market groups not available", 503)
+				return
+			}
+			if !ok {
+				openapi.ParamError(w, "marketGroup", "is not a known market group")
+				return
+			}
+			q.types = types
+		}
+		if query.Get("limit") != "" {
+			q.limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || q.limit <= 0 || q.limit > 500 {
+				openapi.ParamError(w, "limit", "must be an integer between 1 and 500")
+				return
+			}
+		}
+
+		rows, err := dbGetScreener(timeoutCtx, a, q)
+		if err != nil {
 			log.Printf("Internal server error: %v", err)
 			http.Error(w, "Internal server error", 500)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.Write(historyJson)
+		err = json.NewEncoder(w).Encode(rows)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	return []openapi.Operation{
+		{
+			Path:    "/history",
+			Summary: "Daily price history of a type in a region or a region group",
+			Params: []openapi.Param{
+				{Name: "type", Type: "integer", Required: true, Description: "Type id"},
+				{Name: "region", Type: "integer", Description: "Region id, 0 for the global history. Required without group"},
+				{Name: "group", Type: "string", Description: "Name of a region group, replaces region"},
+			},
+			Response: []dbHistoryDay{},
+			Handler:  CreateHandler(ctx, a),
+		},
+		{
+			Path:    "/screener",
+			Summary: "Markets ranked by the moves of their last history day",
+			Params: []openapi.Param{
+				{Name: "region", Type: "integer", Description: "Region id, 0 for the global market. Required without group"},
+				{Name: "group", Type: "string", Description: "Name of a region group, replaces region"},
+				{Name: "sort", Type: "string", Enum: []string{"dayChange", "weekChange", "volumeRatio", "breakout"}, Description: "Ranking, dayChange by default"},
+				{Name: "order", Type: "string", Enum: []string{"desc", "asc"}, Description: "desc by default"},
+				{Name: "minValue", Type: "number", Description: "Minimum ISK traded during the last day"},
+				{Name: "marketGroup", Type: "integer", Description: "Restrict to the types of a market group and its sub groups"},
+				{Name: "limit", Type: "integer", Description: "Between 1 and 500, 50 by default"},
+			},
+			Response: []screenerRow{},
+			Handler:  CreateScreenerHandler(ctx, a),
+		},
 	}
 }
@@ -2,47 +2,181 @@ package histories
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
 	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
 type dbHistory = shared.DbHistory
-type dbHistoryDay struct {
-	Date           string  `json:"date"`
-	Average        float64 `json:"average"`
-	Average5d      float64 `json:"average5d"`
-	Average20d     float64 `json:"average20d"`
-	Highest        float64 `json:"highest"`
-	Lowest         float64 `json:"lowest"`
-	OrderCount     int     `json:"orderCount"`
-	Volume         int64   `json:"volume"`
-	DonchianTop    float64 `json:"donchianTop"`
-	DonchianBottom float64 `json:"donchianBottom"`
+type dbHistoryDay = shared.DbHistoryDay
+
+type historyRun struct {
+	date            string
+	started         time.Time
+	chunkCount      int
+	completedChunks int
+	done            bool
 }
 
-func dbGetHistoriesOfType(ctx context.Context, typeId int) ([]dbHistory, error) {
-	db := ctx.Value("db").(*database.DB)
+// WARN: nillable return value
+func dbGetLastRun(ctx context.Context, a *app.App) (*historyRun, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	var run historyRun
+	var started int64
+	selectQuery := `
+  SELECT Date, Started, ChunkCount, CompletedChunks, Done FROM HistoryRun
+    ORDER BY Started DESC LIMIT 1;
+  `
+	err := db.QueryRow(timeoutCtx, selectQuery).Scan(
+		&run.date,
+		&started,
+		&run.chunkCount,
+		&run.completedChunks,
+		&run.done,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	run.started = time.Unix(started, 0)
+
+	return &run, nil
+}
+
+// Create a new run and snapshot the active markets it has to download
+func dbCreateRun(ctx context.Context, a *app.App, date string, started time.Time, chunkSize int) (*historyRun, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HistoryRunMarket")
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: the requested types are bound as one array, there can be more of
+	// them than the databases accept parameters
+	requestedCond, requestedArg := db.InArray(activemarkets.RequestedTypes(a))
+	args := make([]any, 0, len(regions.Hubs)+5)
+	args = append(args, requestedArg)
+	for _, regionId := range regions.Hubs {
+		args = append(args, regionId)
+	}
+	args = append(
+		args,
+		activemarkets.ImportantTradedValue,
+		activemarkets.StatusActive,
+		activemarkets.StatusWeekly,
+		int(started.Weekday()),
+	)
+
+	// NOTE: the positions go from 1 to the number of markets.
+	// Weekly markets are spread over the days of the week.
+	// The markets are downloaded in order of importance: first the types
+	// recently requested by users, then the markets of the trade hubs and the
+	// ones with a high traded value, then the long tail. Inside each tier the
+	// markets with the highest traded value come first.
+	planQuery := `
+  INSERT INTO HistoryRunMarket (Position, TypeId, RegionId)
+    SELECT
+      ROW_NUMBER() OVER (ORDER BY
+        a.TypeId ` + requestedCond + ` DESC,
+        (a.RegionId IN ` + database.InList(len(regions.Hubs)) + ` OR COALESCE(v.TradedValue, 0) >= ?) DESC,
+        COALESCE(v.TradedValue, 0) DESC
+      ),
+      a.TypeId,
+      a.RegionId
+    FROM ActiveMarket a
+      JOIN ActiveMarketState s ON a.TypeId = s.TypeId AND a.RegionId = s.RegionId
+      LEFT JOIN ActiveMarketValue v ON a.TypeId = v.TypeId AND a.RegionId = v.RegionId
+      WHERE COALESCE(s.Override, s.Status) = ?
+      OR (COALESCE(s.Override, s.Status) = ? AND (a.TypeId + a.RegionId) % 7 = ?);
+  `
+	result, err := tx.Exec(timeoutCtx, planQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	marketCount, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	run := historyRun{
+		date:       date,
+		started:    started,
+		chunkCount: (int(marketCount) + chunkSize - 1) / chunkSize,
+	}
+	insertQuery := `
+  INSERT INTO HistoryRun VALUES (?,?,?,?,?)
+    ON CONFLICT (Date) DO UPDATE SET
+      Started = excluded.Started,
+      ChunkCount = excluded.ChunkCount,
+      CompletedChunks = excluded.CompletedChunks,
+      Done = excluded.Done;
+  `
+	_, err = tx.Exec(timeoutCtx, insertQuery, run.date, run.started.Unix(), run.chunkCount, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: the failures are retried at the end of every run until they are a
+	// week old
+	deleteQuery := "DELETE FROM HistoryRunFailure WHERE Date < ?"
+	_, err = tx.Exec(timeoutCtx, deleteQuery, started.AddDate(0, 0, -7).Format(esi.DateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func dbGetRunChunk(ctx context.Context, a *app.App, chunk int, chunkSize int) ([]activemarkets.ActiveMarket, error) {
+	db := a.DB
+	activeMarkets := make([]activemarkets.ActiveMarket, 0, chunkSize)
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
 	selectQuery := `
-  SELECT HistoryJson, RegionId FROM History WHERE TypeId = ? AND RegionId != 0`
-	rows, err := db.Query(timeoutCtx, selectQuery, typeId)
+  SELECT TypeId, RegionId FROM HistoryRunMarket
+    WHERE Position > ? AND Position <= ?
+    ORDER BY Position;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, chunk*chunkSize, (chunk+1)*chunkSize)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	histories := make([]dbHistory, 0)
 	for rows.Next() {
-		var h = dbHistory{TypeId: typeId}
-		err = rows.Scan(&h.History, &h.RegionId)
+		var am activemarkets.ActiveMarket
+		err = rows.Scan(&am.TypeId, &am.RegionId)
 		if err != nil {
 			return nil, err
 		}
-		histories = append(histories, h)
+		activeMarkets = append(activeMarkets, am)
 	}
 
 	err = rows.Err()
@@ -50,30 +184,80 @@ func dbGetHistoriesOfType(ctx context.Context, typeId int) ([]dbHistory, error)
 		return nil, err
 	}
 
-	return histories, nil
+	return activeMarkets, nil
 }
 
-func dbInsertHistories(ctx context.Context, histories []dbHistory) error {
-	db := ctx.Value("db").(*database.DB)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+func dbSetRunProgress(ctx context.Context, a *app.App, date string, completedChunks int, done bool) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	updateQuery := "UPDATE HistoryRun SET CompletedChunks = ?, Done = ? WHERE Date = ?"
+	_, err := db.Exec(timeoutCtx, updateQuery, completedChunks, done, date)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Markets that failed during the run of date or during an earlier run
+func dbGetRunFailures(ctx context.Context, a *app.App, date string) ([]activemarkets.ActiveMarket, error) {
+	db := a.DB
+	failures := make([]activemarkets.ActiveMarket, 0)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	selectQuery := "SELECT DISTINCT TypeId, RegionId FROM HistoryRunFailure WHERE Date <= ?"
+	rows, err := db.Query(timeoutCtx, selectQuery, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var am activemarkets.ActiveMarket
+		err = rows.Scan(&am.TypeId, &am.RegionId)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, am)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+func dbInsertRunFailures(ctx context.Context, a *app.App, date string, activeMarkets []activemarkets.ActiveMarket, cause error) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 
-	// NOTE: the transaction here is not strictly necessary, if it lock the db
-	// for too long I may to remove it.
 	tx, err := db.Begin(timeoutCtx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareWrite(timeoutCtx, "INSERT OR REPLACE INTO History VALUES (?,?,?)")
+	insertQuery := `
+  INSERT INTO HistoryRunFailure VALUES (?,?,?,?)
+    ON CONFLICT (Date, TypeId, RegionId) DO UPDATE SET Error = excluded.Error;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, insertQuery)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	for _, h := range histories {
-		_, err = stmt.Exec(timeoutCtx, h.TypeId, h.RegionId, h.History)
+	for _, am := range activeMarkets {
+		_, err = stmt.Exec(timeoutCtx, date, am.TypeId, am.RegionId, cause.Error())
+		if err != nil {
+			return err
+		}
 	}
 
 	err = tx.Commit()
@@ -84,16 +268,173 @@ func dbInsertHistories(ctx context.Context, histories []dbHistory) error {
 	return nil
 }
 
-func dbInsertHistory(ctx context.Context, history dbHistory) error {
-	db := ctx.Value("db").(*database.DB)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 4*time.Minute)
+// Delete the failures of every run of the markets
+func dbDeleteRunFailures(ctx context.Context, a *app.App, activeMarkets []activemarkets.ActiveMarket) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 
-	insertQuery := "INSERT OR REPLACE INTO History VALUES (?,?,?)"
-	_, err := db.Exec(timeoutCtx, insertQuery, history.TypeId, history.RegionId, history.History)
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareWrite(timeoutCtx, "DELETE FROM HistoryRunFailure WHERE TypeId = ? AND RegionId = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, am := range activeMarkets {
+		_, err = stmt.Exec(timeoutCtx, am.TypeId, am.RegionId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// Replace the whole screener by rows
+func dbReplaceScreener(ctx context.Context, a *app.App, rows []screenerRow) error {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM Screener")
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareWrite(timeoutCtx, "INSERT INTO Screener VALUES (?,?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		_, err = stmt.Exec(
+			timeoutCtx,
+			r.TypeId,
+			r.RegionId,
+			r.Date,
+			r.Average,
+			r.Volume,
+			r.TradedValue,
+			r.DayChange,
+			r.WeekChange,
+			r.VolumeRatio,
+			r.Breakout,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+type screenerQuery struct {
+	regionId int
+	sort     string // dayChange, weekChange, volumeRatio or breakout
+	ascend   bool
+	minValue float64
+	types    []int // nil to disable the filter
+	limit    int
+}
+
+var screenerSortColumns = map[string]string{
+	"dayChange":   "DayChange",
+	"weekChange":  "WeekChange",
+	"volumeRatio": "VolumeRatio",
+}
+
+// Rows of the breakout sort are the markets that broke out upward, or
+// downward if ascend is set, sorted by traded value
+func dbGetScreener(ctx context.Context, a *app.App, q screenerQuery) ([]screenerRow, error) {
+	db := a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	rows := make([]screenerRow, 0, q.limit)
+
+	args := []any{q.regionId, q.minValue}
+	typesFilter := ""
+	if q.types != nil {
+		typesFilter = "AND TypeId IN " + database.InList(len(q.types))
+		for _, typeId := range q.types {
+			args = append(args, typeId)
+		}
+	}
+
+	var orderBy string
+	breakout := 0
+	if q.sort == "breakout" {
+		breakout = 1
+		if q.ascend {
+			breakout = -1
+		}
+		orderBy = "TradedValue DESC"
+	} else {
+		orderBy = screenerSortColumns[q.sort] + " DESC"
+		if q.ascend {
+			orderBy = screenerSortColumns[q.sort] + " ASC"
+		}
+	}
+
+	// orderBy only comes from screenerSortColumns
+	selectQuery := `
+  SELECT TypeId, RegionId, Date, Average, Volume, TradedValue, DayChange, WeekChange, VolumeRatio, Breakout
+    FROM Screener
+    WHERE RegionId = ?
+    AND TradedValue >= ?
+    ` + typesFilter + `
+    AND (? = 0 OR Breakout = ?)
+    ORDER BY ` + orderBy + `
+    LIMIT ?;
+  `
+	args = append(args, breakout, breakout, q.limit)
+	result, err := db.Query(timeoutCtx, selectQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	for result.Next() {
+		var r screenerRow
+		err = result.Scan(
+			&r.TypeId,
+			&r.RegionId,
+			&r.Date,
+			&r.Average,
+			&r.Volume,
+			&r.TradedValue,
+			&r.DayChange,
+			&r.WeekChange,
+			&r.VolumeRatio,
+			&r.Breakout,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, r)
+	}
+
+	err = result.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,130 @@
+package histories
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+)
+
+func TestCompact(t *testing.T) {
+	a, ctx := apptest.New(t)
+	db := a.DB
+
+	historyJson, err := json.Marshal([]dbHistoryDay{
+		{Date: "2024-03-01", Average: 5.5, Highest: 6, Lowest: 5, OrderCount: 10, Volume: 1000},
+		{Date: "2024-03-02", Average: 5.5, Highest: 5.5, Lowest: 5.5, Filled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a row written before the encoding and a new one
+	_, err = db.Exec(ctx, "INSERT INTO History (TypeId, RegionId, HistoryBlob) VALUES (?,?,?)", 34, 10000002, historyJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = shared.Histories(a).Insert(ctx, []dbHistory{{TypeId: 34, RegionId: 10000043, History: historyJson}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Compact(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jsonRows int
+	err = db.QueryRow(ctx, "SELECT COUNT(*) FROM History WHERE Format != ?", shared.HistoryFormatColumns).Scan(&jsonRows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsonRows != 0 {
+		t.Errorf("%d rows left uncompressed", jsonRows)
+	}
+	histories, err := shared.Histories(a).GetOfType(ctx, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2 {
+		t.Fatalf("expected 2 histories, got %d", len(histories))
+	}
+	for _, h := range histories {
+		if !bytes.Equal(h.History, historyJson) {
+			t.Errorf("history of region %d changed:\n%s\n%s", h.RegionId, h.History, historyJson)
+		}
+	}
+}
+
+func TestRunFailuresCarriedOver(t *testing.T) {
+	a, ctx := apptest.New(t)
+
+	// failures of an abandoned run and of the current run
+	abandoned := []activemarkets.ActiveMarket{{TypeId: 34, RegionId: 10000002}, {TypeId: 35, RegionId: 10000002}}
+	err := dbInsertRunFailures(ctx, a, "2024-03-01", abandoned, errors.New("esi down"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dbInsertRunFailures(ctx, a, "2024-03-02", abandoned[:1], errors.New("esi down"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err := dbGetRunFailures(ctx, a, "2024-03-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("expected the 2 failed markets, got %v", failures)
+	}
+
+	err = dbDeleteRunFailures(ctx, a, abandoned[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, err = dbGetRunFailures(ctx, a, "2024-03-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0] != abandoned[1] {
+		t.Fatalf("expected the failure of type 35 only, got %v", failures)
+	}
+}
+
+func TestRunResumedOnItsDayOnly(t *testing.T) {
+	a, ctx := apptest.New(t)
+
+	started := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	_, err := dbCreateRun(ctx, a, "2024-03-01", started, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err := dbGetLastRun(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isResumable(run, "2024-03-01") {
+		t.Errorf("expected the run to be resumed on its day")
+	}
+	// less than 24 hours later, but on the next day
+	if isResumable(run, "2024-03-02") {
+		t.Errorf("expected the run of the previous day to be abandoned")
+	}
+
+	err = dbSetRunProgress(ctx, a, "2024-03-01", run.chunkCount, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, err = dbGetLastRun(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isResumable(run, "2024-03-01") {
+		t.Errorf("expected a done run not to be resumed")
+	}
+}
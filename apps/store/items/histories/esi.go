@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
 	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
@@ -16,35 +18,41 @@ type esiHistoryDay = shared.EsiHistoryDay
 
 var ErrInvalidEsiData = errors.New("Invalid esi data")
 
-func fetchHistoriesChunk(ctx context.Context, activeMarketChunk []activemarkets.ActiveMarket) ([]dbHistory, error) {
+type historyResult struct {
+	history *dbHistory
+	report  activemarkets.HistoryReport
+}
+
+func fetchHistoriesChunk(ctx context.Context, a *app.App, activeMarketChunk []activemarkets.ActiveMarket) ([]dbHistory, []activemarkets.HistoryReport, error) {
 	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 15*time.Minute)
 	errorCtx, errorCancel := context.WithCancelCause(timeoutCtx)
 	defer timeoutCancel()
 
-	histories := make([]dbHistory, len(activeMarketChunk))
+	histories := make([]dbHistory, 0, len(activeMarketChunk))
+	reports := make([]activemarkets.HistoryReport, 0, len(activeMarketChunk))
 	activeMarketCh := make(chan activemarkets.ActiveMarket, 4)
-	historyCh := make(chan *dbHistory, 4)
+	resultCh := make(chan historyResult, 4)
 
 	worker := func() {
 		for am := range activeMarketCh {
-			history, err := fetchHistory(errorCtx, am.RegionId, am.TypeId)
+			history, report, err := fetchHistory(errorCtx, a, am.RegionId, am.TypeId)
 			if err != nil {
 				var esiError *esi.EsiError
 				if errors.As(err, &esiError) && (esiError.Code == 404 || esiError.Code == 400) {
-					// NOTE: I can maybe handle 404, 400 errors better
-					// log.Printf("History downloader: I cant fetch history of type %d in region %d due to a %d so I'll skip it.", am.TypeId, am.RegionId, esiError.Code)
+					// The market is skipped and its lifecycle will take care of it
 					history = nil
+					report = activemarkets.HistoryReport{TypeId: am.TypeId, RegionId: am.RegionId, NotFound: true}
 				} else {
 					errorCancel(fmt.Errorf("fetch history: %w", err))
 					return
 				}
 			}
-			// if 404, then send nil
-			historyCh <- history
+			// if 404, then send a nil history
+			resultCh <- historyResult{history: history, report: report}
 		}
 	}
 
-	for i := 0; i < esi.MaxConcurrentRequests; i++ {
+	for i := 0; i < a.Esi.MaxConcurrentRequests(); i++ {
 		go worker()
 	}
 
@@ -61,41 +69,66 @@ func fetchHistoriesChunk(ctx context.Context, activeMarketChunk []activemarkets.
 
 	for i := 0; i < len(activeMarketChunk); i++ {
 		select {
-		case h := <-historyCh:
-			if h != nil {
-				histories[i] = *h
+		case r := <-resultCh:
+			if r.history != nil {
+				histories = append(histories, *r.history)
 			}
+			reports = append(reports, r.report)
 		case <-errorCtx.Done():
-			return nil, context.Cause(errorCtx)
+			return nil, nil, context.Cause(errorCtx)
 		}
 	}
 
-	return histories, nil
+	return histories, reports, nil
 }
 
-func fetchHistory(ctx context.Context, regionId int, typeId int) (*dbHistory, error) {
+func fetchHistory(ctx context.Context, a *app.App, regionId int, typeId int) (*dbHistory, activemarkets.HistoryReport, error) {
+	report := activemarkets.HistoryReport{TypeId: typeId, RegionId: regionId}
+	// The long tail yields to the other esi requests
+	priority := 0
+	if regions.IsHub(regionId) || activemarkets.WasRequested(a, typeId) {
+		priority = 1
+	}
 	uri := fmt.Sprintf("/markets/%d/history?type_id=%d", regionId, typeId)
-	response, err := esi.EsiFetch[[]esiHistoryDay](ctx, "GET", uri, nil, false, 1, 5)
+	response, err := esi.EsiFetch[[]esiHistoryDay](ctx, a.Esi, "GET", uri, nil, false, priority, 5)
 	if err != nil {
-		return nil, fmt.Errorf("fetching esi history: %w", err)
+		return nil, report, fmt.Errorf("fetching esi history: %w", err)
 	}
+	skipFilled := a.Config.Histories.SkipFilled
 	esiHistoryDays := *response.Data
-	dbHistoryDays, err := esiToDbHistoryDays(esiHistoryDays)
+	dbHistoryDays, err := esiToDbHistoryDays(esiHistoryDays, skipFilled)
 	if err != nil {
-		return nil, fmt.Errorf("esi to db history: %w", err)
+		return nil, report, fmt.Errorf("esi to db history: %w", err)
 	}
 	dbHistoryDaysJson, err := json.Marshal(dbHistoryDays)
 	if err != nil {
-		return nil, fmt.Errorf("marshal history: %w", err)
+		return nil, report, fmt.Errorf("marshal history: %w", err)
+	}
+	for i := len(esiHistoryDays) - 1; i >= 0; i-- {
+		if esiHistoryDays[i].Volume > 0 {
+			report.LastTrade, _ = time.Parse(esi.DateLayout, esiHistoryDays[i].Date)
+			break
+		}
+	}
+	if len(esiHistoryDays) > 0 {
+		lastDay := esiHistoryDays[len(esiHistoryDays)-1]
+		report.LastValue = lastDay.Average * float64(lastDay.Volume)
 	}
 	return &dbHistory{
 		History:  dbHistoryDaysJson,
 		RegionId: regionId,
 		TypeId:   typeId,
-	}, nil
+	}, report, nil
 }
 
-func esiToDbHistoryDays(esiHistoryDays []esiHistoryDay) ([]dbHistoryDay, error) {
+// The order count of the filled days, the esi doesn't tell it
+const unknownOrderCount = -1
+
+// Convert esi history days to db history days. Days missing from the esi
+// history are synthesized by copying the previous average with no volume and
+// an unknown order count, and are marked as filled. If skipFilled is set, the rolling indicators are
+// computed over real trading days only.
+func esiToDbHistoryDays(esiHistoryDays []esiHistoryDay, skipFilled bool) ([]dbHistoryDay, error) {
 	if len(esiHistoryDays) == 0 {
 		return make([]dbHistoryDay, 0), nil
 	}
@@ -143,9 +176,56 @@ func esiToDbHistoryDays(esiHistoryDays []esiHistoryDay) ([]dbHistoryDay, error)
 			historyDays[i].Lowest = esiHistoryDays[j].Average
 			historyDays[i].Highest = esiHistoryDays[j].Average
 			historyDays[i].Average = esiHistoryDays[j].Average
-			historyDays[i].OrderCount = esiHistoryDays[j].OrderCount
+			historyDays[i].OrderCount = unknownOrderCount
+			historyDays[i].Filled = true
+		}
+	}
+
+	computeIndicators(historyDays, skipFilled)
+
+	return historyDays, nil
+}
+
+// Compute the rolling averages and donchian channels of historyDays. If
+// skipFilled is set, filled days are left out of the rolling windows and
+// carry the indicators of the last real day.
+func computeIndicators(historyDays []dbHistoryDay, skipFilled bool) {
+	if !skipFilled {
+		computeRollingIndicators(historyDays)
+		return
+	}
+
+	realDays := make([]dbHistoryDay, 0, len(historyDays))
+	for i := range historyDays {
+		if !historyDays[i].Filled {
+			realDays = append(realDays, historyDays[i])
+		}
+	}
+	computeRollingIndicators(realDays)
+
+	j := -1
+	for i := range historyDays {
+		if !historyDays[i].Filled {
+			j++
+			historyDays[i] = realDays[j]
+		} else if j >= 0 {
+			historyDays[i].Average5d = realDays[j].Average5d
+			historyDays[i].Average20d = realDays[j].Average20d
+			historyDays[i].DonchianTop = realDays[j].DonchianTop
+			historyDays[i].DonchianBottom = realDays[j].DonchianBottom
+		} else {
+			historyDays[i].Average5d = historyDays[i].Average
+			historyDays[i].Average20d = historyDays[i].Average
+			historyDays[i].DonchianTop = historyDays[i].Highest
+			historyDays[i].DonchianBottom = historyDays[i].Lowest
 		}
 	}
+}
+
+func computeRollingIndicators(historyDays []dbHistoryDay) {
+	if len(historyDays) == 0 {
+		return
+	}
 
 	historyDays[0].Average5d = historyDays[0].Average
 	historyDays[0].Average20d = historyDays[0].Average
@@ -197,6 +277,4 @@ func esiToDbHistoryDays(esiHistoryDays []esiHistoryDay) ([]dbHistoryDay, error)
 		historyDays[i].DonchianTop = dcTop
 		historyDays[i].DonchianBottom = dcBottom
 	}
-
-	return historyDays, nil
 }
@@ -52,10 +52,11 @@ var testDbHistoryDays = []dbHistoryDay{
 		Date:           "2024-12-26",
 		Highest:        6018000,
 		Lowest:         6018000,
-		OrderCount:     1739,
+		OrderCount:     unknownOrderCount,
 		Volume:         0,
 		DonchianTop:    6144000,
 		DonchianBottom: 6000000,
+		Filled:         true,
 	},
 	{
 		Average:        6003000,
@@ -93,11 +94,12 @@ func areDaysEqual(d1 *dbHistoryDay, d2 *dbHistoryDay) bool {
 		d1.OrderCount == d2.OrderCount &&
 		d1.Volume == d2.Volume &&
 		d1.DonchianTop == d2.DonchianTop &&
-		d1.DonchianBottom == d2.DonchianBottom
+		d1.DonchianBottom == d2.DonchianBottom &&
+		d1.Filled == d2.Filled
 }
 
 func TestEsiToDbHistory(t *testing.T) {
-	dbHistoryDays, err := esiToDbHistoryDays(testEsiHistoryDays)
+	dbHistoryDays, err := esiToDbHistoryDays(testEsiHistoryDays, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,3 +112,31 @@ func TestEsiToDbHistory(t *testing.T) {
 		}
 	}
 }
+
+func TestEsiToDbHistorySkipFilled(t *testing.T) {
+	dbHistoryDays, err := esiToDbHistoryDays(testEsiHistoryDays, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dbHistoryDays) != len(testDbHistoryDays) {
+		t.Fatalf("expected %d days, got %d", len(testDbHistoryDays), len(dbHistoryDays))
+	}
+	filledDay := dbHistoryDays[1]
+	if !filledDay.Filled || dbHistoryDays[0].Filled || dbHistoryDays[2].Filled {
+		t.Fatalf("unexpected filled days: %v", dbHistoryDays)
+	}
+	if filledDay.Average5d != dbHistoryDays[0].Average5d ||
+		filledDay.Average20d != dbHistoryDays[0].Average20d ||
+		filledDay.DonchianTop != dbHistoryDays[0].DonchianTop ||
+		filledDay.DonchianBottom != dbHistoryDays[0].DonchianBottom {
+		t.Fatalf("filled day should carry the indicators of the previous day: got %v", filledDay)
+	}
+
+	// the 20 days average of the last day only covers the 3 real days
+	want := (21*6018000 - 6018000 + 6003000) / 21.0
+	want = (21*want - 6018000 + 6000000) / 21
+	if dbHistoryDays[3].Average20d != want {
+		t.Fatalf("expected average20d %v, got %v", want, dbHistoryDays[3].Average20d)
+	}
+}
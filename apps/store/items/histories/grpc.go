@@ -0,0 +1,71 @@
+package histories
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	storepb.UnimplementedHistoriesServer
+	ctx context.Context
+	a   *app.App
+}
+
+// Register the Histories service of the gRPC api
+func RegisterGrpc(ctx context.Context, a *app.App, server grpc.ServiceRegistrar) {
+	storepb.RegisterHistoriesServer(server, &grpcServer{ctx: ctx, a: a})
+}
+
+func (s *grpcServer) GetHistory(ctx context.Context, req *storepb.HistoryRequest) (*storepb.HistoryReply, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	activemarkets.RecordRequest(s.a, int(req.TypeId))
+	regionId := int(req.RegionId)
+	if req.Group != "" {
+		group, ok := regions.GetGroup(s.a, req.Group)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "group is not a known region group")
+		}
+		regionId = group.Id
+	}
+
+	// NOTE: the histories are stored encoded, decoding them here saves the
+	// clients from doing it
+	days, err := shared.Histories(s.a).GetDays(timeoutCtx, int(req.TypeId), regionId)
+	if err != nil {
+		log.Printf("Internal server error: %v", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	if days == nil {
+		return nil, status.Error(codes.NotFound, "History not available")
+	}
+
+	reply := &storepb.HistoryReply{Days: make([]*storepb.HistoryDay, len(days))}
+	for i, d := range days {
+		reply.Days[i] = &storepb.HistoryDay{
+			Date:           d.Date,
+			Average:        d.Average,
+			Average5D:      d.Average5d,
+			Average20D:     d.Average20d,
+			Highest:        d.Highest,
+			Lowest:         d.Lowest,
+			OrderCount:     int32(d.OrderCount),
+			Volume:         d.Volume,
+			DonchianTop:    d.DonchianTop,
+			DonchianBottom: d.DonchianBottom,
+			Filled:         d.Filled,
+		}
+	}
+	return reply, nil
+}
@@ -8,34 +8,64 @@ import (
 	"log"
 	"time"
 
+	vm "github.com/VictoriaMetrics/metrics"
 	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
 	"github.com/raph5/eve-market-browser/apps/store/items/metrics"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
 const chunkSize = 128
 
-func Download(ctx context.Context) error {
-	activeMarketsCount, err := activemarkets.Count(ctx)
+// History rows rewritten per transaction by Compact
+const compactBatchSize = 1000
+
+// Download the histories of the active markets. The progress of the run is
+// checkpointed in db after each chunk so that an interrupted run resumes where
+// it stopped. The markets of a failed chunk are recorded and retried one by
+// one at the end of the run and of the following runs.
+func Download(ctx context.Context, a *app.App, day time.Time) error {
+	date := day.Format(esi.DateLayout)
+	run, err := dbGetLastRun(ctx, a)
 	if err != nil {
-		return fmt.Errorf("cant get activeMarkets count: %w", err)
+		return fmt.Errorf("cant get last history run: %w", err)
+	}
+	if run != nil && run.done && run.date == date {
+		log.Printf("History run %s is already done", date)
+		return nil
+	}
+	if !isResumable(run, date) {
+		if run != nil && !run.done {
+			log.Printf("Abandoning history run %s at chunk %d of %d", run.date, run.completedChunks, run.chunkCount)
+		}
+		run, err = dbCreateRun(ctx, a, date, day, chunkSize)
+		if err != nil {
+			return fmt.Errorf("cant create history run: %w", err)
+		}
+	} else {
+		log.Printf("Resuming history run %s at chunk %d of %d", run.date, run.completedChunks, run.chunkCount)
 	}
 
-	for offset := 0; offset < activeMarketsCount; offset += chunkSize {
-		activeMarketsChunk, err := activemarkets.GetChunk(ctx, offset, chunkSize)
+	for chunk := run.completedChunks; chunk < run.chunkCount; chunk++ {
+		activeMarketsChunk, err := dbGetRunChunk(ctx, a, chunk, chunkSize)
 		if err != nil {
 			return err
 		}
 
 		var historiesChunk []dbHistory
+		var reportsChunk []activemarkets.HistoryReport
 		for trails := 0; trails < 3; trails++ {
-			historiesChunk, err = fetchHistoriesChunk(ctx, activeMarketsChunk)
+			historiesChunk, reportsChunk, err = fetchHistoriesChunk(ctx, a, activeMarketsChunk)
 			if err == nil {
 				break
 			}
 			var esiErr *esi.EsiError
 			if errors.As(err, &esiErr) {
-				return fmt.Errorf("chunk download stoped by esi error: %w", err)
+				err = fmt.Errorf("chunk download stoped by esi error: %w", err)
+				break
 			}
 
 			log.Printf("History chunk error, retry downloading the chunk after 5 mintues: %v", err)
@@ -50,27 +80,178 @@ func Download(ctx context.Context) error {
 			}
 		}
 		if err != nil {
-			return fmt.Errorf("history chunk failed 3 times: %w", err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// skip the chunk, its markets will be retried at the end of the run
+			log.Printf("History run %s: chunk %d failed: %v", run.date, chunk, err)
+			failErr := dbInsertRunFailures(ctx, a, run.date, activeMarketsChunk, err)
+			if failErr != nil {
+				return fmt.Errorf("failed to record history chunk failure: %w", failErr)
+			}
+			failErr = dbSetRunProgress(ctx, a, run.date, chunk+1, false)
+			if failErr != nil {
+				return fmt.Errorf("failed to save history run progress: %w", failErr)
+			}
+			continue
 		}
 
-		err = dbInsertHistories(ctx, historiesChunk)
+		err = shared.Histories(a).Insert(ctx, historiesChunk)
 		if err != nil {
 			return fmt.Errorf("failed to insert history chunk to db: %w", err)
 		}
+		err = activemarkets.ReportHistories(ctx, a, reportsChunk)
+		if err != nil {
+			log.Printf("Can't report history chunk to active markets: %v", err)
+		}
+		// the markets that failed during an earlier run are downloaded now
+		err = dbDeleteRunFailures(ctx, a, activeMarketsChunk)
+		if err != nil {
+			return fmt.Errorf("failed to delete history failures: %w", err)
+		}
+		err = dbSetRunProgress(ctx, a, run.date, chunk+1, false)
+		if err != nil {
+			return fmt.Errorf("failed to save history run progress: %w", err)
+		}
+	}
+
+	err = retryRunFailures(ctx, a, run.date)
+	if err != nil {
+		return fmt.Errorf("failed to retry history run failures: %w", err)
+	}
+
+	err = dbSetRunProgress(ctx, a, run.date, run.chunkCount, true)
+	if err != nil {
+		return fmt.Errorf("failed to save history run progress: %w", err)
+	}
+
+	return nil
+}
+
+// An unfinished run is only resumed on the day it started. The runs of the
+// previous days are abandoned, their failures are retried by the next runs.
+func isResumable(run *historyRun, date string) bool {
+	return run != nil && !run.done && run.date == date
+}
+
+// Rewrite the history rows stored before the current storage, the json rows
+// on sqlite and every History blob on postgres. The histories of the active
+// markets are rewritten when they are downloaded, the other rows wait for
+// Compact. The storage of the histories by format is reported once done.
+func Compact(ctx context.Context, a *app.App) error {
+	for {
+		left, err := shared.Histories(a).Compact(ctx, compactBatchSize)
+		if err != nil {
+			return err
+		}
+		vm.GetOrCreateGauge("store_history_json_rows", nil).Set(float64(left))
+		if left == 0 {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	// NOTE: unlike the encode counters, the sizes cover every stored row and
+	// survive the restarts
+	sizes, err := shared.Histories(a).Sizes(ctx)
+	if err != nil {
+		return fmt.Errorf("history sizes: %w", err)
+	}
+	for _, size := range sizes {
+		name := shared.HistoryFormatName(size.Format)
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_history_rows{format="%s"}`, name), nil).Set(float64(size.Rows))
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_history_bytes{format="%s"}`, name), nil).Set(float64(size.Bytes))
+	}
+
+	return nil
+}
+
+type RunProgress struct {
+	Date            string `json:"date"`
+	Started         int64  `json:"started"` // Epoch Seconds
+	ChunkCount      int    `json:"chunkCount"`
+	CompletedChunks int    `json:"completedChunks"`
+	Done            bool   `json:"done"`
+}
+
+// WARN: nillable return value, nil if no run was ever started
+func LastRunProgress(ctx context.Context, a *app.App) (*RunProgress, error) {
+	run, err := dbGetLastRun(ctx, a)
+	if err != nil || run == nil {
+		return nil, err
+	}
+	return &RunProgress{
+		Date:            run.date,
+		Started:         run.started.Unix(),
+		ChunkCount:      run.chunkCount,
+		CompletedChunks: run.completedChunks,
+		Done:            run.done,
+	}, nil
+}
+
+// Time at which the last global histories computation finished. The time is
+// zero if the histories were never computed.
+func LastRefresh(ctx context.Context, a *app.App) (time.Time, error) {
+	return timerecord.Get(ctx, a, "HistoriesRefresh")
+}
+
+// Fetch one by one the markets that failed during the run or during the
+// previous runs, like the markets of an abandoned run. Markets that fail again
+// stay recorded in db until their failure is a week old.
+func retryRunFailures(ctx context.Context, a *app.App, date string) error {
+	failures, err := dbGetRunFailures(ctx, a, date)
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		log.Printf("History run %s: retrying %d failed markets", date, len(failures))
+	}
+
+	for _, am := range failures {
+		history, report, err := fetchHistory(ctx, a, am.RegionId, am.TypeId)
+		var esiError *esi.EsiError
+		if errors.As(err, &esiError) && (esiError.Code == 404 || esiError.Code == 400) {
+			// skipped as in fetchHistoriesChunk
+			report.NotFound = true
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("History run %s: type %d in region %d failed again: %v", date, am.TypeId, am.RegionId, err)
+			continue
+		} else {
+			err = shared.Histories(a).Insert(ctx, []dbHistory{*history})
+			if err != nil {
+				return err
+			}
+		}
+
+		err = dbDeleteRunFailures(ctx, a, []activemarkets.ActiveMarket{am})
+		if err != nil {
+			return err
+		}
+		err = activemarkets.ReportHistories(ctx, a, []activemarkets.HistoryReport{report})
+		if err != nil {
+			log.Printf("Can't report history to active markets: %v", err)
+		}
 	}
 
 	return nil
 }
 
-func ComputeGobalHistories(ctx context.Context, day time.Time) error {
-	metricsEnabled := ctx.Value("metricsEnabled").(bool)
+func ComputeGobalHistories(ctx context.Context, a *app.App, day time.Time) error {
+	metricsEnabled := a.Config.Workers.Metrics
 
-	activeMarketsId, err := activemarkets.GetTypesId(ctx)
+	activeMarketsId, err := activemarkets.GetTypesId(ctx, a)
 	if err != nil {
 		return err
 	}
 
+	screenerRows := make([]screenerRow, 0, 16*len(activeMarketsId))
 	var dayDataPoints []metrics.DayDataPoint
+	tradedValues := make(metrics.TradedValues)
 	if metricsEnabled {
 		dayDataPoints = make([]metrics.DayDataPoint, 0, len(activeMarketsId))
 	}
@@ -80,21 +261,49 @@ func ComputeGobalHistories(ctx context.Context, day time.Time) error {
 			return err
 		}
 
-		histories, err := dbGetHistoriesOfType(ctx, typeId)
+		histories, err := shared.Histories(a).GetOfType(ctx, typeId)
 		if err != nil {
 			log.Printf("Can't get histories of type %d: %v", typeId, err)
 			continue
 		}
 		histories = filterOutEmptyHistories(histories)
 
-		err = computeGlobalHistoryOfType(ctx, histories, typeId)
+		globalHistory, err := computeAggregatedHistoryOfType(ctx, a, histories, typeId, 0)
 		if err != nil {
 			log.Printf("Can't compute global history for type %d: %v", typeId, err)
 			continue
 		}
+		screenerHistories := make([]dbHistory, len(histories), len(histories)+len(regions.Groups(a))+1)
+		copy(screenerHistories, histories)
+		if globalHistory != nil {
+			screenerHistories = append(screenerHistories, *globalHistory)
+		}
+
+		for _, group := range regions.Groups(a) {
+			groupHistories := filterGroupHistories(histories, group)
+			groupHistory, err := computeAggregatedHistoryOfType(ctx, a, groupHistories, typeId, group.Id)
+			if err != nil {
+				log.Printf("Can't compute %s history for type %d: %v", group.Name, typeId, err)
+			} else if groupHistory != nil {
+				screenerHistories = append(screenerHistories, *groupHistory)
+			}
+		}
+
+		for _, h := range screenerHistories {
+			row, err := computeScreenerRow(h)
+			if err != nil {
+				log.Printf("Can't compute screener row of type %d in region %d: %v", h.TypeId, h.RegionId, err)
+			} else if row != nil {
+				screenerRows = append(screenerRows, *row)
+			}
+		}
 
 		if metricsEnabled {
-			regionDataPoint, err := metrics.CreateRegionDayDataPoints(ctx, histories, day)
+			err = tradedValues.Add(histories, day)
+			if err != nil {
+				log.Printf("TradedValues.Add: %v\n", err)
+			}
+			regionDataPoint, err := metrics.CreateRegionDayDataPoints(ctx, a, histories, day)
 			if err != nil {
 				log.Printf("CreateRegionDayDataPoints: %v\n", err)
 				continue
@@ -103,55 +312,75 @@ func ComputeGobalHistories(ctx context.Context, day time.Time) error {
 		}
 	}
 
+	err = timerecord.Set(ctx, a, "HistoriesRefresh", a.Now())
+	if err != nil {
+		return fmt.Errorf("can't record histories refresh: %w", err)
+	}
+
+	err = dbReplaceScreener(ctx, a, screenerRows)
+	if err != nil {
+		log.Printf("Can't replace screener: %v", err)
+	}
+
 	if metricsEnabled {
-		err = metrics.InsertDayDataPoints(ctx, dayDataPoints)
+		err = metrics.InsertDayDataPoints(ctx, a, dayDataPoints)
 		if err != nil {
 			log.Printf("InsertDatDataPoints: %v\n", err)
 		}
-		err = metrics.ClearHotDataPoints(ctx, day)
+		err = metrics.ClearHotDataPoints(ctx, a, day)
 		if err != nil {
 			log.Printf("ClearHotDataPoints: %v\n", err)
 		}
+		err = metrics.InsertTradedValues(ctx, a, day, tradedValues)
+		if err != nil {
+			log.Printf("InsertTradedValues: %v\n", err)
+		}
 	}
 
 	return nil
 }
 
-func computeGlobalHistoryOfType(ctx context.Context, histories []dbHistory, typeId int) error {
-	regions := len(histories)
+// Merge the histories of typeId in different regions into a single history
+// stored under regionId. The merge of the average is weighted by volume.
+// The inserted history is returned, it is nil if histories is empty.
+func computeAggregatedHistoryOfType(ctx context.Context, a *app.App, histories []dbHistory, typeId int, regionId int) (*dbHistory, error) {
+	regionCount := len(histories)
 
-	if regions == 0 {
+	if regionCount == 0 {
 		// return fmt.Errorf("empty historiesOfType for type %d", typeId)
 		// here I do not throw as in practice I get a few empty ones
-		return nil
+		return nil, nil
 	}
 
-	if regions == 1 {
-		histories[0].RegionId = 0
-		err := dbInsertHistory(ctx, histories[0])
+	if regionCount == 1 {
+		// copy to avoid altering the RegionId of the caller's history
+		history := histories[0]
+		history.RegionId = regionId
+		err := shared.Histories(a).Insert(ctx, []dbHistory{history})
 		if err != nil {
-			return fmt.Errorf("can't insert history: %w", err)
+			return nil, fmt.Errorf("can't insert history: %w", err)
 		}
-		return nil
+		return &history, nil
 	}
 
-	esiHistories := make([][]esiHistoryDay, regions)
+	skipFilled := a.Config.Histories.SkipFilled
+	regionHistoryDays := make([][]dbHistoryDay, regionCount)
 	for i, h := range histories {
-		err := json.Unmarshal(h.History, &esiHistories[i])
+		err := json.Unmarshal(h.History, &regionHistoryDays[i])
 		if err != nil {
-			return fmt.Errorf("can't unmarshal history: %w", err)
+			return nil, fmt.Errorf("can't unmarshal history: %w", err)
 		}
 	}
 
 	var firstDate, lastDate time.Time
-	for _, ehd := range esiHistories {
-		fd, err := time.Parse(esi.DateLayout, ehd[0].Date)
+	for _, hd := range regionHistoryDays {
+		fd, err := time.Parse(esi.DateLayout, hd[0].Date)
 		if err != nil {
-			return fmt.Errorf("can't parse date: %w", err)
+			return nil, fmt.Errorf("can't parse date: %w", err)
 		}
-		ld, err := time.Parse(esi.DateLayout, ehd[len(ehd)-1].Date)
+		ld, err := time.Parse(esi.DateLayout, hd[len(hd)-1].Date)
 		if err != nil {
-			return fmt.Errorf("can't parse date: %w", err)
+			return nil, fmt.Errorf("can't parse date: %w", err)
 		}
 		if firstDate.IsZero() || fd.Before(firstDate) {
 			firstDate = fd
@@ -161,26 +390,26 @@ func computeGlobalHistoryOfType(ctx context.Context, histories []dbHistory, type
 		}
 	}
 	deltaDays := int(lastDate.Sub(firstDate).Hours() / 24)
-	globalEsiHistoryDays := make([]esiHistoryDay, deltaDays+1)
+	globalHistoryDays := make([]dbHistoryDay, deltaDays+1)
 
-	offsets := make([]int, regions)
+	offsets := make([]int, regionCount)
 	for i, d := 0, firstDate; i < deltaDays+1; i, d = i+1, d.AddDate(0, 0, 1) {
-		for j := 0; j < regions; j++ {
-			if offsets[j]+i >= len(esiHistories[j]) {
+		for j := 0; j < regionCount; j++ {
+			if offsets[j]+i >= len(regionHistoryDays[j]) {
 				continue
 			}
 
-			day := esiHistories[j][offsets[j]+i]
+			day := regionHistoryDays[j][offsets[j]+i]
 			date, err := time.Parse(esi.DateLayout, day.Date)
 			if err != nil {
-				return fmt.Errorf("can't parse date: %w", err)
+				return nil, fmt.Errorf("can't parse date: %w", err)
 			}
 			if !date.Equal(d) {
 				offsets[j]--
 				continue
 			}
 
-			gDay := &globalEsiHistoryDays[i]
+			gDay := &globalHistoryDays[i]
 			if gDay.Date == "" {
 				*gDay = day
 			} else {
@@ -196,45 +425,48 @@ func computeGlobalHistoryOfType(ctx context.Context, histories []dbHistory, type
 					}
 				}
 				gDay.Date = day.Date
-				gDay.OrderCount += day.OrderCount
+				// the unknown order counts of the filled days are left out
+				if gDay.OrderCount == unknownOrderCount {
+					gDay.OrderCount = day.OrderCount
+				} else if day.OrderCount != unknownOrderCount {
+					gDay.OrderCount += day.OrderCount
+				}
 				gDay.Volume += day.Volume
+				// a day is filled only if it is filled in every region
+				gDay.Filled = gDay.Filled && day.Filled
 			}
 		}
-		if globalEsiHistoryDays[i].Date == "" {
+		if globalHistoryDays[i].Date == "" {
 			if i == 0 {
 				panic("impossible point to reach")
 			}
-			// NOTE: It might be a good idea to set OrderCount to -1 and display
-			// "Unknown OrderCount" in the ui
-			globalEsiHistoryDays[i].Volume = 0
-			globalEsiHistoryDays[i].OrderCount = 0
-			globalEsiHistoryDays[i].Date = d.Format(esi.DateLayout)
-			globalEsiHistoryDays[i].Lowest = globalEsiHistoryDays[i-1].Average
-			globalEsiHistoryDays[i].Highest = globalEsiHistoryDays[i-1].Average
-			globalEsiHistoryDays[i].Average = globalEsiHistoryDays[i-1].Average
+			globalHistoryDays[i].Volume = 0
+			globalHistoryDays[i].OrderCount = unknownOrderCount
+			globalHistoryDays[i].Date = d.Format(esi.DateLayout)
+			globalHistoryDays[i].Lowest = globalHistoryDays[i-1].Average
+			globalHistoryDays[i].Highest = globalHistoryDays[i-1].Average
+			globalHistoryDays[i].Average = globalHistoryDays[i-1].Average
+			globalHistoryDays[i].Filled = true
 		}
 	}
 
-	globalHistoryDays, err := esiToDbHistoryDays(globalEsiHistoryDays)
-	if err != nil {
-		return fmt.Errorf("esi to db history: %w", err)
-	}
+	computeIndicators(globalHistoryDays, skipFilled)
 	globalHistoryDaysJson, err := json.Marshal(globalHistoryDays)
 	if err != nil {
-		return fmt.Errorf("history marshal: %w", err)
+		return nil, fmt.Errorf("history marshal: %w", err)
 	}
 	globalHistory := dbHistory{
 		History:  globalHistoryDaysJson,
-		RegionId: 0,
+		RegionId: regionId,
 		TypeId:   typeId,
 	}
 
-	err = dbInsertHistory(ctx, globalHistory)
+	err = shared.Histories(a).Insert(ctx, []dbHistory{globalHistory})
 	if err != nil {
-		return fmt.Errorf("can't insert history: %w", err)
+		return nil, fmt.Errorf("can't insert history: %w", err)
 	}
 
-	return nil
+	return &globalHistory, nil
 }
 
 func filterOutEmptyHistories(histories []dbHistory) []dbHistory {
@@ -246,3 +478,13 @@ func filterOutEmptyHistories(histories []dbHistory) []dbHistory {
 	}
 	return filteredHistories
 }
+
+func filterGroupHistories(histories []dbHistory, group regions.Group) []dbHistory {
+	groupHistories := make([]dbHistory, 0, len(group.Regions))
+	for i := range histories {
+		if group.Contains(histories[i].RegionId) {
+			groupHistories = append(groupHistories, histories[i])
+		}
+	}
+	return groupHistories
+}
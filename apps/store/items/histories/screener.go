@@ -0,0 +1,79 @@
+package histories
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The screener ranks the markets by the moves of their last history day. Its
+// rows are computed for every history during ComputeGobalHistories and stored
+// in the Screener table that only holds the last computation.
+type screenerRow struct {
+	TypeId      int     `json:"typeId"`
+	RegionId    int     `json:"regionId"`
+	Date        string  `json:"date"`
+	Average     float64 `json:"average"`
+	Volume      int64   `json:"volume"`
+	TradedValue float64 `json:"tradedValue"`
+	// Relative changes of the average price, 0.1 is +10%
+	DayChange  float64 `json:"dayChange"`
+	WeekChange float64 `json:"weekChange"`
+	// Volume relative to the average volume of the 20 previous days
+	VolumeRatio float64 `json:"volumeRatio"`
+	// 1 if the average broke above the donchian channel of the previous day, -1
+	// if it broke below and 0 otherwise
+	Breakout int `json:"breakout"`
+}
+
+const volumeBaselineDays = 20
+
+// WARN: nillable return value, nil if the history is too short
+func computeScreenerRow(history dbHistory) (*screenerRow, error) {
+	var historyDays []dbHistoryDay
+	err := json.Unmarshal(history.History, &historyDays)
+	if err != nil {
+		return nil, fmt.Errorf("can't unmarshal history: %w", err)
+	}
+	if len(historyDays) < 2 {
+		return nil, nil
+	}
+
+	last := historyDays[len(historyDays)-1]
+	previous := historyDays[len(historyDays)-2]
+	weekBefore := historyDays[max(0, len(historyDays)-8)]
+
+	row := screenerRow{
+		TypeId:      history.TypeId,
+		RegionId:    history.RegionId,
+		Date:        last.Date,
+		Average:     last.Average,
+		Volume:      last.Volume,
+		TradedValue: last.Average * float64(last.Volume),
+		DayChange:   relativeChange(previous.Average, last.Average),
+		WeekChange:  relativeChange(weekBefore.Average, last.Average),
+	}
+
+	baselineDays := historyDays[max(0, len(historyDays)-1-volumeBaselineDays) : len(historyDays)-1]
+	var baselineVolume int64 = 0
+	for _, d := range baselineDays {
+		baselineVolume += d.Volume
+	}
+	if baselineVolume > 0 {
+		row.VolumeRatio = float64(last.Volume) * float64(len(baselineDays)) / float64(baselineVolume)
+	}
+
+	if last.Average > previous.DonchianTop {
+		row.Breakout = 1
+	} else if last.Average < previous.DonchianBottom {
+		row.Breakout = -1
+	}
+
+	return &row, nil
+}
+
+func relativeChange(from float64, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return to/from - 1
+}
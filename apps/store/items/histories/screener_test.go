@@ -0,0 +1,53 @@
+package histories
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestScreenerRow(t *testing.T) {
+	historyDays := make([]dbHistoryDay, 0, 30)
+	for i := 0; i < 29; i++ {
+		historyDays = append(historyDays, dbHistoryDay{Date: "d", Average: 100, Highest: 110, Lowest: 90, Volume: 10})
+	}
+	historyDays[22].Average = 80
+	historyDays = append(historyDays, dbHistoryDay{Date: "last", Average: 120, Highest: 125, Lowest: 115, Volume: 50})
+	computeIndicators(historyDays, false)
+	historyJson, err := json.Marshal(historyDays)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := computeScreenerRow(dbHistory{TypeId: 34, RegionId: 10000002, History: historyJson})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := screenerRow{
+		TypeId:      34,
+		RegionId:    10000002,
+		Date:        "last",
+		Average:     120,
+		Volume:      50,
+		TradedValue: 6000,
+		DayChange:   0.2,
+		WeekChange:  0.5,
+		VolumeRatio: 5,
+		Breakout:    1,
+	}
+	if row == nil || math.Abs(row.DayChange-want.DayChange) > 1e-9 || math.Abs(row.WeekChange-want.WeekChange) > 1e-9 {
+		t.Fatalf("got %v, want %v", row, want)
+	}
+	row.DayChange, row.WeekChange = want.DayChange, want.WeekChange
+	if *row != want {
+		t.Errorf("got %v, want %v", *row, want)
+	}
+}
+
+func TestScreenerRowShortHistory(t *testing.T) {
+	historyJson, _ := json.Marshal([]dbHistoryDay{{Date: "d", Average: 100, Volume: 10}})
+	row, err := computeScreenerRow(dbHistory{TypeId: 34, RegionId: 10000002, History: historyJson})
+	if err != nil || row != nil {
+		t.Errorf("got %v %v, want nil row", row, err)
+	}
+}
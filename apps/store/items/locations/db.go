@@ -4,11 +4,30 @@ import (
 	"context"
 	"time"
 
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
 )
 
-func dbGetLocationCount(ctx context.Context) (int, error) {
-	db := ctx.Value("db").(*database.DB)
+// Repository of the locations
+type locationStore interface {
+	Count(ctx context.Context) (int, error)
+	// Structures of the orders that are not in the locations
+	GetUnknownStructures(ctx context.Context) ([]int64, error)
+	Add(ctx context.Context, locations []location) error
+	// Replace all the locations, the structures included
+	Replace(ctx context.Context, locations []location) error
+}
+
+func storeOf(a *app.App) locationStore {
+	return database.ForDriver[locationStore](a.DB, sqliteLocations{a: a}, postgresLocations{sqliteLocations{a: a}})
+}
+
+type sqliteLocations struct {
+	a *app.App
+}
+
+func (s sqliteLocations) Count(ctx context.Context) (int, error) {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
 	defer cancel()
 
@@ -21,8 +40,8 @@ func dbGetLocationCount(ctx context.Context) (int, error) {
 	return count, nil
 }
 
-func dbGetUnknownStructures(ctx context.Context) ([]int64, error) {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteLocations) GetUnknownStructures(ctx context.Context) ([]int64, error) {
+	db := s.a.DB
 	unknownStructures := make([]int64, 0)
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
@@ -57,19 +76,34 @@ func dbGetUnknownStructures(ctx context.Context) ([]int64, error) {
 	return unknownStructures, nil
 }
 
-func dbAddLocations(ctx context.Context, locations []location) error {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteLocations) Add(ctx context.Context, locations []location) error {
+	return s.write(ctx, locations, false)
+}
+
+func (s sqliteLocations) Replace(ctx context.Context, locations []location) error {
+	return s.write(ctx, locations, true)
+}
+
+func (s sqliteLocations) write(ctx context.Context, locations []location, replace bool) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	// NOTE: the transaction here is not strictly necessary, if it lock the db
-	// for too long I may to remove it.
+	// NOTE: the transaction is only needed by Replace, if it lock the db for
+	// too long I may remove it from Add.
 	tx, err := db.Begin(timeoutCtx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if replace {
+		_, err = tx.Exec(timeoutCtx, "DELETE FROM Location")
+		if err != nil {
+			return err
+		}
+	}
+
 	stmt, err := tx.PrepareWrite(timeoutCtx, "INSERT INTO Location VALUES (?,?,?,?)")
 	if err != nil {
 		return err
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
@@ -12,9 +13,9 @@ type esiStructure struct {
 	SystemId int32  `json:"solar_system_id"`
 }
 
-func fetchStrcutreInfo(ctx context.Context, structureId int64) (*esiStructure, error) {
+func fetchStrcutreInfo(ctx context.Context, a *app.App, structureId int64) (*esiStructure, error) {
 	uri := fmt.Sprintf("/universe/structures/%d", structureId)
-	response, err := esi.EsiFetch[esiStructure](ctx, "GET", uri, nil, true, 1, 1)
+	response, err := esi.EsiFetch[esiStructure](ctx, a.Esi, "GET", uri, nil, true, 1, 1)
 	if err != nil {
 		return nil, fmt.Errorf("fetching esi strucure info: %w", err)
 	}
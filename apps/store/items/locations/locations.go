@@ -10,11 +10,12 @@ import (
 	"io"
 	"log"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/raph5/eve-market-browser/apps/store/items/systems"
 	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
-	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
@@ -28,27 +29,36 @@ type location struct {
 //go:embed data/staStations.csv
 var stationCsv []byte
 
-// forbiddenLocations will be reset at each restart
-var forbiddenLocations = make(map[int64]struct{})
+// The structures whose info the esi refuses, they are forgotten at each restart
+type forbiddenLocations struct {
+	mu  sync.Mutex
+	ids map[int64]struct{}
+}
+
+func getForbiddenLocations(a *app.App) *forbiddenLocations {
+	return app.State(a, "locations", func() *forbiddenLocations {
+		return &forbiddenLocations{ids: make(map[int64]struct{})}
+	})
+}
 
-func Init(ctx context.Context) error {
-	count, err := dbGetLocationCount(ctx)
+func Init(ctx context.Context, a *app.App) error {
+	count, err := storeOf(a).Count(ctx)
 	if err != nil {
 		return err
 	}
-	expiration, err := timerecord.Get(ctx, "LocationExpiration")
+	expiration, err := timerecord.Get(ctx, a, "LocationExpiration")
 	if err != nil {
 		return err
 	}
 
-	now := time.Now()
+	now := a.Now()
 	if count == 0 || now.After(expiration) {
 		log.Println("Initializing locations")
-		err = populateStation(ctx)
+		err = populateStation(ctx, a)
 		if err != nil {
 			return err
 		}
-		err = timerecord.Set(ctx, "LocationExpiration", expiration.Add(7*24*time.Hour))
+		err = timerecord.Set(ctx, a, "LocationExpiration", expiration.Add(7*24*time.Hour))
 		if err != nil {
 			return err
 		}
@@ -58,23 +68,27 @@ func Init(ctx context.Context) error {
 	return nil
 }
 
-func PopulateStructure(ctx context.Context) error {
-	unknownIds, err := dbGetUnknownStructures(ctx)
+func PopulateStructure(ctx context.Context, a *app.App) error {
+	unknownIds, err := storeOf(a).GetUnknownStructures(ctx)
 	if err != nil {
-		return fmt.Errorf("dbGetUnknownStructures: %w", err)
+		return fmt.Errorf("GetUnknownStructures: %w", err)
 	}
 
+	forbidden := getForbiddenLocations(a)
+	forbidden.mu.Lock()
+	defer forbidden.mu.Unlock()
+
 	var newLocations []location
 	for _, id := range unknownIds {
-		_, isForbidden := forbiddenLocations[id]
+		_, isForbidden := forbidden.ids[id]
 		if isForbidden {
 			continue
 		}
 
-		info, err := fetchStrcutreInfo(ctx, id)
+		info, err := fetchStrcutreInfo(ctx, a, id)
 		var esiError *esi.EsiError
 		if errors.As(err, &esiError) {
-			forbiddenLocations[id] = struct{}{}
+			forbidden.ids[id] = struct{}{}
 			continue
 		}
 		if err != nil {
@@ -94,16 +108,16 @@ func PopulateStructure(ctx context.Context) error {
 	}
 
 	if len(newLocations) > 0 {
-		err = dbAddLocations(ctx, newLocations)
+		err = storeOf(a).Add(ctx, newLocations)
 		if err != nil {
-			return fmt.Errorf("dbAddLocations: %w", err)
+			return fmt.Errorf("Add: %w", err)
 		}
 	}
 
 	return nil
 }
 
-func populateStation(ctx context.Context) error {
+func populateStation(ctx context.Context, a *app.App) error {
 	r := csv.NewReader(bytes.NewReader(stationCsv))
 	record, err := r.Read()
 	if err != nil {
@@ -113,27 +127,7 @@ func populateStation(ctx context.Context) error {
 		return errors.New("invalid station csv header")
 	}
 
-	db := ctx.Value("db").(*database.DB)
-	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-
-	tx, err := db.Begin(timeoutCtx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	_, err = tx.Exec(timeoutCtx, "DELETE FROM Location")
-	if err != nil {
-		return err
-	}
-
-	stmt, err := tx.PrepareWrite(timeoutCtx, "INSERT INTO Location VALUES (?,?,?,?)")
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
+	stations := make([]location, 0, 8192)
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -157,16 +151,13 @@ func populateStation(ctx context.Context) error {
 			return err
 		}
 
-		_, err = stmt.Exec(timeoutCtx, id, systemId, name, security)
-		if err != nil {
-			return err
-		}
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		return err
+		stations = append(stations, location{
+			id:       int64(id),
+			name:     name,
+			systemId: int32(systemId),
+			security: float32(security),
+		})
 	}
 
-	return nil
+	return storeOf(a).Replace(ctx, stations)
 }
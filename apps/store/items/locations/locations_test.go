@@ -5,6 +5,8 @@ import (
 	"encoding/csv"
 	"strconv"
 	"testing"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
 )
 
 func TestLoadStationCsv(t *testing.T) {
@@ -27,3 +29,49 @@ func TestLoadStationCsv(t *testing.T) {
 	}
 	t.Log(stationId)
 }
+
+func TestLocationStore(t *testing.T) {
+	stores, ctx := apptest.Databases(t, "Location", `"Order"`)
+	for driver, a := range stores {
+		err := storeOf(a).Replace(ctx, []location{{id: 60003760, name: "Jita IV - Moon 4", systemId: 30000142, security: 0.9}})
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		_, err = a.DB.Exec(ctx, `INSERT INTO "Order" (Id, RegionId, LocationId, TypeId) VALUES (1, 10000002, 60003760, 34), (2, 10000002, 1035466617946, 34)`)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+
+		unknown, err := storeOf(a).GetUnknownStructures(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if len(unknown) != 1 || unknown[0] != 1035466617946 {
+			t.Fatalf("%s: expected the structure of the order 2 to be unknown, got %v", driver, unknown)
+		}
+		err = storeOf(a).Add(ctx, []location{{id: 1035466617946, name: "Perimeter - Tranquility Trading Tower", systemId: 30000144, security: 0.9}})
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		count, err := storeOf(a).Count(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if count != 2 {
+			t.Errorf("%s: expected 2 locations, got %d", driver, count)
+		}
+
+		// the structures go with the replace
+		err = storeOf(a).Replace(ctx, []location{{id: 60003760, name: "Jita IV - Moon 4", systemId: 30000142, security: 0.9}})
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		count, err = storeOf(a).Count(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if count != 1 {
+			t.Errorf("%s: expected 1 location after the replace, got %d", driver, count)
+		}
+	}
+}
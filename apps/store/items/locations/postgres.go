@@ -0,0 +1,62 @@
+package locations
+
+import (
+	"context"
+	"time"
+)
+
+// The locations are written with a COPY, the reads are the queries of
+// sqliteLocations
+type postgresLocations struct {
+	sqliteLocations
+}
+
+func (s postgresLocations) Add(ctx context.Context, locations []location) error {
+	return s.write(ctx, locations, false)
+}
+
+func (s postgresLocations) Replace(ctx context.Context, locations []location) error {
+	return s.write(ctx, locations, true)
+}
+
+func (s postgresLocations) write(ctx context.Context, locations []location, replace bool) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		_, err = tx.Exec(timeoutCtx, "DELETE FROM Location")
+		if err != nil {
+			return err
+		}
+	}
+
+	// NOTE: no other statement of the transaction can run during the copy
+	copyStmt, err := tx.PrepareCopy(timeoutCtx, "Location", "Id", "SystemId", "Name", "Security")
+	if err != nil {
+		return err
+	}
+	defer copyStmt.Close()
+	for _, l := range locations {
+		_, err = copyStmt.Exec(timeoutCtx, l.id, l.systemId, l.name, l.security)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = copyStmt.Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	err = copyStmt.Close()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+// Market groups are read from the market-group.json file of the esi cache of
+// the website (ESI_CACHE). The store does not fetch them itself as the website
+// already keeps them up to date.
+
+package marketgroups
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type marketGroup struct {
+	Id       int   `json:"id"`
+	ChildsId []int `json:"childsId"`
+	Types    []int `json:"types"`
+}
+
+var ErrNotLoaded = errors.New("market groups not loaded")
+
+// groups and marketTypes are nil until Load succeeds
+var groups map[int]marketGroup
+var marketTypes map[int]struct{}
+
+func Load(path string) error {
+	groupsJson, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read market groups file: %w", err)
+	}
+
+	var groupsList []marketGroup
+	err = json.Unmarshal(groupsJson, &groupsList)
+	if err != nil {
+		return fmt.Errorf("unmarshal market groups: %w", err)
+	}
+
+	groupsMap := make(map[int]marketGroup, len(groupsList))
+	typesSet := make(map[int]struct{})
+	for _, g := range groupsList {
+		groupsMap[g.Id] = g
+		for _, typeId := range g.Types {
+			typesSet[typeId] = struct{}{}
+		}
+	}
+	groups = groupsMap
+	marketTypes = typesSet
+	return nil
+}
+
+// Return the types of groupId and of all its sub groups. ok is false if the
+// group does not exist.
+func GetTypes(groupId int) (types []int, ok bool, err error) {
+	if groups == nil {
+		return nil, false, ErrNotLoaded
+	}
+	if _, ok := groups[groupId]; !ok {
+		return nil, false, nil
+	}
+
+	types = make([]int, 0, 64)
+	visited := make(map[int]struct{})
+	stack := []int{groupId}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		g := groups[id]
+		types = append(types, g.Types...)
+		stack = append(stack, g.ChildsId...)
+	}
+	return types, true, nil
+}
+
+// Whether typeId is a type sold on the market
+func IsMarketType(typeId int) (bool, error) {
+	if marketTypes == nil {
+		return false, ErrNotLoaded
+	}
+	_, ok := marketTypes[typeId]
+	return ok, nil
+}
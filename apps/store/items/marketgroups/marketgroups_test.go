@@ -0,0 +1,57 @@
+package marketgroups
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestGetTypes(t *testing.T) {
+	groups = nil
+	_, _, err := GetTypes(1)
+	if !errors.Is(err, ErrNotLoaded) {
+		t.Errorf("expected ErrNotLoaded, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "market-group.json")
+	groupsJson := `[
+    {"id": 1, "parentId": null, "childsId": [2, 3], "name": "a", "types": []},
+    {"id": 2, "parentId": 1, "childsId": [], "name": "b", "types": [34, 35]},
+    {"id": 3, "parentId": 1, "childsId": [4], "name": "c", "types": [36]},
+    {"id": 4, "parentId": 3, "childsId": [], "name": "d", "types": [37]}
+  ]`
+	err = os.WriteFile(path, []byte(groupsJson), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types, ok, err := GetTypes(1)
+	slices.Sort(types)
+	if !ok || err != nil || !slices.Equal(types, []int{34, 35, 36, 37}) {
+		t.Errorf("got %v %v %v", types, ok, err)
+	}
+	types, ok, err = GetTypes(3)
+	slices.Sort(types)
+	if !ok || err != nil || !slices.Equal(types, []int{36, 37}) {
+		t.Errorf("got %v %v %v", types, ok, err)
+	}
+	_, ok, err = GetTypes(5)
+	if ok || err != nil {
+		t.Errorf("expected unknown group, got %v %v", ok, err)
+	}
+
+	ok, err = IsMarketType(37)
+	if !ok || err != nil {
+		t.Errorf("expected 37 to be a market type, got %v %v", ok, err)
+	}
+	ok, err = IsMarketType(38)
+	if ok || err != nil {
+		t.Errorf("expected 38 not to be a market type, got %v %v", ok, err)
+	}
+}
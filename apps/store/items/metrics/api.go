@@ -5,15 +5,19 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
 )
 
 type apiItemStats struct {
 	TypeId          int     `json:"typeId"`
 	RegionId        int     `json:"regionId"`
-	Year            int     `json:"year"`
-	Day             int     `json:"day"`
+	LocationId      int     `json:"locationId,omitempty"`
+	Date            string  `json:"date"`
 	Volume          int64   `json:"volume"`
 	WeeklyVolume    int64   `json:"weeklyVolume"`
 	SellPrice       float64 `json:"sellPrice"`
@@ -22,7 +26,78 @@ type apiItemStats struct {
 	WeeklyBuyPrice  float64 `json:"weeklyBuyPrice"`
 }
 
-func CreateItemStatsHandler(ctx context.Context) http.HandlerFunc {
+type apiDayStats struct {
+	Date      string  `json:"date"`
+	Volume    int64   `json:"volume"`
+	SellPrice float64 `json:"sellPrice"`
+	BuyPrice  float64 `json:"buyPrice"`
+}
+
+type apiItemStatsRange struct {
+	TypeId   int           `json:"typeId"`
+	RegionId int           `json:"regionId"`
+	Days     []apiDayStats `json:"days"`
+}
+
+type apiTopType struct {
+	TypeId      int     `json:"typeId"`
+	RegionId    int     `json:"regionId"`
+	Date        string  `json:"date"`
+	Volume      int64   `json:"volume"`
+	SellPrice   float64 `json:"sellPrice"`
+	BuyPrice    float64 `json:"buyPrice"`
+	TradedValue float64 `json:"tradedValue"`
+}
+
+type apiIntradayPoint struct {
+	Time      int64   `json:"time"`
+	BuyPrice  float64 `json:"buyPrice"`
+	SellPrice float64 `json:"sellPrice"`
+}
+
+type apiIntraday struct {
+	TypeId     int                `json:"typeId"`
+	RegionId   int                `json:"regionId"`
+	Resolution string             `json:"resolution"`
+	Points     []apiIntradayPoint `json:"points"`
+}
+
+type apiGlobalDay struct {
+	Date           string  `json:"date"`
+	BuyValue       float64 `json:"buyValue"`
+	SellValue      float64 `json:"sellValue"`
+	BuyOrderCount  float64 `json:"buyOrderCount"`
+	SellOrderCount float64 `json:"sellOrderCount"`
+	ActiveTypes    float64 `json:"activeTypes"`
+	PriceIndex     float64 `json:"priceIndex"`
+	TradedValue    float64 `json:"tradedValue"`
+}
+
+type apiGlobalRange struct {
+	RegionId int            `json:"regionId"`
+	Days     []apiGlobalDay `json:"days"`
+}
+
+type apiGlobalNow struct {
+	RegionId       int     `json:"regionId"`
+	Time           int64   `json:"time"`
+	BuyValue       float64 `json:"buyValue"`
+	SellValue      float64 `json:"sellValue"`
+	BuyOrderCount  int     `json:"buyOrderCount"`
+	SellOrderCount int     `json:"sellOrderCount"`
+	ActiveTypes    int     `json:"activeTypes"`
+	PriceIndex     float64 `json:"priceIndex"`
+}
+
+const maxRangeDays = 366
+const maxTopTypes = 500
+
+// Serve /stats?type=&region=&date=&estimator=&location=
+// If date is omitted, the stats of the last available day are returned.
+// location restricts the prices to a trade hub station or a security band of
+// the region. The volumes stay the ones of the whole region as the histories
+// are only available per region.
+func CreateItemStatsHandler(ctx context.Context, a *app.App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
@@ -30,26 +105,78 @@ func CreateItemStatsHandler(ctx context.Context) http.HandlerFunc {
 		query := r.URL.Query()
 		typeId, err := strconv.Atoi(query.Get("type"))
 		if err != nil {
-			http.Error(w, `Bad request: param "type" is invalid integer`, 400)
+			openapi.ParamError(w, "type", "is invalid integer")
 			return
 		}
 		regionId, err := strconv.Atoi(query.Get("region"))
 		if err != nil {
-			http.Error(w, `Bad request: param "region" is invalid integer`, 400)
+			openapi.ParamError(w, "region", "is invalid integer")
 			return
 		}
-		date, err := time.Parse(dateLayout, query.Get("region"))
-		if err != nil {
-			http.Error(w, `Bad request: param "date" is invalid date of format YYYY-MM-DD`, 400)
+		estimator, ok := parseEstimator(query.Get("estimator"))
+		if !ok {
+			openapi.ParamError(w, "estimator", "is not a valid estimator")
 			return
 		}
+		locationId := 0
+		if query.Get("location") != "" {
+			locationId, ok = parseLocation(ctx, a, query.Get("location"))
+			if !ok {
+				openapi.ParamError(w, "location", "must be a trade hub station id, highsec, lowsec or nullsec")
+				return
+			}
+			if estimator != BestEstimator {
+				openapi.ParamError(w, "estimator", `is not available with param "location"`)
+				return
+			}
+		}
 
-		stats, err := dbGetItemStats(timeoutCtx, typeId, regionId, date)
+		var date time.Time
+		if query.Get("date") == "" {
+			lastDate, err := storeOf(a).GetLastItemStatsDate(timeoutCtx, typeId, regionId)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+			if lastDate == nil {
+				http.Error(w, "Stats not available", 404)
+				return
+			}
+			date = *lastDate
+		} else {
+			date, err = time.Parse(dateLayout, query.Get("date"))
+			if err != nil {
+				openapi.ParamError(w, "date", "is invalid date of format YYYY-MM-DD")
+				return
+			}
+		}
+
+		stats, err := storeOf(a).GetItemStats(timeoutCtx, typeId, regionId, date)
 		if err != nil {
 			log.Printf("Internal server error: %v", err)
 			http.Error(w, "Internal server error", 500)
 			return
 		}
+		if stats != nil && locationId != 0 {
+			stats, err = storeOf(a).SetLocationPrices(timeoutCtx, stats, locationId, date)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+		} else if stats != nil && estimator != BestEstimator {
+			stats, err = storeOf(a).SetEstimatedPrices(timeoutCtx, stats, estimator, date)
+			if err != nil {
+				log.Printf("Internal server error: %v", err)
+				http.Error(w, "Internal server error", 500)
+				return
+			}
+		}
+		if stats == nil {
+			http.Error(w, "Stats not available", 404)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		err = json.NewEncoder(w).Encode(stats)
@@ -60,3 +187,395 @@ func CreateItemStatsHandler(ctx context.Context) http.HandlerFunc {
 		}
 	}
 }
+
+// Serve /stats/range?type=&region=&from=&to=&estimator=&location=
+func CreateItemStatsRangeHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		typeId, err := strconv.Atoi(query.Get("type"))
+		if err != nil {
+			openapi.ParamError(w, "type", "is invalid integer")
+			return
+		}
+		regionId, err := strconv.Atoi(query.Get("region"))
+		if err != nil {
+			openapi.ParamError(w, "region", "is invalid integer")
+			return
+		}
+		estimator, ok := parseEstimator(query.Get("estimator"))
+		if !ok {
+			openapi.ParamError(w, "estimator", "is not a valid estimator")
+			return
+		}
+		locationId := 0
+		if query.Get("location") != "" {
+			locationId, ok = parseLocation(ctx, a, query.Get("location"))
+			if !ok {
+				openapi.ParamError(w, "location", "must be a trade hub station id, highsec, lowsec or nullsec")
+				return
+			}
+			if estimator != BestEstimator {
+				openapi.ParamError(w, "estimator", `is not available with param "location"`)
+				return
+			}
+		}
+		from, err := time.Parse(dateLayout, query.Get("from"))
+		if err != nil {
+			openapi.ParamError(w, "from", "is invalid date of format YYYY-MM-DD")
+			return
+		}
+		to, err := time.Parse(dateLayout, query.Get("to"))
+		if err != nil {
+			openapi.ParamError(w, "to", "is invalid date of format YYYY-MM-DD")
+			return
+		}
+		if to.Before(from) || to.Sub(from) > maxRangeDays*24*time.Hour {
+			http.Error(w, `Bad request: range from "from" to "to" must be between 0 and 366 days`, 400)
+			return
+		}
+
+		days, err := storeOf(a).GetItemStatsRange(timeoutCtx, typeId, regionId, from, to, estimator, locationId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(apiItemStatsRange{TypeId: typeId, RegionId: regionId, Days: days})
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Serve /stats/top?region=&date=&limit=
+// Types of region with the highest traded value on date
+func CreateTopTypesHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		regionId, err := strconv.Atoi(query.Get("region"))
+		if err != nil {
+			openapi.ParamError(w, "region", "is invalid integer")
+			return
+		}
+		date, err := time.Parse(dateLayout, query.Get("date"))
+		if err != nil {
+			openapi.ParamError(w, "date", "is invalid date of format YYYY-MM-DD")
+			return
+		}
+		limit := 50
+		if query.Get("limit") != "" {
+			limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || limit <= 0 || limit > maxTopTypes {
+				openapi.ParamError(w, "limit", "must be an integer between 1 and 500")
+				return
+			}
+		}
+
+		topTypes, err := storeOf(a).GetTopTypes(timeoutCtx, regionId, date, limit)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(topTypes)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Serve /intraday?type=&region=&from=&to=&resolution=&estimator=&location=
+// from and to are epoch seconds and default to the last 24 hours. resolution
+// is 1h for the hourly rollups of the best prices, up to the last complete
+// hour, or 10m for the raw hot data points of the estimators and locations
+// (only the last 1 to 2 days are available).
+func CreateIntradayHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		typeId, err := strconv.Atoi(query.Get("type"))
+		if err != nil {
+			openapi.ParamError(w, "type", "is invalid integer")
+			return
+		}
+		regionId, err := strconv.Atoi(query.Get("region"))
+		if err != nil {
+			openapi.ParamError(w, "region", "is invalid integer")
+			return
+		}
+		estimator, ok := parseEstimator(query.Get("estimator"))
+		if !ok {
+			openapi.ParamError(w, "estimator", "is not a valid estimator")
+			return
+		}
+		locationId := 0
+		if query.Get("location") != "" {
+			locationId, ok = parseLocation(ctx, a, query.Get("location"))
+			if !ok {
+				openapi.ParamError(w, "location", "must be a trade hub station id, highsec, lowsec or nullsec")
+				return
+			}
+			if estimator != BestEstimator {
+				openapi.ParamError(w, "estimator", `is not available with param "location"`)
+				return
+			}
+		}
+		to := a.Now()
+		if query.Get("to") != "" {
+			toEpoch, err := strconv.ParseInt(query.Get("to"), 10, 64)
+			if err != nil {
+				openapi.ParamError(w, "to", "is invalid epoch seconds")
+				return
+			}
+			to = time.Unix(toEpoch, 0)
+		}
+		from := to.Add(-24 * time.Hour)
+		if query.Get("from") != "" {
+			fromEpoch, err := strconv.ParseInt(query.Get("from"), 10, 64)
+			if err != nil {
+				openapi.ParamError(w, "from", "is invalid epoch seconds")
+				return
+			}
+			from = time.Unix(fromEpoch, 0)
+		}
+		if to.Before(from) || to.Sub(from) > maxRangeDays*24*time.Hour {
+			http.Error(w, `Bad request: range from "from" to "to" must be between 0 and 366 days`, 400)
+			return
+		}
+
+		resolution := query.Get("resolution")
+		if resolution == "" && (locationId != 0 || estimator != BestEstimator) {
+			resolution = "10m"
+		} else if resolution == "" {
+			resolution = "1h"
+		}
+		if resolution != "10m" && resolution != "1h" {
+			openapi.ParamError(w, "resolution", "must be 10m or 1h")
+			return
+		}
+
+		var points []apiIntradayPoint
+		if locationId != 0 && resolution == "10m" {
+			points, err = storeOf(a).GetIntradayLocationPoints(timeoutCtx, locationId, typeId, regionId, from, to)
+		} else if locationId != 0 {
+			openapi.ParamError(w, "location", "is only available at resolution 10m")
+			return
+		} else if estimator == BestEstimator && resolution == "1h" {
+			points, err = storeOf(a).GetIntradayPoints(timeoutCtx, typeId, regionId, from, to)
+		} else if estimator == BestEstimator {
+			http.Error(w, `Bad request: resolution 10m is only available with param "estimator" or "location"`, 400)
+			return
+		} else if resolution == "10m" {
+			points, err = storeOf(a).GetIntradayEstimates(timeoutCtx, estimator, typeId, regionId, from, to)
+		} else {
+			openapi.ParamError(w, "estimator", "is only available at resolution 10m")
+			return
+		}
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(apiIntraday{
+			TypeId:     typeId,
+			RegionId:   regionId,
+			Resolution: resolution,
+			Points:     points,
+		})
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Serve /global?region=&from=&to=
+// Daily metrics of the whole market of region, 0 for all regions. The day
+// values are the averages of the hot data points of the day except for the
+// traded value that comes from the histories.
+func CreateGlobalHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		regionId, err := strconv.Atoi(query.Get("region"))
+		if err != nil {
+			openapi.ParamError(w, "region", "is invalid integer")
+			return
+		}
+		from, err := time.Parse(dateLayout, query.Get("from"))
+		if err != nil {
+			openapi.ParamError(w, "from", "is invalid date of format YYYY-MM-DD")
+			return
+		}
+		to, err := time.Parse(dateLayout, query.Get("to"))
+		if err != nil {
+			openapi.ParamError(w, "to", "is invalid date of format YYYY-MM-DD")
+			return
+		}
+		if to.Before(from) || to.Sub(from) > maxRangeDays*24*time.Hour {
+			http.Error(w, `Bad request: range from "from" to "to" must be between 0 and 366 days`, 400)
+			return
+		}
+
+		days, err := storeOf(a).GetGlobalDays(timeoutCtx, regionId, from, to)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(apiGlobalRange{RegionId: regionId, Days: days})
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// Serve /global/now?region=
+// Last hot metrics of the whole market of region, 0 for all regions
+func CreateGlobalNowHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+
+		query := r.URL.Query()
+		regionId, err := strconv.Atoi(query.Get("region"))
+		if err != nil {
+			openapi.ParamError(w, "region", "is invalid integer")
+			return
+		}
+
+		now, err := storeOf(a).GetLastHotGlobalDataPoint(timeoutCtx, regionId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		if now == nil {
+			http.Error(w, "Metrics not available", 404)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(now)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+// estimator defaults to the best estimator. Only the estimators enabled with
+// -metric-estimators have data.
+func parseEstimator(estimator string) (string, bool) {
+	if estimator == "" {
+		return BestEstimator, true
+	}
+	return estimator, ValidEstimator(estimator)
+}
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	typeParam := openapi.Param{Name: "type", Type: "integer", Required: true, Description: "Type id"}
+	regionParam := openapi.Param{Name: "region", Type: "integer", Required: true, Description: "Region id, 0 for the global market"}
+	estimatorNames := make([]string, 0, len(estimators))
+	for name := range estimators {
+		estimatorNames = append(estimatorNames, name)
+	}
+	sort.Strings(estimatorNames)
+	estimatorParam := openapi.Param{Name: "estimator", Type: "string", Enum: estimatorNames, Description: "Price estimator, best by default"}
+	locationParam := openapi.Param{Name: "location", Type: "string", Description: "highsec, lowsec, nullsec or the station id of a trade hub"}
+
+	return []openapi.Operation{
+		{
+			Path:     "/stats",
+			Summary:  "Volume and prices of a type on a day",
+			Params:   []openapi.Param{typeParam, regionParam, {Name: "date", Type: "string", Description: "YYYY-MM-DD, last available day by default"}, estimatorParam, locationParam},
+			Response: apiItemStats{},
+			Handler:  CreateItemStatsHandler(ctx, a),
+		},
+		{
+			Path:    "/stats/range",
+			Summary: "Daily volume and prices of a type",
+			Params: []openapi.Param{
+				typeParam,
+				regionParam,
+				{Name: "from", Type: "string", Required: true, Description: "YYYY-MM-DD"},
+				{Name: "to", Type: "string", Required: true, Description: "YYYY-MM-DD, at most 366 days after from"},
+				estimatorParam,
+				locationParam,
+			},
+			Response: apiItemStatsRange{},
+			Handler:  CreateItemStatsRangeHandler(ctx, a),
+		},
+		{
+			Path:    "/stats/top",
+			Summary: "Most traded types of a day",
+			Params: []openapi.Param{
+				regionParam,
+				{Name: "date", Type: "string", Required: true, Description: "YYYY-MM-DD"},
+				{Name: "limit", Type: "integer", Description: "At most 500"},
+			},
+			Response: []apiTopType{},
+			Handler:  CreateTopTypesHandler(ctx, a),
+		},
+		{
+			Path:    "/intraday",
+			Summary: "Best prices of a type during the day",
+			Params: []openapi.Param{
+				typeParam,
+				regionParam,
+				{Name: "from", Type: "integer", Description: "Epoch seconds, 24 hours ago by default"},
+				{Name: "to", Type: "integer", Description: "Epoch seconds, now by default"},
+				{Name: "resolution", Type: "string", Enum: []string{"10m", "1h"}, Description: "1h for the best prices, 10m for estimator and location"},
+				estimatorParam,
+				locationParam,
+			},
+			Response: apiIntraday{},
+			Handler:  CreateIntradayHandler(ctx, a),
+		},
+		{
+			Path:    "/global",
+			Summary: "Daily market wide metrics",
+			Params: []openapi.Param{
+				regionParam,
+				{Name: "from", Type: "string", Required: true, Description: "YYYY-MM-DD"},
+				{Name: "to", Type: "string", Required: true, Description: "YYYY-MM-DD"},
+			},
+			Response: apiGlobalRange{},
+			Handler:  CreateGlobalHandler(ctx, a),
+		},
+		{
+			Path:     "/global/now",
+			Summary:  "Latest market wide metrics",
+			Params:   []openapi.Param{regionParam},
+			Response: apiGlobalNow{},
+			Handler:  CreateGlobalNowHandler(ctx, a),
+		},
+	}
+}
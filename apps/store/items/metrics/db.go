@@ -6,11 +6,49 @@ import (
 	"errors"
 	"time"
 
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
 )
 
-func dbInsertHotDataPoints(ctx context.Context, dps []hotDataPoint) error {
-	db := ctx.Value("db").(*database.DB)
+// Repository of the type, location and global metrics
+type metricStore interface {
+	InsertHotDataPoints(ctx context.Context, dps []hotDataPoint) error
+	GetHotDataPointTypeIds(ctx context.Context, after time.Time, before time.Time) ([]int, error)
+	GetHotDataPointOfTypeId(ctx context.Context, typeId int, after time.Time, before time.Time) ([]hotDataPoint, error)
+	RollupHotTypeDataPoints(ctx context.Context, from time.Time, to time.Time) error
+	ClearHotTypeDataPoints(ctx context.Context, before time.Time, hourlyBefore time.Time) error
+	GetIntradayPoints(ctx context.Context, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error)
+	GetItemStats(ctx context.Context, typeId int, regionId int, date time.Time) (*apiItemStats, error)
+	GetLastItemStatsDate(ctx context.Context, typeId int, regionId int) (*time.Time, error)
+	GetItemStatsRange(ctx context.Context, typeId int, regionId int, from time.Time, to time.Time, estimator string, locationId int) ([]apiDayStats, error)
+	GetTopTypes(ctx context.Context, regionId int, date time.Time, limit int) ([]apiTopType, error)
+	InsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error
+	InsertHotEstimates(ctx context.Context, estimates []hotEstimate) error
+	RollupHotEstimates(ctx context.Context, after time.Time, before time.Time, date time.Time) error
+	GetIntradayEstimates(ctx context.Context, estimator string, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error)
+	SetEstimatedPrices(ctx context.Context, stats *apiItemStats, estimator string, date time.Time) (*apiItemStats, error)
+	GetLocationSecurities(ctx context.Context) (map[int]float64, error)
+	InsertHotLocationDataPoints(ctx context.Context, dps []hotLocationDataPoint) error
+	RollupHotLocationDataPoints(ctx context.Context, after time.Time, before time.Time, date time.Time) error
+	GetIntradayLocationPoints(ctx context.Context, locationId int, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error)
+	SetLocationPrices(ctx context.Context, stats *apiItemStats, locationId int, date time.Time) (*apiItemStats, error)
+	InsertHotGlobalDataPoints(ctx context.Context, dps []hotGlobalDataPoint) error
+	RollupHotGlobalDataPoints(ctx context.Context, after time.Time, before time.Time, date time.Time) error
+	InsertTradedValues(ctx context.Context, date time.Time, tradedValues TradedValues) error
+	GetGlobalDays(ctx context.Context, regionId int, from time.Time, to time.Time) ([]apiGlobalDay, error)
+	GetLastHotGlobalDataPoint(ctx context.Context, regionId int) (*apiGlobalNow, error)
+}
+
+func storeOf(a *app.App) metricStore {
+	return database.ForDriver[metricStore](a.DB, sqliteMetrics{a: a}, postgresMetrics{sqliteMetrics{a: a}})
+}
+
+type sqliteMetrics struct {
+	a *app.App
+}
+
+func (s sqliteMetrics) InsertHotDataPoints(ctx context.Context, dps []hotDataPoint) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
 	defer cancel()
 
@@ -42,8 +80,8 @@ func dbInsertHotDataPoints(ctx context.Context, dps []hotDataPoint) error {
 	return nil
 }
 
-func dbGetHotDataPointTypeIds(ctx context.Context, after time.Time, before time.Time) ([]int, error) {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteMetrics) GetHotDataPointTypeIds(ctx context.Context, after time.Time, before time.Time) ([]int, error) {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 	typeIds := make([]int, 0, 1024)
@@ -58,7 +96,7 @@ func dbGetHotDataPointTypeIds(ctx context.Context, after time.Time, before time.
 
 	for rows.Next() {
 		typeIds = append(typeIds, 0)
-		err = rows.Scan(typeIds[len(typeIds)-1])
+		err = rows.Scan(&typeIds[len(typeIds)-1])
 		if err != nil {
 			return nil, err
 		}
@@ -73,8 +111,8 @@ func dbGetHotDataPointTypeIds(ctx context.Context, after time.Time, before time.
 }
 
 // The DataPoints returned are sorted by region
-func dbGetHotDataPointOfTypeId(ctx context.Context, typeId int, after time.Time, before time.Time) ([]hotDataPoint, error) {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteMetrics) GetHotDataPointOfTypeId(ctx context.Context, typeId int, after time.Time, before time.Time) ([]hotDataPoint, error) {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
 	defer cancel()
 	dataPoints := make([]hotDataPoint, 0, 128)
@@ -107,13 +145,83 @@ func dbGetHotDataPointOfTypeId(ctx context.Context, typeId int, after time.Time,
 	return dataPoints, nil
 }
 
-func dbClearHotTypeDataPoints(ctx context.Context, before time.Time) error {
-	db := ctx.Value("db").(*database.DB)
+// Hourly averages of the hot data points of [from, to)
+// Prices of 0 mean that there was no order, they are left out of the averages.
+const hourRollupQuery = `
+  INSERT INTO HourTypeMetric
+    SELECT
+      TypeId,
+      RegionId,
+      Time / 3600 * 3600 AS Hour,
+      COALESCE(AVG(NULLIF(BuyPrice, 0)), 0),
+      COALESCE(AVG(NULLIF(SellPrice, 0)), 0)
+    FROM HotTypeMetric
+    WHERE Time >= ? AND Time < ?
+    GROUP BY TypeId, RegionId, Hour
+    ON CONFLICT (TypeId, RegionId, Time) DO UPDATE SET
+      BuyPrice = excluded.BuyPrice,
+      SellPrice = excluded.SellPrice;
+  `
+
+// NOTE: HotTypeMetric has no index, this scans the table
+func (s sqliteMetrics) RollupHotTypeDataPoints(ctx context.Context, from time.Time, to time.Time) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
-	query := "DELETE FROM HotTypeMetric WHERE Time < ?"
-	_, err := db.Exec(timeoutCtx, query, before.Unix())
+	_, err := db.Exec(timeoutCtx, hourRollupQuery, from.Unix(), to.Unix())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Roll the hot data points up into hourly data points before deleting them.
+// Hourly data points older than hourlyBefore are deleted.
+func (s sqliteMetrics) ClearHotTypeDataPoints(ctx context.Context, before time.Time, hourlyBefore time.Time) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(timeoutCtx, hourRollupQuery, 0, before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotTypeMetric WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	// hot data points that were not rolled up are outdated
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotTypeEstimate WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotLocationMetric WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotGlobalMetric WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HourTypeMetric WHERE Time < ?", hourlyBefore.Unix())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
@@ -121,10 +229,43 @@ func dbClearHotTypeDataPoints(ctx context.Context, before time.Time) error {
 	return nil
 }
 
-func dbGetItemStats(ctx context.Context, typeId int, regionId int, date time.Time) (*apiItemStats, error) {
-	stats := apiItemStats{TypeId: typeId, RegionId: regionId}
+func (s sqliteMetrics) GetIntradayPoints(ctx context.Context, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	points := make([]apiIntradayPoint, 0, 256)
+
+	query := `SELECT Time, BuyPrice, SellPrice FROM HourTypeMetric
+		WHERE TypeId = ? AND RegionId = ? AND Time >= ? AND Time < ?
+		ORDER BY Time;`
+	rows, err := db.Query(timeoutCtx, query, typeId, regionId, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p apiIntradayPoint
+		err = rows.Scan(&p.Time, &p.BuyPrice, &p.SellPrice)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// WARN: nillable return value
+func (s sqliteMetrics) GetItemStats(ctx context.Context, typeId int, regionId int, date time.Time) (*apiItemStats, error) {
+	stats := apiItemStats{TypeId: typeId, RegionId: regionId, Date: date.Format(dateLayout)}
 
-	db := ctx.Value("db").(*database.DB)
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
@@ -146,8 +287,13 @@ func dbGetItemStats(ctx context.Context, typeId int, regionId int, date time.Tim
 		return nil, err
 	}
 
+	// WeeklyVolume is -1 if there is no data point during the previous week
 	query = `
-  SELECT AVG(Volume), AVG(SellPrice), AVG(BuyPrice) FROM DayTypeMetric
+  SELECT
+    CAST(COALESCE(AVG(Volume), -1) AS BIGINT),
+    COALESCE(AVG(SellPrice), 0),
+    COALESCE(AVG(BuyPrice), 0)
+  FROM DayTypeMetric
     WHERE RegionId = ? AND TypeId = ? AND Date BETWEEN ? AND ?;
   `
 	err = db.QueryRow(
@@ -158,23 +304,139 @@ func dbGetItemStats(ctx context.Context, typeId int, regionId int, date time.Tim
 		date.AddDate(0, 0, -7).Format(dateLayout),
 		date.AddDate(0, 0, -1).Format(dateLayout),
 	).Scan(&stats.WeeklyVolume, &stats.WeeklySellPrice, &stats.WeeklyBuyPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// WARN: nillable return value
+func (s sqliteMetrics) GetLastItemStatsDate(ctx context.Context, typeId int, regionId int) (*time.Time, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	var dateString string
+	query := `
+  SELECT Date FROM DayTypeMetric
+    WHERE TypeId = ? AND RegionId = ?
+    ORDER BY Date DESC LIMIT 1;
+  `
+	err := db.QueryRow(timeoutCtx, query, typeId, regionId).Scan(&dateString)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			stats.WeeklyVolume = -1
-			stats.WeeklyBuyPrice = 0
-			stats.WeeklySellPrice = 0
-		} else {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	date, err := time.Parse(dateLayout, dateString)
+	if err != nil {
+		return nil, err
+	}
+	return &date, nil
+}
+
+// The prices are the ones of estimator or of locationId if it is not 0, the
+// volumes are always the ones of DayTypeMetric
+func (s sqliteMetrics) GetItemStatsRange(ctx context.Context, typeId int, regionId int, from time.Time, to time.Time, estimator string, locationId int) ([]apiDayStats, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	days := make([]apiDayStats, 0, 32)
+
+	query := `
+  SELECT Date, Volume, SellPrice, BuyPrice FROM DayTypeMetric
+    WHERE TypeId = ? AND RegionId = ? AND Date BETWEEN ? AND ?
+    ORDER BY Date;
+  `
+	args := []any{typeId, regionId, from.Format(dateLayout), to.Format(dateLayout)}
+	if locationId != 0 {
+		query = `
+    SELECT m.Date, m.Volume, COALESCE(l.SellPrice, 0), COALESCE(l.BuyPrice, 0)
+      FROM DayTypeMetric m
+      LEFT JOIN DayLocationMetric l
+        ON l.TypeId = m.TypeId AND l.RegionId = m.RegionId AND l.Date = m.Date AND l.LocationId = ?
+      WHERE m.TypeId = ? AND m.RegionId = ? AND m.Date BETWEEN ? AND ?
+      ORDER BY m.Date;
+    `
+		args = append([]any{locationId}, args...)
+	} else if estimator != BestEstimator {
+		query = `
+    SELECT m.Date, m.Volume, COALESCE(e.SellPrice, 0), COALESCE(e.BuyPrice, 0)
+      FROM DayTypeMetric m
+      LEFT JOIN DayTypeEstimate e
+        ON e.TypeId = m.TypeId AND e.RegionId = m.RegionId AND e.Date = m.Date AND e.Estimator = ?
+      WHERE m.TypeId = ? AND m.RegionId = ? AND m.Date BETWEEN ? AND ?
+      ORDER BY m.Date;
+    `
+		args = append([]any{estimator}, args...)
+	}
+	rows, err := db.Query(timeoutCtx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d apiDayStats
+		err = rows.Scan(&d.Date, &d.Volume, &d.SellPrice, &d.BuyPrice)
+		if err != nil {
 			return nil, err
 		}
+		days = append(days, d)
 	}
 
-	return &stats, nil
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// The traded value is estimated with the volume and the mid price
+func (s sqliteMetrics) GetTopTypes(ctx context.Context, regionId int, date time.Time, limit int) ([]apiTopType, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	topTypes := make([]apiTopType, 0, limit)
+
+	query := `
+  SELECT TypeId, Volume, SellPrice, BuyPrice, Volume * (SellPrice + BuyPrice) / 2 AS TradedValue
+    FROM DayTypeMetric
+    WHERE RegionId = ? AND Date = ?
+    ORDER BY TradedValue DESC
+    LIMIT ?;
+  `
+	rows, err := db.Query(timeoutCtx, query, regionId, date.Format(dateLayout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := apiTopType{RegionId: regionId, Date: date.Format(dateLayout)}
+		err = rows.Scan(&t.TypeId, &t.Volume, &t.SellPrice, &t.BuyPrice, &t.TradedValue)
+		if err != nil {
+			return nil, err
+		}
+		topTypes = append(topTypes, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return topTypes, nil
 }
 
 // NOTE: It's important to wrap those inserts in a transaction to avoid the
 // heavy work of updating the table index at each insert
-func dbInsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteMetrics) InsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
 	defer cancel()
 
@@ -184,6 +446,21 @@ func dbInsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error {
 	}
 	defer tx.Rollback()
 
+	// Replace the data points of the days that are computed again
+	deleteQuery := "DELETE FROM DayTypeMetric WHERE Date = ?"
+	deletedDates := make(map[string]struct{})
+	for _, dp := range dps {
+		date := dp.date.Format(dateLayout)
+		if _, ok := deletedDates[date]; ok {
+			continue
+		}
+		_, err = tx.Exec(timeoutCtx, deleteQuery, date)
+		if err != nil {
+			return err
+		}
+		deletedDates[date] = struct{}{}
+	}
+
 	query := "INSERT INTO DayTypeMetric VALUES (?,?,?,?,?,?)"
 	stmt, err := tx.PrepareWrite(timeoutCtx, query)
 	if err != nil {
@@ -193,7 +470,40 @@ func dbInsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error {
 
 	for _, dp := range dps {
 		date := dp.date.Format(dateLayout)
-		_, err := stmt.Exec(timeoutCtx, query, dp.typeId, dp.regionId, date, dp.buyPrice, dp.sellPrice, dp.volume)
+		_, err := stmt.Exec(timeoutCtx, dp.typeId, dp.regionId, date, dp.buyPrice, dp.sellPrice, dp.volume)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMetrics) InsertHotEstimates(ctx context.Context, estimates []hotEstimate) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO HotTypeEstimate VALUES (?,?,?,?,?,?)"
+	stmt, err := tx.PrepareWrite(timeoutCtx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range estimates {
+		_, err := stmt.Exec(timeoutCtx, e.typeId, e.regionId, e.time.Unix(), e.estimator, e.buyPrice, e.sellPrice)
 		if err != nil {
 			return err
 		}
@@ -206,3 +516,571 @@ func dbInsertDayDataPoints(ctx context.Context, dps []DayDataPoint) error {
 
 	return nil
 }
+
+// Average the hot estimates between after and before into day estimates of
+// date and delete them. The global estimates (RegionId 0) are the regional
+// ones weighted by the volumes of DayTypeMetric.
+// Prices of 0 mean that there was no order, they are left out of the averages.
+func (s sqliteMetrics) RollupHotEstimates(ctx context.Context, after time.Time, before time.Time, date time.Time) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	// the day is only replaced if there are hot data points to replace it with
+	var hasDataPoints bool
+	existsQuery := "SELECT EXISTS (SELECT 1 FROM HotTypeEstimate WHERE Time >= ? AND Time < ?)"
+	err := db.QueryRow(timeoutCtx, existsQuery, after.Unix(), before.Unix()).Scan(&hasDataPoints)
+	if err != nil {
+		return err
+	}
+	if !hasDataPoints {
+		return nil
+	}
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM DayTypeEstimate WHERE Date = ?", date.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+
+	regionQuery := `
+  INSERT INTO DayTypeEstimate
+    SELECT
+      TypeId,
+      RegionId,
+      CAST(? AS TEXT),
+      Estimator,
+      COALESCE(AVG(NULLIF(BuyPrice, 0)), 0),
+      COALESCE(AVG(NULLIF(SellPrice, 0)), 0)
+    FROM HotTypeEstimate
+    WHERE Time >= ? AND Time < ?
+    GROUP BY TypeId, RegionId, Estimator;
+  `
+	_, err = tx.Exec(timeoutCtx, regionQuery, date.Format(dateLayout), after.Unix(), before.Unix())
+	if err != nil {
+		return err
+	}
+
+	globalQuery := `
+  INSERT INTO DayTypeEstimate
+    SELECT
+      e.TypeId,
+      0,
+      e.Date,
+      e.Estimator,
+      COALESCE(SUM(CASE WHEN e.BuyPrice > 0 THEN e.BuyPrice * m.Volume END) /
+        SUM(CASE WHEN e.BuyPrice > 0 THEN m.Volume END), 0),
+      COALESCE(SUM(CASE WHEN e.SellPrice > 0 THEN e.SellPrice * m.Volume END) /
+        SUM(CASE WHEN e.SellPrice > 0 THEN m.Volume END), 0)
+    FROM DayTypeEstimate e
+    JOIN DayTypeMetric m
+      ON m.TypeId = e.TypeId AND m.RegionId = e.RegionId AND m.Date = e.Date
+    WHERE e.Date = ? AND e.RegionId != 0 AND m.Volume > 0
+    GROUP BY e.TypeId, e.Date, e.Estimator;
+  `
+	_, err = tx.Exec(timeoutCtx, globalQuery, date.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotTypeEstimate WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMetrics) GetIntradayEstimates(ctx context.Context, estimator string, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	points := make([]apiIntradayPoint, 0, 256)
+
+	query := `SELECT Time, BuyPrice, SellPrice FROM HotTypeEstimate
+		WHERE TypeId = ? AND RegionId = ? AND Estimator = ? AND Time >= ? AND Time < ?
+		ORDER BY Time;`
+	rows, err := db.Query(timeoutCtx, query, typeId, regionId, estimator, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p apiIntradayPoint
+		err = rows.Scan(&p.Time, &p.BuyPrice, &p.SellPrice)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// Replace the prices of stats by the ones of estimator
+// WARN: nillable return value, nil if there is no estimate for the day
+func (s sqliteMetrics) SetEstimatedPrices(ctx context.Context, stats *apiItemStats, estimator string, date time.Time) (*apiItemStats, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	query := `
+  SELECT SellPrice, BuyPrice FROM DayTypeEstimate
+    WHERE RegionId = ? AND TypeId = ? AND Estimator = ? AND Date = ?;
+  `
+	err := db.QueryRow(
+		timeoutCtx,
+		query,
+		stats.RegionId,
+		stats.TypeId,
+		estimator,
+		date.Format(dateLayout),
+	).Scan(&stats.SellPrice, &stats.BuyPrice)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	query = `
+  SELECT COALESCE(AVG(SellPrice), 0), COALESCE(AVG(BuyPrice), 0) FROM DayTypeEstimate
+    WHERE RegionId = ? AND TypeId = ? AND Estimator = ? AND Date BETWEEN ? AND ?;
+  `
+	err = db.QueryRow(
+		timeoutCtx,
+		query,
+		stats.RegionId,
+		stats.TypeId,
+		estimator,
+		date.AddDate(0, 0, -7).Format(dateLayout),
+		date.AddDate(0, 0, -1).Format(dateLayout),
+	).Scan(&stats.WeeklySellPrice, &stats.WeeklyBuyPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (s sqliteMetrics) GetLocationSecurities(ctx context.Context) (map[int]float64, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	securities := make(map[int]float64, 8192)
+
+	rows, err := db.Query(timeoutCtx, "SELECT Id, Security FROM Location;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var security float64
+		err = rows.Scan(&id, &security)
+		if err != nil {
+			return nil, err
+		}
+		securities[id] = security
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return securities, nil
+}
+
+func (s sqliteMetrics) InsertHotLocationDataPoints(ctx context.Context, dps []hotLocationDataPoint) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO HotLocationMetric VALUES (?,?,?,?,?,?)"
+	stmt, err := tx.PrepareWrite(timeoutCtx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, dp := range dps {
+		_, err := stmt.Exec(timeoutCtx, dp.typeId, dp.regionId, dp.locationId, dp.time.Unix(), dp.buyPrice, dp.sellPrice)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Average the hot location data points between after and before into day
+// location data points of date and delete them.
+// Prices of 0 mean that there was no order, they are left out of the averages.
+func (s sqliteMetrics) RollupHotLocationDataPoints(ctx context.Context, after time.Time, before time.Time, date time.Time) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	// the day is only replaced if there are hot data points to replace it with
+	var hasDataPoints bool
+	existsQuery := "SELECT EXISTS (SELECT 1 FROM HotLocationMetric WHERE Time >= ? AND Time < ?)"
+	err := db.QueryRow(timeoutCtx, existsQuery, after.Unix(), before.Unix()).Scan(&hasDataPoints)
+	if err != nil {
+		return err
+	}
+	if !hasDataPoints {
+		return nil
+	}
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM DayLocationMetric WHERE Date = ?", date.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+
+	rollupQuery := `
+  INSERT INTO DayLocationMetric
+    SELECT
+      TypeId,
+      RegionId,
+      LocationId,
+      CAST(? AS TEXT),
+      COALESCE(AVG(NULLIF(BuyPrice, 0)), 0),
+      COALESCE(AVG(NULLIF(SellPrice, 0)), 0)
+    FROM HotLocationMetric
+    WHERE Time >= ? AND Time < ?
+    GROUP BY TypeId, RegionId, LocationId;
+  `
+	_, err = tx.Exec(timeoutCtx, rollupQuery, date.Format(dateLayout), after.Unix(), before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotLocationMetric WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMetrics) GetIntradayLocationPoints(ctx context.Context, locationId int, typeId int, regionId int, from time.Time, to time.Time) ([]apiIntradayPoint, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	points := make([]apiIntradayPoint, 0, 256)
+
+	query := `SELECT Time, BuyPrice, SellPrice FROM HotLocationMetric
+		WHERE TypeId = ? AND RegionId = ? AND LocationId = ? AND Time >= ? AND Time < ?
+		ORDER BY Time;`
+	rows, err := db.Query(timeoutCtx, query, typeId, regionId, locationId, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p apiIntradayPoint
+		err = rows.Scan(&p.Time, &p.BuyPrice, &p.SellPrice)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// Replace the prices of stats by the ones of locationId
+// WARN: nillable return value, nil if there is no data point for the day
+func (s sqliteMetrics) SetLocationPrices(ctx context.Context, stats *apiItemStats, locationId int, date time.Time) (*apiItemStats, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	query := `
+  SELECT SellPrice, BuyPrice FROM DayLocationMetric
+    WHERE RegionId = ? AND TypeId = ? AND LocationId = ? AND Date = ?;
+  `
+	err := db.QueryRow(
+		timeoutCtx,
+		query,
+		stats.RegionId,
+		stats.TypeId,
+		locationId,
+		date.Format(dateLayout),
+	).Scan(&stats.SellPrice, &stats.BuyPrice)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	query = `
+  SELECT COALESCE(AVG(SellPrice), 0), COALESCE(AVG(BuyPrice), 0) FROM DayLocationMetric
+    WHERE RegionId = ? AND TypeId = ? AND LocationId = ? AND Date BETWEEN ? AND ?;
+  `
+	err = db.QueryRow(
+		timeoutCtx,
+		query,
+		stats.RegionId,
+		stats.TypeId,
+		locationId,
+		date.AddDate(0, 0, -7).Format(dateLayout),
+		date.AddDate(0, 0, -1).Format(dateLayout),
+	).Scan(&stats.WeeklySellPrice, &stats.WeeklyBuyPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.LocationId = locationId
+	return stats, nil
+}
+
+func (s sqliteMetrics) InsertHotGlobalDataPoints(ctx context.Context, dps []hotGlobalDataPoint) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO HotGlobalMetric VALUES (?,?,?,?,?,?,?,?)"
+	stmt, err := tx.PrepareWrite(timeoutCtx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, dp := range dps {
+		_, err := stmt.Exec(
+			timeoutCtx,
+			dp.regionId,
+			dp.time.Unix(),
+			dp.buyValue,
+			dp.sellValue,
+			dp.buyOrderCount,
+			dp.sellOrderCount,
+			dp.activeTypes,
+			dp.priceIndex,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Average the hot global data points between after and before into the day
+// global data points of date and delete them. The traded values are left
+// untouched.
+// Price indexes of 0 mean that the index was not available, they are left out
+// of the average.
+func (s sqliteMetrics) RollupHotGlobalDataPoints(ctx context.Context, after time.Time, before time.Time, date time.Time) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rollupQuery := `
+  INSERT INTO DayGlobalMetric
+    SELECT
+      RegionId,
+      CAST(? AS TEXT),
+      AVG(BuyValue),
+      AVG(SellValue),
+      AVG(BuyOrderCount),
+      AVG(SellOrderCount),
+      AVG(ActiveTypes),
+      COALESCE(AVG(NULLIF(PriceIndex, 0)), 0),
+      0
+    FROM HotGlobalMetric
+    WHERE Time >= ? AND Time < ?
+    GROUP BY RegionId
+  ON CONFLICT (RegionId, Date) DO UPDATE SET
+    BuyValue = excluded.BuyValue,
+    SellValue = excluded.SellValue,
+    BuyOrderCount = excluded.BuyOrderCount,
+    SellOrderCount = excluded.SellOrderCount,
+    ActiveTypes = excluded.ActiveTypes,
+    PriceIndex = excluded.PriceIndex;
+  `
+	_, err = tx.Exec(timeoutCtx, rollupQuery, date.Format(dateLayout), after.Unix(), before.Unix())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(timeoutCtx, "DELETE FROM HotGlobalMetric WHERE Time < ?", before.Unix())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMetrics) InsertTradedValues(ctx context.Context, date time.Time, tradedValues TradedValues) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+  INSERT INTO DayGlobalMetric VALUES (?,?,0,0,0,0,0,0,?)
+  ON CONFLICT (RegionId, Date) DO UPDATE SET TradedValue = excluded.TradedValue;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for regionId, value := range tradedValues {
+		_, err := stmt.Exec(timeoutCtx, regionId, date.Format(dateLayout), value)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s sqliteMetrics) GetGlobalDays(ctx context.Context, regionId int, from time.Time, to time.Time) ([]apiGlobalDay, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+	days := make([]apiGlobalDay, 0, 32)
+
+	query := `
+  SELECT Date, BuyValue, SellValue, BuyOrderCount, SellOrderCount, ActiveTypes, PriceIndex, TradedValue
+    FROM DayGlobalMetric
+    WHERE RegionId = ? AND Date BETWEEN ? AND ?
+    ORDER BY Date;
+  `
+	rows, err := db.Query(timeoutCtx, query, regionId, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d apiGlobalDay
+		err = rows.Scan(&d.Date, &d.BuyValue, &d.SellValue, &d.BuyOrderCount, &d.SellOrderCount, &d.ActiveTypes, &d.PriceIndex, &d.TradedValue)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// WARN: nillable return value
+func (s sqliteMetrics) GetLastHotGlobalDataPoint(ctx context.Context, regionId int) (*apiGlobalNow, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	now := apiGlobalNow{RegionId: regionId}
+	query := `
+  SELECT Time, BuyValue, SellValue, BuyOrderCount, SellOrderCount, ActiveTypes, PriceIndex
+    FROM HotGlobalMetric
+    WHERE RegionId = ?
+    ORDER BY Time DESC LIMIT 1;
+  `
+	err := db.QueryRow(timeoutCtx, query, regionId).Scan(
+		&now.Time,
+		&now.BuyValue,
+		&now.SellValue,
+		&now.BuyOrderCount,
+		&now.SellOrderCount,
+		&now.ActiveTypes,
+		&now.PriceIndex,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &now, nil
+}
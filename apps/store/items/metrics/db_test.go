@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+)
+
+func TestRepositories(t *testing.T) {
+
+	stores, ctx := apptest.Databases(t, "DayTypeMetric", "DayTypeEstimate", "HotTypeEstimate", "HotTypeMetric", "HourTypeMetric", "TimeRecord")
+	for name, a := range stores {
+		t.Run(name, func(t *testing.T) {
+			date := time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)
+
+			// the volumes of plex overflow 32 bits integers
+			dps := make([]DayDataPoint, 0, 16)
+			for day := 0; day <= 7; day++ {
+				dps = append(dps,
+					DayDataPoint{typeId: 44992, regionId: 10000002, date: date.AddDate(0, 0, -day), sellPrice: 5, buyPrice: 4, volume: 3e9},
+					DayDataPoint{typeId: 44992, regionId: 10000043, date: date.AddDate(0, 0, -day), sellPrice: 6, buyPrice: 5, volume: 1e9},
+				)
+			}
+			err := storeOf(a).InsertDayDataPoints(ctx, dps)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err := storeOf(a).GetItemStats(ctx, 44992, 10000002, date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats == nil || stats.Volume != 3e9 || stats.WeeklyVolume != 3e9 || stats.WeeklySellPrice != 5 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+			days, err := storeOf(a).GetItemStatsRange(ctx, 44992, 10000043, date.AddDate(0, 0, -2), date, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(days) != 3 || days[2].Volume != 1e9 {
+				t.Fatalf("unexpected days %+v", days)
+			}
+			topTypes, err := storeOf(a).GetTopTypes(ctx, 10000002, date, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(topTypes) != 1 || topTypes[0].TradedValue != 4.5*3e9 {
+				t.Fatalf("unexpected top types %+v", topTypes)
+			}
+
+			// the global estimates are weighted by the regional volumes
+			estimates := []hotEstimate{
+				{typeId: 44992, regionId: 10000002, time: date.Add(time.Hour), estimator: "median5", sellPrice: 5, buyPrice: 4},
+				{typeId: 44992, regionId: 10000043, time: date.Add(time.Hour), estimator: "median5", sellPrice: 9, buyPrice: 0},
+			}
+			err = storeOf(a).InsertHotEstimates(ctx, estimates)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = storeOf(a).RollupHotEstimates(ctx, date, date.AddDate(0, 0, 1), date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			global, err := storeOf(a).SetEstimatedPrices(ctx, &apiItemStats{TypeId: 44992, RegionId: 0}, "median5", date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if global == nil || math.Abs(global.SellPrice-6) > 1e-9 || global.BuyPrice != 4 {
+				t.Fatalf("unexpected global estimate %+v", global)
+			}
+		})
+	}
+}
+
+func TestRollupHotDataPoints(t *testing.T) {
+
+	stores, ctx := apptest.Databases(t, "DayTypeMetric", "DayTypeEstimate", "HotTypeEstimate", "HotTypeMetric", "HourTypeMetric", "TimeRecord")
+	for name, a := range stores {
+		t.Run(name, func(t *testing.T) {
+			hour := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+
+			dps := []hotDataPoint{
+				{typeId: 34, regionId: 10000002, time: hour.Add(10 * time.Minute), sellPrice: 6, buyPrice: 4},
+				{typeId: 34, regionId: 10000002, time: hour.Add(20 * time.Minute), sellPrice: 8, buyPrice: 0},
+				{typeId: 34, regionId: 10000002, time: hour.Add(70 * time.Minute), sellPrice: 10, buyPrice: 5},
+			}
+			err := storeOf(a).InsertHotDataPoints(ctx, dps)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the hour in progress is left for a later rollup
+			err = rollupHotDataPoints(ctx, a, hour.Add(80*time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := storeOf(a).GetIntradayPoints(ctx, 34, 10000002, hour, hour.Add(3*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != 1 || points[0].Time != hour.Unix() || points[0].SellPrice != 7 || points[0].BuyPrice != 4 {
+				t.Fatalf("unexpected hourly points %+v", points)
+			}
+
+			err = rollupHotDataPoints(ctx, a, hour.Add(2*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err = storeOf(a).GetIntradayPoints(ctx, 34, 10000002, hour, hour.Add(3*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(points) != 2 || points[1].SellPrice != 10 {
+				t.Fatalf("unexpected hourly points %+v", points)
+			}
+		})
+	}
+}
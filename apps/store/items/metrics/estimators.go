@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"sort"
+)
+
+// An estimator computes the price of one side of a market from its orders.
+// It returns 0 when there is no order, like computeBuyPriceFromOrders and
+// computeSellPriceFromOrders.
+// The best estimator is stored in HotTypeMetric and DayTypeMetric, the other
+// ones in HotTypeEstimate and DayTypeEstimate.
+type estimator func(orders []*dbOrder, isBuyOrder bool) float64
+
+const BestEstimator = "best"
+
+// Share of the volume of a market side used by the top5pct estimator
+const topVolumeShare = 0.05
+
+// Number of best orders used by the median5 estimator
+const medianOrderCount = 5
+
+// Orders worth less than minOrderValue are ignored by the minisk estimator
+const minOrderValue = 10_000_000
+
+var estimators = map[string]estimator{
+	BestEstimator: estimateBestPrice,
+	"top5pct":     estimateTopVolumePrice,
+	"median5":     estimateMedianPrice,
+	"minisk":      estimateMinValuePrice,
+}
+
+func ValidEstimator(name string) bool {
+	_, ok := estimators[name]
+	return ok
+}
+
+func estimateBestPrice(orders []*dbOrder, isBuyOrder bool) float64 {
+	if isBuyOrder {
+		return computeBuyPriceFromOrders(orders)
+	}
+	return computeSellPriceFromOrders(orders)
+}
+
+// Volume weighted average price of the best orders that make up the top 5% of
+// the volume
+func estimateTopVolumePrice(orders []*dbOrder, isBuyOrder bool) float64 {
+	sortedOrders := sortOrdersByBestPrice(orders, isBuyOrder)
+
+	var totalVolume int64 = 0
+	for _, o := range sortedOrders {
+		totalVolume += int64(o.VolumeRemain)
+	}
+	if totalVolume == 0 {
+		return 0
+	}
+
+	targetVolume := float64(totalVolume) * topVolumeShare
+	var volume, value float64 = 0, 0
+	for _, o := range sortedOrders {
+		orderVolume := min(float64(o.VolumeRemain), targetVolume-volume)
+		volume += orderVolume
+		value += orderVolume * o.Price
+		if volume >= targetVolume {
+			break
+		}
+	}
+	return value / volume
+}
+
+// Median price of the medianOrderCount best orders
+func estimateMedianPrice(orders []*dbOrder, isBuyOrder bool) float64 {
+	sortedOrders := sortOrdersByBestPrice(orders, isBuyOrder)
+	if len(sortedOrders) == 0 {
+		return 0
+	}
+
+	topOrders := sortedOrders[:min(len(sortedOrders), medianOrderCount)]
+	middle := len(topOrders) / 2
+	if len(topOrders)%2 == 0 {
+		return (topOrders[middle-1].Price + topOrders[middle].Price) / 2
+	}
+	return topOrders[middle].Price
+}
+
+// Best price among the orders worth at least minOrderValue
+func estimateMinValuePrice(orders []*dbOrder, isBuyOrder bool) float64 {
+	sortedOrders := sortOrdersByBestPrice(orders, isBuyOrder)
+	for _, o := range sortedOrders {
+		if o.Price*float64(o.VolumeRemain) >= minOrderValue {
+			return o.Price
+		}
+	}
+	return 0
+}
+
+// Return a copy of orders sorted from the best price to the worst: highest
+// first for buy orders and lowest first for sell orders
+func sortOrdersByBestPrice(orders []*dbOrder, isBuyOrder bool) []*dbOrder {
+	sortedOrders := make([]*dbOrder, len(orders))
+	copy(sortedOrders, orders)
+	sort.Slice(sortedOrders, func(i, j int) bool {
+		if isBuyOrder {
+			return sortedOrders[i].Price > sortedOrders[j].Price
+		}
+		return sortedOrders[i].Price < sortedOrders[j].Price
+	})
+	return sortedOrders
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestEstimators(t *testing.T) {
+	sellOrders := []*dbOrder{
+		{Price: 1, VolumeRemain: 1}, // troll order
+		{Price: 100, VolumeRemain: 10},
+		{Price: 102, VolumeRemain: 10},
+		{Price: 104, VolumeRemain: 1000},
+		{Price: 200, VolumeRemain: 100_000},
+	}
+	buyOrders := []*dbOrder{
+		{Price: 1_000_000, VolumeRemain: 1, IsBuyOrder: true}, // troll order
+		{Price: 90, VolumeRemain: 10, IsBuyOrder: true},
+		{Price: 80, VolumeRemain: 1_000_000, IsBuyOrder: true},
+	}
+
+	tests := []struct {
+		estimator string
+		orders    []*dbOrder
+		isBuy     bool
+		want      float64
+	}{
+		{"best", sellOrders, false, 1},
+		{"best", buyOrders, true, 1_000_000},
+		// 5% of 101021 is 5051.05 units: 1 at 1, 10 at 100, 10 at 102, 1000 at 104
+		// and 4030.05 at 200
+		{"top5pct", sellOrders, false, (1 + 10*100 + 10*102 + 1000*104 + 4030.05*200) / 5051.05},
+		{"median5", sellOrders, false, 102},
+		{"median5", buyOrders, true, 90},
+		{"median5", buyOrders[1:], true, 85},
+		{"minisk", sellOrders, false, 200},
+		{"minisk", buyOrders, true, 80},
+		{"top5pct", nil, false, 0},
+		{"median5", nil, true, 0},
+		{"minisk", sellOrders[:3], false, 0},
+	}
+
+	for _, test := range tests {
+		got := estimators[test.estimator](test.orders, test.isBuy)
+		if got-test.want > 1e-9 || test.want-got > 1e-9 {
+			t.Errorf("%s: got %v, want %v", test.estimator, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	vm "github.com/VictoriaMetrics/metrics"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// Global metrics describe the whole market of a region (and of all regions
+// under RegionId 0). They are stored in the HotGlobalMetric and DayGlobalMetric
+// tables and exported as prometheus gauges.
+
+// Default basket of the price index: minerals and plex. It can be overwritten
+// with the -metric-basket flag
+var DefaultBasket = []int{
+	34, // tritanium
+	35, // pyerite
+	36, // mexallon
+	37, // isogen
+	38, // nocxium
+	39, // zydrine
+	40, // megacyte
+	shared.PlexTypeId,
+}
+
+func basketOf(a *app.App) []int {
+	if len(a.Config.Metrics.Basket) == 0 {
+		return DefaultBasket
+	}
+	return a.Config.Metrics.Basket
+}
+
+type hotGlobalDataPoint struct {
+	regionId       int
+	time           time.Time
+	buyValue       float64 // ISK on buy orders
+	sellValue      float64 // ISK on sell orders
+	buyOrderCount  int
+	sellOrderCount int
+	activeTypes    int // types with at least one order
+	priceIndex     float64
+}
+
+// ISK traded per region (0 for all regions) during a day
+type TradedValues map[int]float64
+
+// Add the value traded the day before day in histories
+func (tv TradedValues) Add(histories []dbHistory, day time.Time) error {
+	for _, history := range histories {
+		historyDay, err := getHistoryDay(history, day.AddDate(0, 0, -1))
+		if err != nil {
+			return fmt.Errorf("getHistoryDay: %w", err)
+		}
+		if historyDay == nil {
+			continue
+		}
+		value := historyDay.Average * float64(historyDay.Volume)
+		tv[history.RegionId] += value
+		tv[0] += value
+	}
+	return nil
+}
+
+// Store the traded values of the day before day and export them as gauges.
+// WARN: it must be called after ClearHotDataPoints that computes the rest of
+// the day global metrics
+func InsertTradedValues(ctx context.Context, a *app.App, day time.Time, tradedValues TradedValues) error {
+	err := storeOf(a).InsertTradedValues(ctx, day.AddDate(0, 0, -1), tradedValues)
+	if err != nil {
+		return err
+	}
+	for regionId, value := range tradedValues {
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_traded_value{region="%d"}`, regionId), nil).Set(value)
+	}
+	return nil
+}
+
+// dataPoints are the best prices computed by computeHotDataPoints
+func computeHotGlobalDataPoints(
+	ctx context.Context,
+	retrivalTime time.Time,
+	orders []dbOrder,
+	dataPoints []hotDataPoint,
+	basket []int,
+) ([]hotGlobalDataPoint, error) {
+	globalDataPoints := make(map[int]*hotGlobalDataPoint)
+	activeTypes := make(map[int]map[int]struct{})
+	getDataPoint := func(regionId int) *hotGlobalDataPoint {
+		dp, ok := globalDataPoints[regionId]
+		if !ok {
+			dp = &hotGlobalDataPoint{regionId: regionId, time: retrivalTime}
+			globalDataPoints[regionId] = dp
+			activeTypes[regionId] = make(map[int]struct{})
+		}
+		return dp
+	}
+
+	for i := range orders {
+		if i%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		o := &orders[i]
+		value := o.Price * float64(o.VolumeRemain)
+		for _, regionId := range []int{o.RegionId, 0} {
+			dp := getDataPoint(regionId)
+			if o.IsBuyOrder {
+				dp.buyValue += value
+				dp.buyOrderCount++
+			} else {
+				dp.sellValue += value
+				dp.sellOrderCount++
+			}
+			activeTypes[regionId][o.TypeId] = struct{}{}
+		}
+	}
+
+	// best prices of the basket types in each region and in all regions
+	basketPrices := make(map[int]map[int]*hotDataPoint)
+	isInBasket := make(map[int]struct{}, len(basket))
+	for _, typeId := range basket {
+		isInBasket[typeId] = struct{}{}
+	}
+	for _, dp := range dataPoints {
+		if _, ok := isInBasket[dp.typeId]; !ok {
+			continue
+		}
+		for _, regionId := range []int{dp.regionId, 0} {
+			if basketPrices[regionId] == nil {
+				basketPrices[regionId] = make(map[int]*hotDataPoint)
+			}
+			best, ok := basketPrices[regionId][dp.typeId]
+			if !ok {
+				best = &hotDataPoint{typeId: dp.typeId, regionId: regionId}
+				basketPrices[regionId][dp.typeId] = best
+			}
+			best.buyPrice = max(best.buyPrice, dp.buyPrice)
+			if best.sellPrice == 0 || (dp.sellPrice != 0 && dp.sellPrice < best.sellPrice) {
+				best.sellPrice = dp.sellPrice
+			}
+		}
+	}
+
+	// NOTE: the plex is only traded in the global plex market, its prices count
+	// in the index of every region
+	for regionId := range globalDataPoints {
+		for typeId, dp := range basketPrices[regions.GlobalPlexMarket] {
+			if basketPrices[regionId] == nil {
+				basketPrices[regionId] = make(map[int]*hotDataPoint)
+			}
+			if _, ok := basketPrices[regionId][typeId]; !ok {
+				basketPrices[regionId][typeId] = dp
+			}
+		}
+	}
+
+	globalDataPointsList := make([]hotGlobalDataPoint, 0, len(globalDataPoints))
+	for regionId, dp := range globalDataPoints {
+		dp.activeTypes = len(activeTypes[regionId])
+		dp.priceIndex = computePriceIndex(basketPrices[regionId], basket)
+		globalDataPointsList = append(globalDataPointsList, *dp)
+	}
+	return globalDataPointsList, nil
+}
+
+// Geometric mean of the mid prices of the basket types. The ratio of two
+// indexes is the geometric mean of the price relatives of the basket types.
+// The index is 0 if a basket type misses a buy or sell price as the index would
+// not be comparable.
+func computePriceIndex(prices map[int]*hotDataPoint, basket []int) float64 {
+	if len(basket) == 0 {
+		return 0
+	}
+	var logSum float64 = 0
+	for _, typeId := range basket {
+		dp, ok := prices[typeId]
+		if !ok || dp.buyPrice == 0 || dp.sellPrice == 0 {
+			return 0
+		}
+		logSum += math.Log((dp.buyPrice + dp.sellPrice) / 2)
+	}
+	return math.Exp(logSum / float64(len(basket)))
+}
+
+func reportHotGlobalDataPoints(dps []hotGlobalDataPoint) {
+	for _, dp := range dps {
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_buy_value{region="%d"}`, dp.regionId), nil).Set(dp.buyValue)
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_sell_value{region="%d"}`, dp.regionId), nil).Set(dp.sellValue)
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_buy_orders{region="%d"}`, dp.regionId), nil).Set(float64(dp.buyOrderCount))
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_sell_orders{region="%d"}`, dp.regionId), nil).Set(float64(dp.sellOrderCount))
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_active_types{region="%d"}`, dp.regionId), nil).Set(float64(dp.activeTypes))
+		vm.GetOrCreateGauge(fmt.Sprintf(`store_market_price_index{region="%d"}`, dp.regionId), nil).Set(dp.priceIndex)
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+)
+
+func TestHotGlobalDataPoints(t *testing.T) {
+	now := time.Now()
+	orderSample := loadOrderSample()
+	dataPoints, err := computeHotDataPoints(context.Background(), now, orderSample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	basket := []int{34133, 40519}
+	globalDataPoints, err := computeHotGlobalDataPoints(context.Background(), now, orderSample, dataPoints, basket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forgeIndex := math.Sqrt(2771.5e6 * 541.55e6)
+	want := map[int]hotGlobalDataPoint{
+		10000002: {10000002, now, 887701521001.3, 1409541700000, 135, 80, 2, forgeIndex},
+		10000043: {10000043, now, 236654975000, 77141500000, 74, 33, 2, math.Sqrt(2670.5e6 * 531.85e6)},
+		// the best prices of all regions are the ones of the forge
+		0: {0, now, 1124356496001.3, 1486683200000, 209, 113, 2, forgeIndex},
+	}
+	if len(globalDataPoints) != len(want) {
+		t.Errorf("got %d data points, want %d", len(globalDataPoints), len(want))
+	}
+	for _, dp := range globalDataPoints {
+		w := want[dp.regionId]
+		if !almostEqual(dp.buyValue, w.buyValue) || !almostEqual(dp.sellValue, w.sellValue) ||
+			!almostEqual(dp.priceIndex, w.priceIndex) || dp.buyOrderCount != w.buyOrderCount ||
+			dp.sellOrderCount != w.sellOrderCount || dp.activeTypes != w.activeTypes || dp.time != w.time {
+			t.Errorf("got %v, want %v", dp, w)
+		}
+	}
+}
+
+func TestPriceIndexDefaultBasket(t *testing.T) {
+	now := time.Now()
+	orders := make([]dbOrder, 0, 2*len(DefaultBasket))
+	for i, typeId := range DefaultBasket {
+		regionId := 10000002
+		if typeId == 44992 {
+			regionId = regions.GlobalPlexMarket
+		}
+		price := float64(i + 1)
+		orders = append(
+			orders,
+			dbOrder{OrderId: 2 * i, TypeId: typeId, RegionId: regionId, Price: price, VolumeRemain: 1, IsBuyOrder: true},
+			dbOrder{OrderId: 2*i + 1, TypeId: typeId, RegionId: regionId, Price: price, VolumeRemain: 1},
+		)
+	}
+	dataPoints, err := computeHotDataPoints(context.Background(), now, orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globalDataPoints, err := computeHotGlobalDataPoints(context.Background(), now, orders, dataPoints, DefaultBasket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// geometric mean of 1, 2, ..., 8
+	want := math.Pow(40320, 1.0/8)
+	for _, dp := range globalDataPoints {
+		if dp.regionId == regions.GlobalPlexMarket {
+			continue
+		}
+		if !almostEqual(dp.priceIndex, want) {
+			t.Errorf("region %d: got index %v, want %v", dp.regionId, dp.priceIndex, want)
+		}
+	}
+}
+
+func TestPriceIndexMissingType(t *testing.T) {
+	prices := map[int]*hotDataPoint{
+		34: {typeId: 34, buyPrice: 4, sellPrice: 5},
+		35: {typeId: 35, buyPrice: 0, sellPrice: 10},
+	}
+	if index := computePriceIndex(prices, []int{34}); !almostEqual(index, 4.5) {
+		t.Errorf("got %v, want 4.5", index)
+	}
+	if index := computePriceIndex(prices, []int{34, 35}); index != 0 {
+		t.Errorf("got %v for a type without buy price, want 0", index)
+	}
+	if index := computePriceIndex(prices, []int{34, 36}); index != 0 {
+		t.Errorf("got %v for a type without orders, want 0", index)
+	}
+}
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	storepb.UnimplementedItemStatsServer
+	ctx context.Context
+	a   *app.App
+}
+
+// Register the ItemStats service of the gRPC api
+func RegisterGrpc(ctx context.Context, a *app.App, server grpc.ServiceRegistrar) {
+	storepb.RegisterItemStatsServer(server, &grpcServer{ctx: ctx, a: a})
+}
+
+// Same as /stats
+func (s *grpcServer) GetStats(ctx context.Context, req *storepb.StatsRequest) (*storepb.Stats, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	typeId, regionId := int(req.TypeId), int(req.RegionId)
+	estimator, ok := parseEstimator(req.Estimator)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "estimator is not a valid estimator")
+	}
+	locationId := 0
+	if req.Location != "" {
+		locationId, ok = parseLocation(ctx, s.a, req.Location)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "location must be a trade hub station id, highsec, lowsec or nullsec")
+		}
+		if estimator != BestEstimator {
+			return nil, status.Error(codes.InvalidArgument, "estimator is not available with location")
+		}
+	}
+
+	var date time.Time
+	if req.Date == "" {
+		lastDate, err := storeOf(s.a).GetLastItemStatsDate(timeoutCtx, typeId, regionId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			return nil, status.Error(codes.Internal, "Internal server error")
+		}
+		if lastDate == nil {
+			return nil, status.Error(codes.NotFound, "Stats not available")
+		}
+		date = *lastDate
+	} else {
+		var err error
+		date, err = time.Parse(dateLayout, req.Date)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "date is invalid date of format YYYY-MM-DD")
+		}
+	}
+
+	stats, err := storeOf(s.a).GetItemStats(timeoutCtx, typeId, regionId, date)
+	if err == nil && stats != nil && locationId != 0 {
+		stats, err = storeOf(s.a).SetLocationPrices(timeoutCtx, stats, locationId, date)
+	} else if err == nil && stats != nil && estimator != BestEstimator {
+		stats, err = storeOf(s.a).SetEstimatedPrices(timeoutCtx, stats, estimator, date)
+	}
+	if err != nil {
+		log.Printf("Internal server error: %v", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	if stats == nil {
+		return nil, status.Error(codes.NotFound, "Stats not available")
+	}
+
+	return &storepb.Stats{
+		TypeId:          int32(stats.TypeId),
+		RegionId:        int32(stats.RegionId),
+		LocationId:      int64(stats.LocationId),
+		Date:            stats.Date,
+		Volume:          stats.Volume,
+		WeeklyVolume:    stats.WeeklyVolume,
+		SellPrice:       stats.SellPrice,
+		WeeklySellPrice: stats.WeeklySellPrice,
+		BuyPrice:        stats.BuyPrice,
+		WeeklyBuyPrice:  stats.WeeklyBuyPrice,
+	}, nil
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// Location metrics restrict the hot data points of a region to a trade hub
+// station or to the stations of a security band. They are stored in the
+// HotLocationMetric and DayLocationMetric tables.
+// Security bands use the synthetic location ids below
+const (
+	HighsecLocation = 1
+	LowsecLocation  = 2
+	NullsecLocation = 3
+)
+
+// Default trade hubs, they can be overwritten with the -metric-hubs flag
+var DefaultHubs = []int{
+	60003760, // jita iv - moon 4 - caldari navy assembly plant
+	60008494, // amarr viii (oris) - emperor family academy
+	60011866, // dodixie ix - moon 20 - federation navy assembly plant
+	60004588, // rens vi - moon 8 - brutor tribe treasury
+	60005686, // hek viii - moon 12 - boundless creation factory
+}
+
+func hubsOf(a *app.App) []int {
+	if len(a.Config.Metrics.Hubs) == 0 {
+		return DefaultHubs
+	}
+	return a.Config.Metrics.Hubs
+}
+
+var securityBands = map[string]int{
+	"highsec": HighsecLocation,
+	"lowsec":  LowsecLocation,
+	"nullsec": NullsecLocation,
+}
+
+type hotLocationDataPoint struct {
+	typeId     int
+	regionId   int
+	locationId int
+	time       time.Time
+	sellPrice  float64
+	buyPrice   float64
+}
+
+type locationKey struct {
+	typeId     int
+	regionId   int
+	locationId int
+}
+
+// The security is rounded to one decimal the way the game does it
+func securityBand(security float64) int {
+	if security >= 0.45 {
+		return HighsecLocation
+	}
+	if security > 0 {
+		return LowsecLocation
+	}
+	return NullsecLocation
+}
+
+// Parse a location param that is either a security band name or the id of one
+// of the configured hubs
+func parseLocation(ctx context.Context, a *app.App, location string) (int, bool) {
+	hubs := hubsOf(a)
+	if band, ok := securityBands[location]; ok {
+		return band, true
+	}
+	locationId, err := strconv.Atoi(location)
+	if err != nil {
+		return 0, false
+	}
+	for _, hub := range hubs {
+		if hub == locationId {
+			return locationId, true
+		}
+	}
+	return 0, false
+}
+
+// Compute the best prices of each market restricted to the hubs and to each
+// security band. Orders of locations that are missing from securities are
+// left out of the security bands.
+func computeHotLocationDataPoints(
+	ctx context.Context,
+	retrivalTime time.Time,
+	orders []dbOrder,
+	hubs []int,
+	securities map[int]float64,
+) ([]hotLocationDataPoint, error) {
+	isHub := make(map[int]struct{}, len(hubs))
+	for _, hub := range hubs {
+		isHub[hub] = struct{}{}
+	}
+
+	dataPoints := make(map[locationKey]*hotLocationDataPoint)
+	addOrder := func(key locationKey, o *dbOrder) {
+		dp, ok := dataPoints[key]
+		if !ok {
+			dp = &hotLocationDataPoint{
+				typeId:     key.typeId,
+				regionId:   key.regionId,
+				locationId: key.locationId,
+				time:       retrivalTime,
+			}
+			dataPoints[key] = dp
+		}
+		// 0 means that there is no order, as in computeHotDataPoints
+		if o.IsBuyOrder && o.Price > dp.buyPrice {
+			dp.buyPrice = o.Price
+		}
+		if !o.IsBuyOrder && (dp.sellPrice == 0 || o.Price < dp.sellPrice) {
+			dp.sellPrice = o.Price
+		}
+	}
+
+	for i := range orders {
+		if i%4096 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		o := &orders[i]
+		if _, ok := isHub[o.LocationId]; ok {
+			addOrder(locationKey{o.TypeId, o.RegionId, o.LocationId}, o)
+		}
+		if security, ok := securities[o.LocationId]; ok {
+			addOrder(locationKey{o.TypeId, o.RegionId, securityBand(security)}, o)
+		}
+	}
+
+	dataPointsList := make([]hotLocationDataPoint, 0, len(dataPoints))
+	for _, dp := range dataPoints {
+		dataPointsList = append(dataPointsList, *dp)
+	}
+	return dataPointsList, nil
+}
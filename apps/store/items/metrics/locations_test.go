@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHotLocationDataPoints(t *testing.T) {
+	now := time.Now()
+	orderSample := loadOrderSample()
+	securities := map[int]float64{
+		60003760:      0.9459, // jita 4-4
+		1042508032148: 0.2,    // a lowsec structure of the forge
+	}
+	dataPoints, err := computeHotLocationDataPoints(context.Background(), now, orderSample, []int{60003760}, securities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[locationKey]hotLocationDataPoint{
+		{34133, 10000002, 60003760}:        {34133, 10000002, 60003760, now, 2976000000, 2567000000},
+		{34133, 10000002, HighsecLocation}: {34133, 10000002, HighsecLocation, now, 2976000000, 2567000000},
+		{34133, 10000002, LowsecLocation}:  {34133, 10000002, LowsecLocation, now, 0, 1756000000},
+		{40519, 10000002, 60003760}:        {40519, 10000002, 60003760, now, 546900000, 536200000},
+		{40519, 10000002, HighsecLocation}: {40519, 10000002, HighsecLocation, now, 546900000, 536200000},
+		{40519, 10000002, LowsecLocation}:  {40519, 10000002, LowsecLocation, now, 0, 532000000},
+	}
+	if len(dataPoints) != len(want) {
+		t.Errorf("got %d data points, want %d: %v", len(dataPoints), len(want), dataPoints)
+	}
+	for _, dp := range dataPoints {
+		if dp != want[locationKey{dp.typeId, dp.regionId, dp.locationId}] {
+			t.Errorf("got: %v", dp)
+		}
+	}
+}
+
+func TestSecurityBand(t *testing.T) {
+	tests := map[float64]int{1: HighsecLocation, 0.45: HighsecLocation, 0.44: LowsecLocation, 0.01: LowsecLocation, 0: NullsecLocation, -0.5: NullsecLocation}
+	for security, want := range tests {
+		if got := securityBand(security); got != want {
+			t.Errorf("security %v: got %d, want %d", security, got, want)
+		}
+	}
+}
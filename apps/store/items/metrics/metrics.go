@@ -8,10 +8,19 @@
 // DayTypeMetric will handle metrics that will get a new data point every day
 // (like average of some HotMetrics for example)
 // HotTypeMetric table will be burned to the ground every 1 to 2 days to avoid
-// exessive memory consumption
+// exessive memory consumption. Its data points are rolled up every hour into
+// the HourTypeMetric table that is kept for a configurable retention window
 //
-// If I latter need to add global metrics they will be added under the
-// HotGlobalMetric and DayGlobalMetric tables
+// Prices are the best buy and sell orders. As a single troll order can make
+// them useless, the prices of more robust estimators (see estimators.go) are
+// stored along them in the HotTypeEstimate and DayTypeEstimate tables
+//
+// Prices of trade hub stations and of security bands within a region are
+// stored in the HotLocationMetric and DayLocationMetric tables (see
+// locations.go)
+//
+// Metrics of the whole market of each region are stored in the HotGlobalMetric
+// and DayGlobalMetric tables (see global.go)
 //
 // NOTE: DayTypeMetric is a bit of a diplicate of History. One day I could
 // perhaps merge this two tables
@@ -26,6 +35,8 @@ import (
 	"time"
 
 	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 )
 
@@ -41,6 +52,15 @@ type hotDataPoint struct {
 	buyPrice  float64
 }
 
+type hotEstimate struct {
+	typeId    int
+	regionId  int
+	time      time.Time
+	estimator string
+	sellPrice float64
+	buyPrice  float64
+}
+
 type DayDataPoint struct {
 	typeId    int
 	regionId  int
@@ -55,26 +75,111 @@ const dateLayout = "2006-01-02"
 // Compute and store HotDataPoints relative and ordersList
 // retrivalTime is the time at wich the data was up to date
 // This function is meant to be called during the orders download process
-func CreateHotDataPoints(ctx context.Context, retrivalTime time.Time, orders []dbOrder) error {
+func CreateHotDataPoints(ctx context.Context, a *app.App, retrivalTime time.Time, orders []dbOrder) error {
 	dataPoints, err := computeHotDataPoints(ctx, retrivalTime, orders)
 	if err != nil {
 		return err
 	}
-	err = dbInsertHotDataPoints(ctx, dataPoints)
+	err = storeOf(a).InsertHotDataPoints(ctx, dataPoints)
+	if err != nil {
+		return err
+	}
+	err = rollupHotDataPoints(ctx, a, retrivalTime)
+	if err != nil {
+		return fmt.Errorf("rollup hot data points: %w", err)
+	}
+
+	basket := basketOf(a)
+	globalDataPoints, err := computeHotGlobalDataPoints(ctx, retrivalTime, orders, dataPoints, basket)
+	if err != nil {
+		return err
+	}
+	err = storeOf(a).InsertHotGlobalDataPoints(ctx, globalDataPoints)
 	if err != nil {
 		return err
 	}
+	reportHotGlobalDataPoints(globalDataPoints)
+
+	hubs := hubsOf(a)
+	securities, err := storeOf(a).GetLocationSecurities(ctx)
+	if err != nil {
+		return err
+	}
+	locationDataPoints, err := computeHotLocationDataPoints(ctx, retrivalTime, orders, hubs, securities)
+	if err != nil {
+		return err
+	}
+	err = storeOf(a).InsertHotLocationDataPoints(ctx, locationDataPoints)
+	if err != nil {
+		return err
+	}
+
+	estimatorNames := a.Config.Metrics.Estimators
+	if len(estimatorNames) > 0 {
+		estimates, err := computeHotEstimates(ctx, retrivalTime, orders, estimatorNames)
+		if err != nil {
+			return err
+		}
+		err = storeOf(a).InsertHotEstimates(ctx, estimates)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func ClearHotDataPoints(ctx context.Context, day time.Time) error {
+// Roll the hot data points of the hours completed since the last rollup up
+// into hourly data points. The /intraday endpoint reads the hourly data points
+// as HotTypeMetric has no index.
+func rollupHotDataPoints(ctx context.Context, a *app.App, retrivalTime time.Time) error {
+	hour := retrivalTime.Truncate(time.Hour)
+	lastRollup, err := timerecord.Get(ctx, a, "HourlyRollup")
+	if err != nil {
+		return err
+	}
+	if !lastRollup.Before(hour) {
+		return nil
+	}
+	err = storeOf(a).RollupHotTypeDataPoints(ctx, lastRollup, hour)
+	if err != nil {
+		return err
+	}
+	return timerecord.Set(ctx, a, "HourlyRollup", hour)
+}
+
+// Roll the HotDataPoints older than eleven today up into hourly data points and
+// delete them. Hourly data points are kept for the intradayRetention duration.
+// The hot estimates, location and global data points of the day are averaged
+// into day estimates, day location and day global data points dated the day
+// before day, like the day data points, and deleted.
+// WARN: it must be called after InsertDayDataPoints as the global day
+// estimates are weighted by the volumes of the day data points
+func ClearHotDataPoints(ctx context.Context, a *app.App, day time.Time) error {
+	intradayRetention := time.Duration(a.Config.Metrics.IntradayRetention) * 24 * time.Hour
 	elevenToday := elevenThatDay(day)
-	return dbClearHotTypeDataPoints(ctx, elevenToday)
+	elevenYesterday := elevenTheDayBefore(day)
+
+	err := storeOf(a).RollupHotEstimates(ctx, elevenYesterday, elevenToday, day.AddDate(0, 0, -1))
+	if err != nil {
+		return fmt.Errorf("rollup estimates: %w", err)
+	}
+	err = storeOf(a).RollupHotLocationDataPoints(ctx, elevenYesterday, elevenToday, day.AddDate(0, 0, -1))
+	if err != nil {
+		return fmt.Errorf("rollup location data points: %w", err)
+	}
+	err = storeOf(a).RollupHotGlobalDataPoints(ctx, elevenYesterday, elevenToday, day.AddDate(0, 0, -1))
+	if err != nil {
+		return fmt.Errorf("rollup global data points: %w", err)
+	}
+	return storeOf(a).ClearHotTypeDataPoints(ctx, elevenToday, elevenToday.Add(-intradayRetention))
 }
 
 // histories contains all histories of a typeId
 // This function is meant to be called during the global histories computation
-func CreateRegionDayDataPoints(ctx context.Context, histories []dbHistory, day time.Time) ([]DayDataPoint, error) {
+// The data points are dated the day before day as the histories downloaded on
+// day are complete up to the day before.
+func CreateRegionDayDataPoints(ctx context.Context, a *app.App, histories []dbHistory, day time.Time) ([]DayDataPoint, error) {
 	if len(histories) == 0 {
 		return nil, nil
 	}
@@ -83,7 +188,7 @@ func CreateRegionDayDataPoints(ctx context.Context, histories []dbHistory, day t
 	elevenToday := elevenThatDay(day)
 	elevenYesterday := elevenTheDayBefore(day)
 	// hotDataPoints of typeId for all regions sorted by regionId
-	hotDataPoints, err := dbGetHotDataPointOfTypeId(ctx, typeId, elevenYesterday, elevenToday)
+	hotDataPoints, err := storeOf(a).GetHotDataPointOfTypeId(ctx, typeId, elevenYesterday, elevenToday)
 	if err != nil {
 		return nil, fmt.Errorf("get day dp: %w", err)
 	}
@@ -98,7 +203,7 @@ func CreateRegionDayDataPoints(ctx context.Context, histories []dbHistory, day t
 		}
 
 		hotDataPoints := getRegionDataPoints(hotDataPoints, history.RegionId)
-		dayDataPoint, err := computeRegionDayDataPointOfType(hotDataPoints, history, day)
+		dayDataPoint, err := computeRegionDayDataPointOfType(hotDataPoints, history, day.AddDate(0, 0, -1))
 		if err != nil {
 			return nil, fmt.Errorf("compute day dp: %w", err)
 		}
@@ -107,19 +212,64 @@ func CreateRegionDayDataPoints(ctx context.Context, histories []dbHistory, day t
 		}
 	}
 	if len(dayDataPoints) > 0 {
-		globalDataPoint := computeGlobalDayDataPointOfType(dayDataPoints, day, typeId)
+		globalDataPoint := computeGlobalDayDataPointOfType(dayDataPoints, day.AddDate(0, 0, -1), typeId)
 		dayDataPoints = append(dayDataPoints, globalDataPoint)
 	}
 
 	return dayDataPoints, nil
 }
 
-func InsertDayDataPoints(ctx context.Context, dayDataPoints []DayDataPoint) error {
-	return dbInsertDayDataPoints(ctx, dayDataPoints)
+func InsertDayDataPoints(ctx context.Context, a *app.App, dayDataPoints []DayDataPoint) error {
+	return storeOf(a).InsertDayDataPoints(ctx, dayDataPoints)
 }
 
 func computeHotDataPoints(ctx context.Context, retrivalTime time.Time, orders []dbOrder) ([]hotDataPoint, error) {
 	dataPoints := make([]hotDataPoint, 0, 1024)
+	err := forEachMarket(ctx, orders, func(typeId, regionId int, buyOrders, sellOrders []*dbOrder) {
+		buyPrice := computeBuyPriceFromOrders(buyOrders)
+		sellPrice := computeSellPriceFromOrders(sellOrders)
+		dataPoints = append(dataPoints, hotDataPoint{
+			typeId:    typeId,
+			regionId:  regionId,
+			time:      retrivalTime,
+			buyPrice:  buyPrice,
+			sellPrice: sellPrice,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataPoints, nil
+}
+
+// Compute the prices of every market with each estimator of estimatorNames
+// except the best estimator that is already computed by computeHotDataPoints
+func computeHotEstimates(ctx context.Context, retrivalTime time.Time, orders []dbOrder, estimatorNames []string) ([]hotEstimate, error) {
+	estimates := make([]hotEstimate, 0, 1024*len(estimatorNames))
+	err := forEachMarket(ctx, orders, func(typeId, regionId int, buyOrders, sellOrders []*dbOrder) {
+		for _, name := range estimatorNames {
+			if name == BestEstimator {
+				continue
+			}
+			estimate := estimators[name]
+			estimates = append(estimates, hotEstimate{
+				typeId:    typeId,
+				regionId:  regionId,
+				time:      retrivalTime,
+				estimator: name,
+				buyPrice:  estimate(buyOrders, true),
+				sellPrice: estimate(sellOrders, false),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return estimates, nil
+}
+
+// Call fn with the buy and sell orders of each (type, region) market
+func forEachMarket(ctx context.Context, orders []dbOrder, fn func(typeId, regionId int, buyOrders, sellOrders []*dbOrder)) error {
 	ordersPtr := make([]*dbOrder, len(orders))
 	for i := range orders {
 		ordersPtr[i] = &orders[i]
@@ -128,30 +278,19 @@ func computeHotDataPoints(ctx context.Context, retrivalTime time.Time, orders []
 
 	regionStart := 0
 	for regionStart < len(ordersPtr) {
-		regionId := orders[regionStart].RegionId
-		regionEnd := getRegionEnd(orders, regionId, regionStart)
+		regionId := ordersPtr[regionStart].RegionId
+		regionEnd := getRegionEnd(ordersPtr, regionId, regionStart)
 		regionOrders := ordersPtr[regionStart:regionEnd]
 
 		typeStart := 0
 		for typeStart < len(regionOrders) {
 			if err := ctx.Err(); err != nil {
-				return nil, err
+				return err
 			}
 
 			typeId := regionOrders[typeStart].TypeId
 			typeSellStart, typeEnd := getTypeSellStartAndEnd(regionOrders, typeId, typeStart)
-			buyOrders := regionOrders[typeStart:typeSellStart]
-			sellOrders := regionOrders[typeSellStart:typeEnd]
-
-			buyPrice := computeBuyPriceFromOrders(buyOrders)
-			sellPrice := computeSellPriceFromOrders(sellOrders)
-			dataPoints = append(dataPoints, hotDataPoint{
-				typeId:    typeId,
-				regionId:  regionId,
-				time:      retrivalTime,
-				buyPrice:  buyPrice,
-				sellPrice: sellPrice,
-			})
+			fn(typeId, regionId, regionOrders[typeStart:typeSellStart], regionOrders[typeSellStart:typeEnd])
 
 			if typeEnd <= typeStart {
 				panic("infinite loop?")
@@ -165,7 +304,7 @@ func computeHotDataPoints(ctx context.Context, retrivalTime time.Time, orders []
 		regionStart = regionEnd
 	}
 
-	return dataPoints, nil
+	return nil
 }
 
 // WARN: nillable return value
@@ -191,8 +330,8 @@ func computeRegionDayDataPointOfType(regionDataPoints []hotDataPoint, history db
 	buyPrice /= float64(len(regionDataPoints))
 
 	return &DayDataPoint{
-		typeId:    history.RegionId,
-		regionId:  history.TypeId,
+		typeId:    history.TypeId,
+		regionId:  history.RegionId,
 		date:      day,
 		volume:    historyDay.Volume,
 		sellPrice: sellPrice,
@@ -266,7 +405,7 @@ func getHistoryDay(history dbHistory, day time.Time) (*esiHistoryDay, error) {
 	return nil, nil
 }
 
-func getRegionEnd(orders []dbOrder, regionId int, regionStart int) int {
+func getRegionEnd(orders []*dbOrder, regionId int, regionStart int) int {
 	regionEnd := regionStart
 	for i := regionStart; i < len(orders); i++ {
 		if orders[i].RegionId != regionId {
@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"testing"
 	"time"
 )
@@ -48,3 +49,38 @@ func TestHotDataPoint(t *testing.T) {
 		}
 	}
 }
+
+func TestForEachMarketInterleavedRegions(t *testing.T) {
+	orders := []dbOrder{
+		{RegionId: 10000043, TypeId: 34, Price: 6},
+		{RegionId: 10000002, TypeId: 34, Price: 5},
+		{RegionId: 10000043, TypeId: 34, Price: 4, IsBuyOrder: true},
+		{RegionId: 10000002, TypeId: 35, Price: 7},
+	}
+
+	markets := make(map[[2]int][2]int)
+	err := forEachMarket(context.Background(), orders, func(typeId, regionId int, buyOrders, sellOrders []*dbOrder) {
+		for _, o := range slices.Concat(buyOrders, sellOrders) {
+			if o.TypeId != typeId || o.RegionId != regionId {
+				t.Errorf("order of type %d in region %d given for type %d in region %d", o.TypeId, o.RegionId, typeId, regionId)
+			}
+		}
+		markets[[2]int{typeId, regionId}] = [2]int{len(buyOrders), len(sellOrders)}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[[2]int][2]int{
+		{34, 10000002}: {0, 1},
+		{34, 10000043}: {1, 1},
+		{35, 10000002}: {0, 1},
+	}
+	if len(markets) != len(expected) {
+		t.Fatalf("unexpected markets %v", markets)
+	}
+	for m, counts := range expected {
+		if markets[m] != counts {
+			t.Errorf("market %v: expected %v buy and sell orders, got %v", m, counts, markets[m])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// The hot metrics are written with a COPY, the rest are the queries of
+// sqliteMetrics
+type postgresMetrics struct {
+	sqliteMetrics
+}
+
+// Copy the rows into a table in a transaction
+func (s postgresMetrics) copyRows(ctx context.Context, rows [][]any, table string, columns ...string) error {
+	tx, err := s.a.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	copyStmt, err := tx.PrepareCopy(ctx, table, columns...)
+	if err != nil {
+		return err
+	}
+	defer copyStmt.Close()
+	for _, row := range rows {
+		_, err = copyStmt.Exec(ctx, row...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = copyStmt.Exec(ctx)
+	if err != nil {
+		return err
+	}
+	err = copyStmt.Close()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s postgresMetrics) InsertHotDataPoints(ctx context.Context, dps []hotDataPoint) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	rows := make([][]any, len(dps))
+	for i, dp := range dps {
+		rows[i] = []any{dp.typeId, dp.regionId, dp.time.Unix(), dp.buyPrice, dp.sellPrice}
+	}
+	return s.copyRows(timeoutCtx, rows, "HotTypeMetric", "TypeId", "RegionId", "Time", "BuyPrice", "SellPrice")
+}
+
+func (s postgresMetrics) InsertHotEstimates(ctx context.Context, estimates []hotEstimate) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	rows := make([][]any, len(estimates))
+	for i, e := range estimates {
+		rows[i] = []any{e.typeId, e.regionId, e.time.Unix(), e.estimator, e.buyPrice, e.sellPrice}
+	}
+	return s.copyRows(timeoutCtx, rows, "HotTypeEstimate", "TypeId", "RegionId", "Time", "Estimator", "BuyPrice", "SellPrice")
+}
+
+func (s postgresMetrics) InsertHotLocationDataPoints(ctx context.Context, dps []hotLocationDataPoint) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 6*time.Minute)
+	defer cancel()
+
+	rows := make([][]any, len(dps))
+	for i, dp := range dps {
+		rows[i] = []any{dp.typeId, dp.regionId, dp.locationId, dp.time.Unix(), dp.buyPrice, dp.sellPrice}
+	}
+	return s.copyRows(timeoutCtx, rows, "HotLocationMetric", "TypeId", "RegionId", "LocationId", "Time", "BuyPrice", "SellPrice")
+}
+
+func (s postgresMetrics) InsertHotGlobalDataPoints(ctx context.Context, dps []hotGlobalDataPoint) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	rows := make([][]any, len(dps))
+	for i, dp := range dps {
+		rows[i] = []any{
+			dp.regionId,
+			dp.time.Unix(),
+			dp.buyValue,
+			dp.sellValue,
+			dp.buyOrderCount,
+			dp.sellOrderCount,
+			dp.activeTypes,
+			dp.priceIndex,
+		}
+	}
+	return s.copyRows(
+		timeoutCtx,
+		rows,
+		"HotGlobalMetric",
+		"RegionId", "Time", "BuyValue", "SellValue", "BuyOrderCount", "SellOrderCount", "ActiveTypes", "PriceIndex",
+	)
+}
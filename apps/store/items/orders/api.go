@@ -5,12 +5,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/httpcache"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
 )
 
 // orders type that the store will return
@@ -33,8 +39,8 @@ type apiOrder struct {
 
 // NOTE: even though I could split the fonction in two api and db function,
 // I don't do it for the sake of performance.
-func CreateHandler(ctx context.Context) http.HandlerFunc {
-	db := ctx.Value("db").(*database.DB)
+func CreateHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	db := a.DB
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
@@ -43,17 +49,45 @@ func CreateHandler(ctx context.Context) http.HandlerFunc {
 		query := r.URL.Query()
 		typeId, err := strconv.Atoi(query.Get("type"))
 		if err != nil {
-			http.Error(w, `Bad request: param "type" is invalid integer`, 400)
+			openapi.ParamError(w, "type", "is invalid integer")
 			return
 		}
+		activemarkets.RecordRequest(a, typeId)
 		regionId, err := strconv.Atoi(query.Get("region"))
 		if err != nil {
-			http.Error(w, `Bad request: param "region" is invalid integer`, 400)
+			openapi.ParamError(w, "region", "is invalid integer")
 			return
 		}
 
+		refreshRegionId := regionId
+		if typeId == shared.PlexTypeId {
+			refreshRegionId = 0
+		}
+		lastRefresh, err := LastRefresh(timeoutCtx, a, refreshRegionId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		nextRefresh, err := timerecord.Get(timeoutCtx, a, "OrdersExpiration")
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+		// the orders and their refresh time are replaced in the same transaction
+		// so the refresh time identifies the orders snapshot
+		httpcache.SetFreshness(w, lastRefresh)
+		if !lastRefresh.IsZero() {
+			httpcache.SetMaxAge(w, nextRefresh, a.Config.Api.KeyRequired)
+			etag := fmt.Sprintf(`"%d-%d"`, refreshRegionId, lastRefresh.Unix())
+			if httpcache.NotModified(w, r, etag) {
+				return
+			}
+		}
+
 		var rows *sql.Rows
-		if typeId == 44992 { // plex
+		if typeId == shared.PlexTypeId {
 			orderQuery := `
       SELECT * FROM "Order"
         WHERE TypeId = ?;
@@ -134,3 +168,19 @@ func CreateHandler(ctx context.Context) http.HandlerFunc {
 		}
 	}
 }
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	return []openapi.Operation{
+		{
+			Path:    "/order",
+			Summary: "Orders of a type in a region",
+			Params: []openapi.Param{
+				{Name: "type", Type: "integer", Required: true, Description: "Type id"},
+				{Name: "region", Type: "integer", Required: true, Description: "Region id, 0 for all the regions"},
+			},
+			Response: []*apiOrder{},
+			Handler:  CreateHandler(ctx, a),
+		},
+	}
+}
@@ -2,9 +2,12 @@ package orders
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
 )
 
@@ -29,8 +32,28 @@ var esiToDbRangeMap = map[string]string{
 	"40":          "40 Jumps",
 }
 
-func dbReplaceOrders(ctx context.Context, orders []dbOrder) error {
-	db := ctx.Value("db").(*database.DB)
+// Repository of the orders
+type orderStore interface {
+	// Replace the orders of the downloaded regions, the orders of the other
+	// regions are kept
+	Replace(ctx context.Context, downloaded []int, orders []dbOrder) error
+	// Orders of a type in a region, 0 for all the regions. The plex orders are
+	// global.
+	Get(ctx context.Context, typeId int, regionId int) ([]dbOrder, error)
+	// Locations of the orders, each location is returned once
+	GetLocations(ctx context.Context, orders []dbOrder) ([]dbLocation, error)
+}
+
+func storeOf(a *app.App) orderStore {
+	return database.ForDriver[orderStore](a.DB, sqliteOrders{a: a}, postgresOrders{sqliteOrders{a: a}})
+}
+
+type sqliteOrders struct {
+	a *app.App
+}
+
+func (s sqliteOrders) Replace(ctx context.Context, downloaded []int, orders []dbOrder) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
@@ -40,12 +63,34 @@ func dbReplaceOrders(ctx context.Context, orders []dbOrder) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(timeoutCtx, "DELETE FROM `Order`")
+	deleteArgs := make([]any, len(downloaded))
+	for i, regionId := range downloaded {
+		deleteArgs[i] = regionId
+	}
+	_, err = tx.Exec(timeoutCtx, `DELETE FROM "Order" WHERE RegionId IN `+database.InList(len(downloaded)), deleteArgs...)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.PrepareWrite(timeoutCtx, "INSERT OR REPLACE INTO `Order` VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)")
+	// NOTE: an order can appear on two pages if it is updated during the
+	// download
+	insertQuery := `
+  INSERT INTO "Order" VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)
+    ON CONFLICT (Id) DO UPDATE SET
+      RegionId = excluded.RegionId,
+      Duration = excluded.Duration,
+      IsBuyOrder = excluded.IsBuyOrder,
+      Issued = excluded.Issued,
+      LocationId = excluded.LocationId,
+      MinVolume = excluded.MinVolume,
+      Price = excluded.Price,
+      Range = excluded.Range,
+      SystemId = excluded.SystemId,
+      TypeId = excluded.TypeId,
+      VolumeRemain = excluded.VolumeRemain,
+      VolumeTotal = excluded.VolumeTotal;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, insertQuery)
 	if err != nil {
 		return err
 	}
@@ -73,6 +118,11 @@ func dbReplaceOrders(ctx context.Context, orders []dbOrder) error {
 		}
 	}
 
+	err = recordRefresh(timeoutCtx, s.a, tx, downloaded)
+	if err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -80,3 +130,98 @@ func dbReplaceOrders(ctx context.Context, orders []dbOrder) error {
 
 	return err
 }
+
+// the refresh times are recorded in the transaction of the orders so that
+// they always match the orders served
+func recordRefresh(ctx context.Context, a *app.App, tx *database.Tx, downloaded []int) error {
+	refreshed := a.Now().Unix()
+	recordQuery := `INSERT INTO TimeRecord VALUES (?,?) ON CONFLICT ("Key") DO UPDATE SET Time = excluded.Time`
+	_, err := tx.Exec(ctx, recordQuery, refreshKey(0), refreshed)
+	if err != nil {
+		return err
+	}
+	for _, regionId := range downloaded {
+		_, err = tx.Exec(ctx, recordQuery, refreshKey(regionId), refreshed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dbLocation struct {
+	Id       int
+	Name     string
+	Security float32
+}
+
+func (s sqliteOrders) Get(ctx context.Context, typeId int, regionId int) ([]dbOrder, error) {
+	db := s.a.DB
+
+	var rows *sql.Rows
+	var err error
+	if typeId == shared.PlexTypeId || regionId == 0 {
+		rows, err = db.Query(ctx, `SELECT * FROM "Order" WHERE TypeId = ?`, typeId)
+	} else {
+		rows, err = db.Query(ctx, `SELECT * FROM "Order" WHERE TypeId = ? AND RegionId = ?`, typeId, regionId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]dbOrder, 0)
+	for rows.Next() {
+		var o dbOrder
+		err = rows.Scan(
+			&o.OrderId,
+			&o.RegionId,
+			&o.Duration,
+			&o.IsBuyOrder,
+			&o.Issued,
+			&o.LocationId,
+			&o.MinVolume,
+			&o.Price,
+			&o.Range,
+			&o.SystemId,
+			&o.TypeId,
+			&o.VolumeRemain,
+			&o.VolumeTotal,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+func (s sqliteOrders) GetLocations(ctx context.Context, orders []dbOrder) ([]dbLocation, error) {
+	db := s.a.DB
+
+	stmt, err := db.PrepareRead(ctx, "SELECT Name, Security FROM Location WHERE Id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	seen := make(map[int]struct{})
+	locations := make([]dbLocation, 0)
+	for _, o := range orders {
+		if _, ok := seen[o.LocationId]; ok {
+			continue
+		}
+		seen[o.LocationId] = struct{}{}
+
+		l := dbLocation{Id: o.LocationId}
+		err = stmt.QueryRow(ctx, o.LocationId).Scan(&l.Name, &l.Security)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if l.Name == "" {
+			l.Name = "Unknown Player Structure"
+		}
+		locations = append(locations, l)
+	}
+	return locations, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 	"github.com/raph5/eve-market-browser/apps/store/lib/security"
 )
@@ -26,9 +27,9 @@ type esiOrder struct {
 
 var ErrInvalidEsiData = errors.New("Invalid esi data")
 
-func fetchPageOrders(ctx context.Context, regionId int, page int) ([]dbOrder, int, error) {
+func fetchPageOrders(ctx context.Context, a *app.App, regionId int, page int) ([]dbOrder, int, error) {
 	uri := fmt.Sprintf("/markets/%d/orders?order_type=all&page=%d", regionId, page)
-	response, err := esi.EsiFetch[[]esiOrder](ctx, "GET", uri, nil, false, 2, 5)
+	response, err := esi.EsiFetch[[]esiOrder](ctx, a.Esi, "GET", uri, nil, false, 2, 5)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -0,0 +1,184 @@
+package orders
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
+	"github.com/raph5/eve-market-browser/apps/store/items/shared"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type grpcServer struct {
+	storepb.UnimplementedOrdersServer
+	ctx context.Context
+	a   *app.App
+}
+
+// Register the Orders service of the gRPC api
+func RegisterGrpc(ctx context.Context, a *app.App, server grpc.ServiceRegistrar) {
+	storepb.RegisterOrdersServer(server, &grpcServer{ctx: ctx, a: a})
+}
+
+func (s *grpcServer) GetOrders(ctx context.Context, req *storepb.OrdersRequest) (*storepb.OrdersReply, error) {
+	activemarkets.RecordRequest(s.a, int(req.TypeId))
+	reply, err := getOrdersReply(ctx, s.a, int(req.TypeId), int(req.RegionId))
+	if err != nil {
+		log.Printf("Internal server error: %v", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+	return reply, nil
+}
+
+// The orders are sent, then the orders that changed after each orders
+// download of the region. The orders that are gone are sent with no volume
+// remaining.
+func (s *grpcServer) WatchOrders(req *storepb.OrdersRequest, stream storepb.Orders_WatchOrdersServer) error {
+	typeId, regionId := int(req.TypeId), int(req.RegionId)
+	activemarkets.RecordRequest(s.a, typeId)
+	if typeId == shared.PlexTypeId {
+		regionId = 0
+	}
+
+	ch, unsubscribe, ok := events.Subscribe(s.a, 0, regionId)
+	if !ok {
+		return status.Error(codes.Unavailable, "Too many event subscribers")
+	}
+	defer unsubscribe()
+
+	sent := make(map[int]dbOrder)
+	send := func() error {
+		orders, lastRefresh, err := getOrders(stream.Context(), s.a, typeId, regionId)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			return status.Error(codes.Internal, "Internal server error")
+		}
+		reply, err := newOrdersReply(stream.Context(), s.a, diffOrders(sent, orders), lastRefresh)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			return status.Error(codes.Internal, "Internal server error")
+		}
+		return stream.Send(reply)
+	}
+
+	// NOTE: the events of a download carry the same time, one per region. The
+	// orders sent first are at least as recent as the last download.
+	lastHandled := events.LastOrders(s.a).Time
+	err := send()
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return status.Error(codes.Unavailable, "Store is stopping")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case e := <-ch:
+			// NOTE: without region filter, an orders event is received for every
+			// region of the same download
+			if e.Kind != events.OrdersEvent || e.Time <= lastHandled {
+				continue
+			}
+			lastHandled = e.Time
+			err = send()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Orders that are new or changed since sent, and the orders of sent that are
+// gone with no volume remaining. sent is updated to orders.
+func diffOrders(sent map[int]dbOrder, orders []dbOrder) []dbOrder {
+	changed := make([]dbOrder, 0)
+	current := make(map[int]struct{}, len(orders))
+	for _, o := range orders {
+		current[o.OrderId] = struct{}{}
+		if previous, ok := sent[o.OrderId]; !ok || previous != o {
+			changed = append(changed, o)
+			sent[o.OrderId] = o
+		}
+	}
+	for id, o := range sent {
+		if _, ok := current[id]; !ok {
+			o.VolumeRemain = 0
+			changed = append(changed, o)
+			delete(sent, id)
+		}
+	}
+	return changed
+}
+
+func getOrdersReply(ctx context.Context, a *app.App, typeId int, regionId int) (*storepb.OrdersReply, error) {
+	orders, lastRefresh, err := getOrders(ctx, a, typeId, regionId)
+	if err != nil {
+		return nil, err
+	}
+	return newOrdersReply(ctx, a, orders, lastRefresh)
+}
+
+func getOrders(ctx context.Context, a *app.App, typeId int, regionId int) ([]dbOrder, time.Time, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	refreshRegionId := regionId
+	if typeId == shared.PlexTypeId {
+		refreshRegionId = 0
+	}
+	lastRefresh, err := LastRefresh(timeoutCtx, a, refreshRegionId)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	orders, err := storeOf(a).Get(timeoutCtx, typeId, regionId)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return orders, lastRefresh, nil
+}
+
+func newOrdersReply(ctx context.Context, a *app.App, orders []dbOrder, lastRefresh time.Time) (*storepb.OrdersReply, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	locations, err := storeOf(a).GetLocations(timeoutCtx, orders)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &storepb.OrdersReply{
+		Orders:    make([]*storepb.Order, len(orders)),
+		Locations: make([]*storepb.Location, len(locations)),
+	}
+	if !lastRefresh.IsZero() {
+		reply.Refreshed = lastRefresh.Unix()
+	}
+	for i, o := range orders {
+		reply.Orders[i] = &storepb.Order{
+			OrderId:      int64(o.OrderId),
+			RegionId:     int32(o.RegionId),
+			Duration:     int32(o.Duration),
+			IsBuyOrder:   o.IsBuyOrder,
+			Issued:       o.Issued,
+			LocationId:   int64(o.LocationId),
+			MinVolume:    int32(o.MinVolume),
+			Price:        o.Price,
+			Range:        o.Range,
+			SystemId:     int32(o.SystemId),
+			TypeId:       int32(o.TypeId),
+			VolumeRemain: int32(o.VolumeRemain),
+			VolumeTotal:  int32(o.VolumeTotal),
+		}
+	}
+	for i, l := range locations {
+		reply.Locations[i] = &storepb.Location{Id: int64(l.Id), Name: l.Name, Security: l.Security}
+	}
+	return reply, nil
+}
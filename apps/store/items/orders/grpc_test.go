@@ -0,0 +1,101 @@
+package orders
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+	"github.com/raph5/eve-market-browser/apps/store/lib/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGrpcOrders(t *testing.T) {
+	a, ctx := apptest.New(t)
+
+	err := storeOf(a).Replace(ctx, []int{10000002, 10000043}, []dbOrder{
+		{OrderId: 1, RegionId: 10000002, TypeId: 34, LocationId: 1000000000001, Price: 5, Range: "Region"},
+		{OrderId: 2, RegionId: 10000002, TypeId: 34, LocationId: 1000000000001, Price: 4, Range: "Region", IsBuyOrder: true},
+		{OrderId: 3, RegionId: 10000043, TypeId: 34, LocationId: 60008494, Price: 6, Range: "Region"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterGrpc(ctx, a, server)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := storepb.NewOrdersClient(conn)
+
+	reqCtx, reqCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer reqCancel()
+	reply, err := client.GetOrders(reqCtx, &storepb.OrdersRequest{TypeId: 34, RegionId: 10000002})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Orders) != 2 || reply.Refreshed == 0 {
+		t.Fatalf("expected the 2 orders of the region, got %v", reply)
+	}
+	if len(reply.Locations) != 1 || reply.Locations[0].Name != "Unknown Player Structure" {
+		t.Errorf("expected the location to be sent once, got %v", reply.Locations)
+	}
+
+	stream, err := client.WatchOrders(reqCtx, &storepb.OrdersRequest{TypeId: 34})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Orders) != 3 {
+		t.Fatalf("expected the 3 orders of all the regions, got %v", first.Orders)
+	}
+
+	// the stream is subscribed before the first reply is sent
+	err = storeOf(a).Replace(ctx, []int{10000002, 10000043}, []dbOrder{{OrderId: 4, RegionId: 10000002, TypeId: 34, Price: 5, Range: "Region", VolumeRemain: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hoardling announces the download time, which is after the previous
+	// refresh
+	events.PublishOrders(a, time.Unix(first.Refreshed+1, 0), []int{10000002, 10000043})
+	second, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Orders) != 4 {
+		t.Fatalf("expected the new order and the 3 gone orders, got %v", second.Orders)
+	}
+	for _, o := range second.Orders {
+		if gone := o.OrderId != 4; gone != (o.VolumeRemain == 0) {
+			t.Errorf("unexpected order %v", o)
+		}
+	}
+
+	// the orders that did not change are not sent again
+	events.PublishOrders(a, time.Unix(first.Refreshed+2, 0), []int{10000002, 10000043})
+	third, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third.Orders) != 0 {
+		t.Errorf("expected no changed order, got %v", third.Orders)
+	}
+}
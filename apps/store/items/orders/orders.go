@@ -6,8 +6,13 @@ import (
 	"log"
 	"time"
 
+	"github.com/raph5/eve-market-browser/apps/store/items/alerts"
+	"github.com/raph5/eve-market-browser/apps/store/items/anomalies"
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
 	"github.com/raph5/eve-market-browser/apps/store/items/metrics"
 	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 )
 
 // NOTE: I tryed two approaches for downloading the orders:
@@ -19,37 +24,65 @@ import (
 // Though the first approach was more simple to write it turned out to be
 // significantly slower (~7min against ~3min for the second approach).
 // EDIT: Just fetching orders and regions sequentially works fine 👉👈
-func Download(ctx context.Context) error {
-	metricsEnabled := ctx.Value("metricsEnabled").(bool)
+//
+// Returns the regions whose orders were replaced
+func Download(ctx context.Context, a *app.App) ([]int, error) {
+	metricsEnabled := a.Config.Workers.Metrics
 	orders := make([]dbOrder, 0, 1024)
 
-	for _, regionId := range regions.Regions {
+	downloaded := regions.Downloaded(a)
+	for _, regionId := range downloaded {
 		var pageOrders []dbOrder
 		var err error
 		var pages int
 
 		for p := 1; p <= pages || p == 1; p++ {
-			pageOrders, pages, err = fetchPageOrders(ctx, regionId, p)
+			pageOrders, pages, err = fetchPageOrders(ctx, a, regionId, p)
 			if err != nil {
-				return fmt.Errorf("fetching page %d from region %d: %w", p, regionId, err)
+				return nil, fmt.Errorf("fetching page %d from region %d: %w", p, regionId, err)
 			}
 			// this slices are quite big, lets hop the gc does a great job...
 			orders = append(orders, pageOrders...)
 		}
 	}
 
+	retrivalTime := a.Now()
 	if metricsEnabled {
-		retrivalTime := time.Now()
-		err := metrics.CreateHotDataPoints(ctx, retrivalTime, orders)
+		err := metrics.CreateHotDataPoints(ctx, a, retrivalTime, orders)
 		if err != nil {
 			log.Printf("CreateHotDataPoints: %v", err)
 		}
 	}
 
-	err := dbReplaceOrders(ctx, orders)
+	err := anomalies.CheckOrders(ctx, a, retrivalTime, downloaded, orders)
+	if err != nil {
+		log.Printf("CheckOrders: %v", err)
+	}
+
+	err = storeOf(a).Replace(ctx, downloaded, orders)
+	if err != nil {
+		return nil, fmt.Errorf("replacing orders: %w", err)
+	}
+
+	err = alerts.Check(ctx, a, retrivalTime, orders)
 	if err != nil {
-		return fmt.Errorf("replacing orders: %w", err)
+		log.Printf("Alerts check: %v", err)
+	}
+	events.PublishMarketChanges(a, retrivalTime, orders)
+
+	return downloaded, nil
+}
+
+// TimeRecord key of the last orders refresh of a region, 0 for all regions
+func refreshKey(regionId int) string {
+	if regionId == 0 {
+		return "OrdersRefresh"
 	}
+	return fmt.Sprintf("OrdersRefresh%d", regionId)
+}
 
-	return nil
+// Time of the last successful orders refresh of a region, 0 for all regions.
+// The time is zero if the orders were never refreshed.
+func LastRefresh(ctx context.Context, a *app.App, regionId int) (time.Time, error) {
+	return timerecord.Get(ctx, a, refreshKey(regionId))
 }
@@ -0,0 +1,120 @@
+package orders
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
+)
+
+func TestDownload(t *testing.T) {
+	pages := map[string][]esiOrder{
+		"1": {{OrderId: 1, TypeId: 34, LocationId: 60003760, Price: 5, Range: "region", Duration: 90}},
+		"2": {{OrderId: 2, TypeId: 34, LocationId: 60003760, Price: 4, Range: "station", IsBuyOrder: true, Duration: 90}},
+	}
+	fakeEsi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/markets/10000002/orders" {
+			http.Error(w, `{"error": "not found"}`, 404)
+			return
+		}
+		w.Header().Set("X-Pages", "2")
+		json.NewEncoder(w).Encode(pages[r.URL.Query().Get("page")])
+	}))
+	defer fakeEsi.Close()
+
+	cfg := config.Default()
+	cfg.Workers.Metrics = false
+	cfg.Regions.Downloaded = []int{10000002}
+	a, ctx := apptest.NewWithOptions(t, apptest.Options{Config: &cfg})
+	a.Esi = esi.NewClient(esi.Options{Root: fakeEsi.URL})
+	a.Clock = func() time.Time { return time.Unix(1600000000, 0) }
+
+	// an order of a region that is no longer downloaded
+	err := storeOf(a).Replace(ctx, []int{10000043}, []dbOrder{{OrderId: 3, RegionId: 10000043, TypeId: 34, Price: 6, Range: "Region"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domainRefresh, err := LastRefresh(ctx, a, 10000043)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.Clock = func() time.Time { return time.Unix(1700000000, 0) }
+	downloaded, err := Download(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(downloaded) != 1 || downloaded[0] != 10000002 {
+		t.Errorf("expected only the forge to be downloaded, got %v", downloaded)
+	}
+
+	orders, err := storeOf(a).Get(ctx, 34, 10000002)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("expected the orders of the 2 pages, got %v", orders)
+	}
+
+	orders, err = storeOf(a).Get(ctx, 34, 10000043)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Errorf("expected the orders of the other regions to be kept, got %v", orders)
+	}
+	refresh, err := LastRefresh(ctx, a, 10000043)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refresh.Equal(domainRefresh) {
+		t.Errorf("the refresh of a region that is not downloaded changed")
+	}
+}
+
+func TestReplaceOrders(t *testing.T) {
+	stores, ctx := apptest.Databases(t, `"Order"`, "TimeRecord")
+	for driver, a := range stores {
+		a.Clock = func() time.Time { return time.Unix(1700000000, 0) }
+		err := storeOf(a).Replace(ctx, []int{10000043}, []dbOrder{{OrderId: 3, RegionId: 10000043, TypeId: 34, Price: 6, Range: "Region"}})
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		// the order 1 is on two pages
+		err = storeOf(a).Replace(ctx, []int{10000002}, []dbOrder{
+			{OrderId: 1, RegionId: 10000002, TypeId: 34, Price: 5, Range: "Region", VolumeRemain: 10},
+			{OrderId: 2, RegionId: 10000002, TypeId: 34, Price: 4, Range: "Station", IsBuyOrder: true},
+			{OrderId: 1, RegionId: 10000002, TypeId: 34, Price: 5, Range: "Region", VolumeRemain: 8},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+
+		orders, err := storeOf(a).Get(ctx, 34, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		volumes := make(map[int]int)
+		for _, o := range orders {
+			volumes[o.OrderId] = o.VolumeRemain
+			if o.OrderId == 2 && !o.IsBuyOrder {
+				t.Errorf("%s: expected the order 2 to be a buy order", driver)
+			}
+		}
+		if len(orders) != 3 || volumes[1] != 8 {
+			t.Errorf("%s: expected the orders 1 to 3 with the last volume of the order 1, got %v", driver, orders)
+		}
+		refresh, err := LastRefresh(ctx, a, 10000002)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if refresh.Unix() != 1700000000 {
+			t.Errorf("%s: expected the refresh of the forge to be recorded, got %v", driver, refresh)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package orders
+
+import (
+	"context"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+// The orders are copied into a temporary table and moved to "Order" with a
+// single upsert, the reads are the queries of sqliteOrders.
+type postgresOrders struct {
+	sqliteOrders
+}
+
+func (s postgresOrders) Replace(ctx context.Context, downloaded []int, orders []dbOrder) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	// NOTE: an order can appear on two pages if it is updated during the
+	// download, the upsert can't update a row twice so the last one is kept
+	positions := make(map[int]int, len(orders))
+	unique := make([]dbOrder, 0, len(orders))
+	for _, o := range orders {
+		if i, ok := positions[o.OrderId]; ok {
+			unique[i] = o
+			continue
+		}
+		positions[o.OrderId] = len(unique)
+		unique = append(unique, o)
+	}
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deleteArgs := make([]any, len(downloaded))
+	for i, regionId := range downloaded {
+		deleteArgs[i] = regionId
+	}
+	_, err = tx.Exec(timeoutCtx, `DELETE FROM "Order" WHERE RegionId IN `+database.InList(len(downloaded)), deleteArgs...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(timeoutCtx, `CREATE TEMPORARY TABLE OrderCopy (LIKE "Order") ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: no other statement of the transaction can run during the copy
+	copyStmt, err := tx.PrepareCopy(
+		timeoutCtx,
+		"OrderCopy",
+		"Id",
+		"RegionId",
+		"Duration",
+		"IsBuyOrder",
+		"Issued",
+		"LocationId",
+		"MinVolume",
+		"Price",
+		"Range",
+		"SystemId",
+		"TypeId",
+		"VolumeRemain",
+		"VolumeTotal",
+	)
+	if err != nil {
+		return err
+	}
+	defer copyStmt.Close()
+	for _, o := range unique {
+		_, err = copyStmt.Exec(
+			timeoutCtx,
+			o.OrderId,
+			o.RegionId,
+			o.Duration,
+			o.IsBuyOrder,
+			o.Issued,
+			o.LocationId,
+			o.MinVolume,
+			o.Price,
+			o.Range,
+			o.SystemId,
+			o.TypeId,
+			o.VolumeRemain,
+			o.VolumeTotal,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = copyStmt.Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	err = copyStmt.Close()
+	if err != nil {
+		return err
+	}
+
+	moveQuery := `
+  INSERT INTO "Order" SELECT * FROM OrderCopy
+    ON CONFLICT (Id) DO UPDATE SET
+      RegionId = excluded.RegionId,
+      Duration = excluded.Duration,
+      IsBuyOrder = excluded.IsBuyOrder,
+      Issued = excluded.Issued,
+      LocationId = excluded.LocationId,
+      MinVolume = excluded.MinVolume,
+      Price = excluded.Price,
+      Range = excluded.Range,
+      SystemId = excluded.SystemId,
+      TypeId = excluded.TypeId,
+      VolumeRemain = excluded.VolumeRemain,
+      VolumeTotal = excluded.VolumeTotal;
+  `
+	_, err = tx.Exec(timeoutCtx, moveQuery)
+	if err != nil {
+		return err
+	}
+
+	err = recordRefresh(timeoutCtx, s.a, tx, downloaded)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
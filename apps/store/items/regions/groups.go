@@ -0,0 +1,153 @@
+// Region groups are named sets of regions. For each group an aggregated
+// history is computed the same way the global history (RegionId 0) is, and it
+// is stored in the History table under the synthetic id of the group.
+
+package regions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type Group struct {
+	Name    string `json:"name"`
+	Id      int    `json:"id"`
+	Regions []int  `json:"regions"`
+}
+
+// Ids below FirstRegionId are synthetic: 0 is the global market and region
+// groups use ids from 1 to FirstRegionId-1
+const FirstRegionId = 10000000
+
+var ErrInvalidGroup = errors.New("invalid region group")
+
+var empireRegions = []int{
+	10000001, // derelik
+	10000002, // the forge
+	10000016, // lonetrek
+	10000020, // tash-murkon
+	10000028, // molden heath
+	10000030, // heimatar
+	10000032, // sinq laison
+	10000033, // the citadel
+	10000036, // devoid
+	10000037, // everyshore
+	10000038, // the bleak lands
+	10000042, // metropolis
+	10000043, // domain
+	10000044, // solitude
+	10000048, // placid
+	10000049, // khanid
+	10000052, // kador
+	10000054, // aridia
+	10000064, // essence
+	10000065, // kor-azor
+	10000067, // genesis
+	10000068, // verge vendor
+	10000069, // black rise
+}
+
+var nullsecRegions = []int{
+	10000003, 10000005, 10000006, 10000007, 10000008, 10000009, 10000010,
+	10000011, 10000012, 10000013, 10000014, 10000015, 10000018, 10000021,
+	10000022, 10000023, 10000025, 10000027, 10000029, 10000031, 10000034,
+	10000035, 10000039, 10000040, 10000041, 10000045, 10000046, 10000047,
+	10000050, 10000051, 10000053, 10000055, 10000056, 10000057, 10000058,
+	10000059, 10000060, 10000061, 10000062, 10000063, 10000066,
+}
+
+// Default groups, they are replaced by the groups file of the config
+var defaultGroups = []Group{
+	{Name: "empire", Id: 1, Regions: empireRegions},
+	{Name: "highsec-hubs", Id: 2, Regions: Hubs[:]},
+	{Name: "nullsec", Id: 3, Regions: nullsecRegions},
+	{Name: "pochven", Id: 4, Regions: []int{10000070}},
+}
+
+type groups struct {
+	groups []Group
+}
+
+// NOTE: the groups are loaded at startup, before the workers and the servers
+// read them
+func getGroups(a *app.App) *groups {
+	return app.State(a, "regions", func() *groups { return &groups{groups: defaultGroups} })
+}
+
+// Replace the default groups by the ones defined in the json file at path.
+// The file must contain a list of {"name": string, "id": int, "regions": [int]}
+func LoadGroups(a *app.App, path string) error {
+	groupsJson, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read groups file: %w", err)
+	}
+
+	var groups []Group
+	err = json.Unmarshal(groupsJson, &groups)
+	if err != nil {
+		return fmt.Errorf("unmarshal groups: %w", err)
+	}
+	err = validateGroups(groups)
+	if err != nil {
+		return err
+	}
+
+	getGroups(a).groups = groups
+	return nil
+}
+
+func Groups(a *app.App) []Group {
+	return getGroups(a).groups
+}
+
+func GetGroup(a *app.App, name string) (Group, bool) {
+	for _, g := range Groups(a) {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return Group{}, false
+}
+
+func (g *Group) Contains(regionId int) bool {
+	for _, id := range g.Regions {
+		if id == regionId {
+			return true
+		}
+	}
+	return false
+}
+
+func validateGroups(groups []Group) error {
+	names := make(map[string]struct{}, len(groups))
+	ids := make(map[int]struct{}, len(groups))
+	for _, g := range groups {
+		if g.Name == "" {
+			return fmt.Errorf("group %d has no name: %w", g.Id, ErrInvalidGroup)
+		}
+		if g.Id <= 0 || g.Id >= FirstRegionId {
+			return fmt.Errorf("group %s id %d out of range: %w", g.Name, g.Id, ErrInvalidGroup)
+		}
+		if len(g.Regions) == 0 {
+			return fmt.Errorf("group %s is empty: %w", g.Name, ErrInvalidGroup)
+		}
+		for _, regionId := range g.Regions {
+			if regionId < FirstRegionId {
+				return fmt.Errorf("group %s contains invalid region %d: %w", g.Name, regionId, ErrInvalidGroup)
+			}
+		}
+		if _, ok := names[g.Name]; ok {
+			return fmt.Errorf("duplicate group name %s: %w", g.Name, ErrInvalidGroup)
+		}
+		if _, ok := ids[g.Id]; ok {
+			return fmt.Errorf("duplicate group id %d: %w", g.Id, ErrInvalidGroup)
+		}
+		names[g.Name] = struct{}{}
+		ids[g.Id] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package regions
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+)
+
+func TestDefaultGroups(t *testing.T) {
+	err := validateGroups(defaultGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, g := range defaultGroups {
+		for _, regionId := range g.Regions {
+			found := false
+			for _, id := range Regions {
+				if id == regionId {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("group %s contains unknown region %d", g.Name, regionId)
+			}
+		}
+	}
+}
+
+func TestInvalidGroups(t *testing.T) {
+	invalidGroups := [][]Group{
+		{{Name: "", Id: 1, Regions: []int{10000002}}},
+		{{Name: "a", Id: 0, Regions: []int{10000002}}},
+		{{Name: "a", Id: FirstRegionId, Regions: []int{10000002}}},
+		{{Name: "a", Id: 1, Regions: []int{}}},
+		{{Name: "a", Id: 1, Regions: []int{4}}},
+		{{Name: "a", Id: 1, Regions: []int{10000002}}, {Name: "a", Id: 2, Regions: []int{10000002}}},
+		{{Name: "a", Id: 1, Regions: []int{10000002}}, {Name: "b", Id: 1, Regions: []int{10000002}}},
+	}
+
+	for _, groups := range invalidGroups {
+		err := validateGroups(groups)
+		if !errors.Is(err, ErrInvalidGroup) {
+			t.Errorf("expected ErrInvalidGroup for %v, got %v", groups, err)
+		}
+	}
+}
+
+func TestLoadGroups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.json")
+	err := os.WriteFile(path, []byte(`[{"name": "forge", "id": 1, "regions": [10000002]}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := app.New(nil, nil, config.Default())
+	other := app.New(nil, nil, config.Default())
+	err = LoadGroups(a, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetGroup(a, "forge"); !ok || len(Groups(a)) != 1 {
+		t.Errorf("expected the loaded groups, got %v", Groups(a))
+	}
+	if _, ok := GetGroup(other, "empire"); !ok {
+		t.Errorf("expected the default groups in another app, got %v", Groups(other))
+	}
+}
@@ -1,7 +1,22 @@
 package regions
 
+import (
+	"fmt"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
 var GlobalPlexMarket = 19000001
 
+// Regions of the main trade hubs: Jita, Amarr, Dodixie, Rens and Hek
+var Hubs = [...]int{
+	10000002, // the forge
+	10000043, // domain
+	10000032, // sinq laison
+	10000030, // heimatar
+	10000042, // metropolis
+}
+
 var Regions = [...]int{
 	10000002,
 	10000043,
@@ -72,3 +87,41 @@ var Regions = [...]int{
 	11000031, // thera
 	19000001, // global plex market
 }
+
+func IsHub(regionId int) bool {
+	for _, id := range Hubs {
+		if id == regionId {
+			return true
+		}
+	}
+	return false
+}
+
+func IsRegion(regionId int) bool {
+	for _, id := range Regions {
+		if id == regionId {
+			return true
+		}
+	}
+	return false
+}
+
+// Regions whose orders are downloaded, regions.downloaded of the running
+// config or all the regions
+func Downloaded(a *app.App) []int {
+	downloaded := a.Current().Regions.Downloaded
+	if len(downloaded) == 0 {
+		return Regions[:]
+	}
+	return downloaded
+}
+
+// Check the regions of regions.downloaded
+func CheckDownloaded(regionIds []int) error {
+	for _, id := range regionIds {
+		if !IsRegion(id) {
+			return fmt.Errorf("%d is not a region", id)
+		}
+	}
+	return nil
+}
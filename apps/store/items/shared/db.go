@@ -0,0 +1,315 @@
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	vm "github.com/VictoriaMetrics/metrics"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+)
+
+// Repository of the histories. The histories are read and written as json, the
+// storage depends on the database: sqlite keeps an encoded blob per market in
+// History and postgres a row per day in the HistoryDay hypertable.
+type HistoryStore interface {
+	// Replace the histories of the markets
+	Insert(ctx context.Context, histories []DbHistory) error
+	// WARN: nillable return value
+	Get(ctx context.Context, typeId int, regionId int) ([]byte, error)
+	// WARN: nillable return value
+	GetDays(ctx context.Context, typeId int, regionId int) ([]DbHistoryDay, error)
+	// Histories of typeId in the regions, without the global and region
+	// groups histories and the corrupted histories
+	GetOfType(ctx context.Context, typeId int) ([]DbHistory, error)
+	// Rewrite up to limit rows stored before the current storage. Returns the
+	// number of rows left.
+	Compact(ctx context.Context, limit int) (int, error)
+	// Rows and bytes of the histories by format
+	Sizes(ctx context.Context) ([]HistorySize, error)
+}
+
+type HistorySize struct {
+	Format int
+	Rows   int
+	Bytes  int64
+}
+
+// History row read by Compact or GetOfType
+type historyMarket struct {
+	typeId   int
+	regionId int
+	format   int
+	days     []DbHistoryDay
+}
+
+func Histories(a *app.App) HistoryStore {
+	return database.ForDriver[HistoryStore](a.DB, sqliteHistories{a: a}, postgresHistories{a: a})
+}
+
+type sqliteHistories struct {
+	a *app.App
+}
+
+func (s sqliteHistories) Insert(ctx context.Context, histories []DbHistory) error {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	// NOTE: the transaction here is not strictly necessary, if it lock the db
+	// for too long I may to remove it.
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+  INSERT INTO History VALUES (?,?,?,?)
+    ON CONFLICT (TypeId, RegionId) DO UPDATE SET
+      HistoryBlob = excluded.HistoryBlob,
+      Format = excluded.Format;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, insertQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, h := range histories {
+		blob, err := encodeHistory(h.History)
+		format := HistoryFormatColumns
+		if err != nil {
+			log.Printf("Histories: can't encode the history of type %d in region %d: %v", h.TypeId, h.RegionId, err)
+			blob, format = h.History, HistoryFormatCorrupted
+		}
+		_, err = stmt.Exec(timeoutCtx, h.TypeId, h.RegionId, blob, format)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Encode a json history for the History table and report the space saved
+func encodeHistory(historyJson []byte) ([]byte, error) {
+	blob, err := EncodeHistory(historyJson)
+	if err != nil {
+		return nil, err
+	}
+	vm.GetOrCreateCounter("store_history_json_bytes_total").Add(len(historyJson))
+	vm.GetOrCreateCounter("store_history_encoded_bytes_total").Add(len(blob))
+	return blob, nil
+}
+
+func (s sqliteHistories) getBlob(ctx context.Context, typeId int, regionId int) (blob []byte, format int, ok bool, err error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	selectQuery := `
+  SELECT HistoryBlob, Format FROM History
+    WHERE TypeId = ? AND RegionId = ?;
+  `
+	err = db.QueryRow(timeoutCtx, selectQuery, typeId, regionId).Scan(&blob, &format)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, err
+	}
+	return blob, format, true, nil
+}
+
+func (s sqliteHistories) Get(ctx context.Context, typeId int, regionId int) ([]byte, error) {
+	blob, format, ok, err := s.getBlob(ctx, typeId, regionId)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return DecodeHistory(format, blob)
+}
+
+func (s sqliteHistories) GetDays(ctx context.Context, typeId int, regionId int) ([]DbHistoryDay, error) {
+	blob, format, ok, err := s.getBlob(ctx, typeId, regionId)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return DecodeHistoryDays(format, blob)
+}
+
+func (s sqliteHistories) GetOfType(ctx context.Context, typeId int) ([]DbHistory, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT HistoryBlob, Format, RegionId FROM History
+    WHERE TypeId = ? AND RegionId >= ? AND Format <> ?`
+	rows, err := db.Query(timeoutCtx, selectQuery, typeId, regions.FirstRegionId, HistoryFormatCorrupted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make([]DbHistory, 0)
+	corrupted := make([]historyMarket, 0)
+	for rows.Next() {
+		var h = DbHistory{TypeId: typeId}
+		var blob []byte
+		var format int
+		err = rows.Scan(&blob, &format, &h.RegionId)
+		if err != nil {
+			return nil, err
+		}
+		h.History, err = DecodeHistory(format, blob)
+		if err != nil {
+			log.Printf("Histories: can't decode the history of type %d in region %d: %v", typeId, h.RegionId, err)
+			corrupted = append(corrupted, historyMarket{typeId: typeId, regionId: h.RegionId, format: format})
+			continue
+		}
+		histories = append(histories, h)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = markCorrupted(timeoutCtx, db, corrupted)
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+// Mark the History rows that failed to decode. The Format condition skips the
+// rows rewritten since they were read.
+func markCorrupted(ctx context.Context, db *database.DB, markets []historyMarket) error {
+	markQuery := `
+  UPDATE History SET Format = ?
+    WHERE TypeId = ? AND RegionId = ? AND Format = ?;
+  `
+	for _, m := range markets {
+		_, err := db.Exec(ctx, markQuery, HistoryFormatCorrupted, m.typeId, m.regionId, m.format)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode the json rows of the History table, the rows written before the
+// encoding existed. The rows that fail to decode are marked corrupted.
+func (s sqliteHistories) Compact(ctx context.Context, limit int) (int, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	// NOTE: the literal Format = 0 lets sqlite use the partial HistoryJsonIndex
+	selectQuery := `
+  SELECT TypeId, RegionId, HistoryBlob FROM History
+    WHERE Format = 0
+    LIMIT ?;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, limit)
+	if err != nil {
+		return 0, err
+	}
+	histories := make([]DbHistory, 0, limit)
+	for rows.Next() {
+		var h DbHistory
+		err = rows.Scan(&h.TypeId, &h.RegionId, &h.History)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		histories = append(histories, h)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin(timeoutCtx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// NOTE: the Format condition skips the rows rewritten since the select
+	updateQuery := `
+  UPDATE History SET HistoryBlob = ?, Format = ?
+    WHERE TypeId = ? AND RegionId = ? AND Format = 0;
+  `
+	stmt, err := tx.PrepareWrite(timeoutCtx, updateQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, h := range histories {
+		blob, err := encodeHistory(h.History)
+		format := HistoryFormatColumns
+		if err != nil {
+			log.Printf("Histories: can't compact the history of type %d in region %d: %v", h.TypeId, h.RegionId, err)
+			blob, format = h.History, HistoryFormatCorrupted
+		}
+		_, err = stmt.Exec(timeoutCtx, blob, format, h.TypeId, h.RegionId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	var left int
+	err = db.QueryRow(timeoutCtx, "SELECT COUNT(*) FROM History WHERE Format = 0").Scan(&left)
+	if err != nil {
+		return 0, err
+	}
+
+	return left, nil
+}
+
+func (s sqliteHistories) Sizes(ctx context.Context) ([]HistorySize, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT Format, COUNT(*), COALESCE(SUM(LENGTH(HistoryBlob)), 0) FROM History
+    GROUP BY Format
+    ORDER BY Format;
+  `
+	rows, err := s.a.DB.Query(timeoutCtx, selectQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make([]HistorySize, 0)
+	for rows.Next() {
+		var size HistorySize
+		err = rows.Scan(&size.Format, &size.Rows, &size.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, rows.Err()
+}
@@ -0,0 +1,143 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/apptest"
+)
+
+func TestHistoryStore(t *testing.T) {
+
+	days := []DbHistoryDay{
+		{Date: "2024-03-01", Average: 5.5, Average5d: 5.4, Highest: 6, Lowest: 5, OrderCount: 10, Volume: 3e9, DonchianTop: 6, DonchianBottom: 5},
+		{Date: "2024-03-02", Average: 5.5, Average5d: 5.45, Highest: 5.5, Lowest: 5.5, DonchianTop: 6, DonchianBottom: 5, Filled: true},
+	}
+	historyJson, err := json.Marshal(days)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyJson, err := json.Marshal(days[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores, ctx := apptest.Databases(t, "History", "HistoryDay")
+	for name, a := range stores {
+		t.Run(name, func(t *testing.T) {
+			db := a.DB
+			store := Histories(a)
+
+			// rows written before the current storage, one of them corrupted, a
+			// downloaded history, an empty one, one that fails to encode and a
+			// global history
+			insertQuery := "INSERT INTO History (TypeId, RegionId, HistoryBlob) VALUES (?,?,?)"
+			_, err := db.Exec(ctx, insertQuery, 34, 10000002, legacyJson)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = db.Exec(ctx, insertQuery, 34, 10000030, []byte(`[{"date":`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.Insert(ctx, []DbHistory{
+				{TypeId: 34, RegionId: 10000043, History: []byte("[]")},
+				{TypeId: 34, RegionId: 10000032, History: legacyJson},
+				{TypeId: 34, RegionId: 10000033, History: []byte(`[{"date":`)},
+				{TypeId: 34, RegionId: 0, History: historyJson},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// replacing a history drops its previous days
+			err = store.Insert(ctx, []DbHistory{{TypeId: 34, RegionId: 10000032, History: historyJson}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Get(ctx, 34, 10000032)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, historyJson) {
+				t.Errorf("unexpected history:\n%s\n%s", got, historyJson)
+			}
+			got, err = store.Get(ctx, 34, 10000043)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "[]" {
+				t.Errorf("unexpected empty history %s", got)
+			}
+			got, err = store.Get(ctx, 35, 10000002)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != nil {
+				t.Errorf("unexpected history of an unknown market %s", got)
+			}
+			gotDays, err := store.GetDays(ctx, 34, 10000002)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gotDays) != 1 || gotDays[0] != days[0] {
+				t.Errorf("unexpected days of the row written before the current storage %+v", gotDays)
+			}
+
+			left, err := store.Compact(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left != 0 {
+				t.Errorf("%d rows left to compact", left)
+			}
+			var format int
+			err = db.QueryRow(ctx, "SELECT Format FROM History WHERE TypeId = ? AND RegionId = ?", 34, 10000030).Scan(&format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != HistoryFormatCorrupted {
+				t.Errorf("expected the corrupted row to be marked, got format %d", format)
+			}
+			_, err = store.Get(ctx, 34, 10000030)
+			if err == nil {
+				t.Errorf("expected an error reading the corrupted history")
+			}
+
+			// a blob that fails to decode is skipped by GetOfType
+			_, err = db.Exec(ctx, "INSERT INTO History VALUES (?,?,?,?)", 34, 10000044, []byte("garbage"), HistoryFormatColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			histories, err := store.GetOfType(ctx, 34)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := map[int]string{10000002: string(legacyJson), 10000043: "[]", 10000032: string(historyJson)}
+			if len(histories) != len(expected) {
+				t.Fatalf("expected the histories of the 3 regions, got %d", len(histories))
+			}
+			for _, h := range histories {
+				if string(h.History) != expected[h.RegionId] {
+					t.Errorf("unexpected history of region %d:\n%s\n%s", h.RegionId, h.History, expected[h.RegionId])
+				}
+			}
+
+			sizes, err := store.Sizes(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			corrupted := 0
+			for _, size := range sizes {
+				if size.Format == HistoryFormatCorrupted {
+					corrupted = size.Rows
+				}
+			}
+			if corrupted != 3 {
+				t.Errorf("expected the rows of the regions 10000030, 10000033 and 10000044 to be marked corrupted, got %d corrupted rows", corrupted)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Encoding of the History rows. The rows written before the encoding existed
+// are json, the new rows are columns of the history days compressed with zstd.
+// The Format column of a row tells which is which.
+//
+// Columns layout, before compression:
+//   - uvarint number of days
+//   - varint dates as day offsets, the first from 1970-01-01 then from the
+//     previous date
+//   - for each float field, the float bits xored with the bits of the
+//     previous day, 8 bytes little endian
+//   - varint order counts and volumes as deltas from the previous day
+//   - a byte per day for the filled flag
+//
+// The json produced by DecodeHistory is the json that was encoded, so the
+// clients get the same histories before and after the migration.
+
+package shared
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	HistoryFormatJson    = 0
+	HistoryFormatColumns = 1
+	// postgres: the days of the market are in HistoryDay
+	HistoryFormatDays = 2
+	// rows the compaction failed to decode, kept for inspection
+	HistoryFormatCorrupted = -1
+)
+
+// Name of the format in the metrics labels
+func HistoryFormatName(format int) string {
+	switch format {
+	case HistoryFormatJson:
+		return "json"
+	case HistoryFormatColumns:
+		return "columns"
+	case HistoryFormatDays:
+		return "days"
+	case HistoryFormatCorrupted:
+		return "corrupted"
+	default:
+		return strconv.Itoa(format)
+	}
+}
+
+type DbHistoryDay struct {
+	Date           string  `json:"date"`
+	Average        float64 `json:"average"`
+	Average5d      float64 `json:"average5d"`
+	Average20d     float64 `json:"average20d"`
+	Highest        float64 `json:"highest"`
+	Lowest         float64 `json:"lowest"`
+	OrderCount     int     `json:"orderCount"`
+	Volume         int64   `json:"volume"`
+	DonchianTop    float64 `json:"donchianTop"`
+	DonchianBottom float64 `json:"donchianBottom"`
+	// Filled days are synthesized to fill the gaps between trading days
+	Filled bool `json:"filled,omitempty"`
+}
+
+const historyDateLayout = "2006-01-02"
+
+// NOTE: the longest histories are a bit more than a year of days, 1MiB is
+// far above the size of any decoded history
+const maxDecodedHistorySize = 1 << 20
+
+var errCorruptedHistory = errors.New("corrupted history blob")
+
+// EncodeAll and DecodeAll are safe for concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedHistorySize))
+
+// Encode the json of a history into the columns format
+func EncodeHistory(historyJson []byte) ([]byte, error) {
+	var days []DbHistoryDay
+	err := json.Unmarshal(historyJson, &days)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal history: %w", err)
+	}
+	return EncodeHistoryDays(days)
+}
+
+func EncodeHistoryDays(days []DbHistoryDay) ([]byte, error) {
+	// 7 float columns, the other columns take a few bytes
+	buf := make([]byte, 0, 16+len(days)*64)
+	buf = binary.AppendUvarint(buf, uint64(len(days)))
+
+	var prevDate int64
+	for _, d := range days {
+		t, err := time.Parse(historyDateLayout, d.Date)
+		if err != nil {
+			return nil, fmt.Errorf("history date: %w", err)
+		}
+		date := t.Unix() / 86400
+		buf = binary.AppendVarint(buf, date-prevDate)
+		prevDate = date
+	}
+
+	for _, field := range historyFloatFields {
+		var prev uint64
+		for i := range days {
+			bits := math.Float64bits(*field(&days[i]))
+			buf = binary.LittleEndian.AppendUint64(buf, bits^prev)
+			prev = bits
+		}
+	}
+
+	var prevOrderCount, prevVolume int64
+	for _, d := range days {
+		buf = binary.AppendVarint(buf, int64(d.OrderCount)-prevOrderCount)
+		prevOrderCount = int64(d.OrderCount)
+	}
+	for _, d := range days {
+		buf = binary.AppendVarint(buf, d.Volume-prevVolume)
+		prevVolume = d.Volume
+	}
+
+	for _, d := range days {
+		if d.Filled {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	}
+
+	return zstdEncoder.EncodeAll(buf, nil), nil
+}
+
+// Json of a History row of any format
+func DecodeHistory(format int, blob []byte) ([]byte, error) {
+	if format == HistoryFormatJson {
+		return blob, nil
+	}
+	days, err := DecodeHistoryDays(format, blob)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(days)
+}
+
+func DecodeHistoryDays(format int, blob []byte) ([]DbHistoryDay, error) {
+	switch format {
+	case HistoryFormatJson:
+		var days []DbHistoryDay
+		err := json.Unmarshal(blob, &days)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal history: %w", err)
+		}
+		return days, nil
+	case HistoryFormatColumns:
+	case HistoryFormatCorrupted:
+		return nil, errCorruptedHistory
+	default:
+		return nil, fmt.Errorf("unknown history format %d", format)
+	}
+
+	buf, err := zstdDecoder.DecodeAll(blob, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress history: %w", err)
+	}
+	r := historyReader{buf: buf}
+
+	n := r.uvarint()
+	// each day takes at least 60 bytes, this bounds the allocation
+	if n > uint64(len(buf))/60 {
+		return nil, errCorruptedHistory
+	}
+	days := make([]DbHistoryDay, n)
+
+	var date int64
+	for i := range days {
+		date += r.varint()
+		days[i].Date = time.Unix(date*86400, 0).UTC().Format(historyDateLayout)
+	}
+
+	for _, field := range historyFloatFields {
+		var prev uint64
+		for i := range days {
+			bits := r.uint64() ^ prev
+			*field(&days[i]) = math.Float64frombits(bits)
+			prev = bits
+		}
+	}
+
+	var orderCount, volume int64
+	for i := range days {
+		orderCount += r.varint()
+		days[i].OrderCount = int(orderCount)
+	}
+	for i := range days {
+		volume += r.varint()
+		days[i].Volume = volume
+	}
+
+	for i := range days {
+		days[i].Filled = r.byte() == 1
+	}
+
+	if r.err || len(r.buf) != 0 {
+		return nil, errCorruptedHistory
+	}
+	return days, nil
+}
+
+var historyFloatFields = []func(d *DbHistoryDay) *float64{
+	func(d *DbHistoryDay) *float64 { return &d.Average },
+	func(d *DbHistoryDay) *float64 { return &d.Average5d },
+	func(d *DbHistoryDay) *float64 { return &d.Average20d },
+	func(d *DbHistoryDay) *float64 { return &d.Highest },
+	func(d *DbHistoryDay) *float64 { return &d.Lowest },
+	func(d *DbHistoryDay) *float64 { return &d.DonchianTop },
+	func(d *DbHistoryDay) *float64 { return &d.DonchianBottom },
+}
+
+// Reads the columns, err is set on the first read past the end of buf
+type historyReader struct {
+	buf []byte
+	err bool
+}
+
+func (r *historyReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = true
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *historyReader) varint() int64 {
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = true
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *historyReader) uint64() uint64 {
+	if len(r.buf) < 8 {
+		r.err = true
+		r.buf = nil
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v
+}
+
+func (r *historyReader) byte() byte {
+	if len(r.buf) < 1 {
+		r.err = true
+		r.buf = nil
+		return 0
+	}
+	v := r.buf[0]
+	r.buf = r.buf[1:]
+	return v
+}
@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestHistoryRoundTrip(t *testing.T) {
+	days := []DbHistoryDay{
+		{Date: "2024-02-28", Average: 5.61, Average5d: 5.6, Average20d: 5.58, Highest: 5.8, Lowest: 5.4, OrderCount: 1543, Volume: 3112450987, DonchianTop: 5.9, DonchianBottom: 5.1},
+		{Date: "2024-02-29", Average: 5.61, Average5d: 5.6, Average20d: 5.58, Highest: 5.61, Lowest: 5.61, Filled: true, DonchianTop: 5.9, DonchianBottom: 5.1},
+		{Date: "2024-03-01", Average: 0.1 + 0.2, Average5d: 1e-300, Average20d: 1e300, Highest: 6, Lowest: 4, OrderCount: 12, Volume: 1},
+	}
+	for _, days := range [][]DbHistoryDay{days, {}} {
+		historyJson, err := json.Marshal(days)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blob, err := EncodeHistory(historyJson)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeHistory(HistoryFormatColumns, blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, historyJson) {
+			t.Errorf("decoded json differs:\n%s\n%s", decoded, historyJson)
+		}
+	}
+}
+
+func TestHistoryCorrupted(t *testing.T) {
+	blob, err := EncodeHistoryDays([]DbHistoryDay{{Date: "2024-03-01", Average: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := zstdDecoder.DecodeAll(blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := zstdEncoder.EncodeAll(buf[:len(buf)-1], nil)
+	_, err = DecodeHistoryDays(HistoryFormatColumns, truncated)
+	if err == nil {
+		t.Error("truncated history decoded")
+	}
+	_, err = DecodeHistoryDays(HistoryFormatColumns, []byte("[]"))
+	if err == nil {
+		t.Error("json decoded as columns")
+	}
+	_, err = DecodeHistoryDays(7, blob)
+	if err == nil {
+		t.Error("unknown format decoded")
+	}
+}
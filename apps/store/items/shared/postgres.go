@@ -0,0 +1,468 @@
+// The postgres histories are rows of HistoryDay, a hypertable when TimescaleDB
+// is installed. The History row of a market has the HistoryFormatDays format
+// and no blob, it tells the markets with an empty history from the unknown
+// markets. The History rows of the json and columns formats were written
+// before HistoryDay and are moved to it by Compact.
+
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+type postgresHistories struct {
+	a *app.App
+}
+
+var historyDayColumns = []string{
+	"Time",
+	"Average",
+	"Average5d",
+	"Average20d",
+	"Highest",
+	"Lowest",
+	"OrderCount",
+	"Volume",
+	"DonchianTop",
+	"DonchianBottom",
+	"Filled",
+}
+
+func (s postgresHistories) Insert(ctx context.Context, histories []DbHistory) error {
+	markets := make([]historyMarket, 0, len(histories))
+	corrupted := make([]DbHistory, 0)
+	for _, h := range histories {
+		days, err := unmarshalDays(h.History)
+		if err != nil {
+			log.Printf("Histories: can't store the history of type %d in region %d: %v", h.TypeId, h.RegionId, err)
+			corrupted = append(corrupted, h)
+			continue
+		}
+		markets = append(markets, historyMarket{typeId: h.TypeId, regionId: h.RegionId, days: days})
+	}
+	err := s.writeDays(ctx, markets, false)
+	if err != nil {
+		return err
+	}
+	return s.writeCorrupted(ctx, corrupted)
+}
+
+// Days of a json history, with dates HistoryDay can store
+func unmarshalDays(historyJson []byte) ([]DbHistoryDay, error) {
+	var days []DbHistoryDay
+	err := json.Unmarshal(historyJson, &days)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal history: %w", err)
+	}
+	for _, d := range days {
+		_, err = time.Parse(historyDateLayout, d.Date)
+		if err != nil {
+			return nil, fmt.Errorf("history date: %w", err)
+		}
+	}
+	return days, nil
+}
+
+// Keep the json of the histories that can't be written to HistoryDay in their
+// History row, marked corrupted, and drop their previous days
+func (s postgresHistories) writeCorrupted(ctx context.Context, histories []DbHistory) error {
+	if len(histories) == 0 {
+		return nil
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	tx, err := s.a.DB.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	markQuery := `
+  INSERT INTO History VALUES (?,?,?,?)
+    ON CONFLICT (TypeId, RegionId) DO UPDATE SET
+      HistoryBlob = excluded.HistoryBlob,
+      Format = excluded.Format;
+  `
+	for _, h := range histories {
+		_, err = tx.Exec(timeoutCtx, markQuery, h.TypeId, h.RegionId, h.History, HistoryFormatCorrupted)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(timeoutCtx, "DELETE FROM HistoryDay WHERE TypeId = ? AND RegionId = ?", h.TypeId, h.RegionId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Replace the days of the markets. The History rows of the markets are
+// written first, their locks keep Insert and Compact from writing the days of
+// a market concurrently. With compact, the markets whose History row changed
+// since it was read are skipped.
+func (s postgresHistories) writeDays(ctx context.Context, markets []historyMarket, compact bool) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	tx, err := s.a.DB.Begin(timeoutCtx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	markQuery := `
+  INSERT INTO History VALUES (?,?,?,?)
+    ON CONFLICT (TypeId, RegionId) DO UPDATE SET
+      HistoryBlob = excluded.HistoryBlob,
+      Format = excluded.Format;
+  `
+	if compact {
+		markQuery = `
+  UPDATE History SET HistoryBlob = ?, Format = ?
+    WHERE TypeId = ? AND RegionId = ? AND Format = ?;
+  `
+	}
+	markStmt, err := tx.PrepareWrite(timeoutCtx, markQuery)
+	if err != nil {
+		return err
+	}
+	defer markStmt.Close()
+	deleteStmt, err := tx.PrepareWrite(timeoutCtx, "DELETE FROM HistoryDay WHERE TypeId = ? AND RegionId = ?")
+	if err != nil {
+		return err
+	}
+	defer deleteStmt.Close()
+
+	written := make([]historyMarket, 0, len(markets))
+	for _, m := range markets {
+		if !compact {
+			_, err = markStmt.Exec(timeoutCtx, m.typeId, m.regionId, []byte{}, HistoryFormatDays)
+			if err != nil {
+				return err
+			}
+		} else {
+			result, err := markStmt.Exec(timeoutCtx, []byte{}, HistoryFormatDays, m.typeId, m.regionId, m.format)
+			if err != nil {
+				return err
+			}
+			marked, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if marked == 0 {
+				continue
+			}
+		}
+		_, err = deleteStmt.Exec(timeoutCtx, m.typeId, m.regionId)
+		if err != nil {
+			return err
+		}
+		written = append(written, m)
+	}
+
+	// NOTE: no other statement of the transaction can run during the copy
+	copyStmt, err := tx.PrepareCopy(timeoutCtx, "HistoryDay", append([]string{"TypeId", "RegionId"}, historyDayColumns...)...)
+	if err != nil {
+		return err
+	}
+	defer copyStmt.Close()
+	for _, m := range written {
+		for _, d := range m.days {
+			t, err := time.Parse(historyDateLayout, d.Date)
+			if err != nil {
+				return fmt.Errorf("history of type %d in region %d: history date: %w", m.typeId, m.regionId, err)
+			}
+			_, err = copyStmt.Exec(
+				timeoutCtx,
+				m.typeId,
+				m.regionId,
+				t.Unix(),
+				d.Average,
+				d.Average5d,
+				d.Average20d,
+				d.Highest,
+				d.Lowest,
+				d.OrderCount,
+				d.Volume,
+				d.DonchianTop,
+				d.DonchianBottom,
+				d.Filled,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = copyStmt.Exec(timeoutCtx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s postgresHistories) Get(ctx context.Context, typeId int, regionId int) ([]byte, error) {
+	days, format, blob, err := s.get(ctx, typeId, regionId)
+	if err != nil {
+		return nil, err
+	}
+	if format != HistoryFormatDays {
+		return DecodeHistory(format, blob)
+	}
+	if days == nil {
+		return nil, nil
+	}
+	return json.Marshal(days)
+}
+
+func (s postgresHistories) GetDays(ctx context.Context, typeId int, regionId int) ([]DbHistoryDay, error) {
+	days, format, blob, err := s.get(ctx, typeId, regionId)
+	if err != nil {
+		return nil, err
+	}
+	if format != HistoryFormatDays {
+		return DecodeHistoryDays(format, blob)
+	}
+	return days, nil
+}
+
+// Days of the market, or the blob of a History row written before
+// HistoryDay. days is nil for the unknown markets.
+func (s postgresHistories) get(ctx context.Context, typeId int, regionId int) (days []DbHistoryDay, format int, blob []byte, err error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	selectQuery := `
+  SELECT HistoryBlob, Format FROM History
+    WHERE TypeId = ? AND RegionId = ?;
+  `
+	err = db.QueryRow(timeoutCtx, selectQuery, typeId, regionId).Scan(&blob, &format)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, HistoryFormatDays, nil, nil
+	} else if err != nil {
+		return nil, 0, nil, err
+	}
+	if format != HistoryFormatDays {
+		return nil, format, blob, nil
+	}
+
+	selectQuery = `
+  SELECT RegionId, ` + strings.Join(historyDayColumns, ", ") + ` FROM HistoryDay
+    WHERE TypeId = ? AND RegionId = ?
+    ORDER BY Time;
+  `
+	daysOfRegions, err := s.queryDays(timeoutCtx, selectQuery, typeId, regionId)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	days = daysOfRegions[regionId]
+	if days == nil {
+		days = make([]DbHistoryDay, 0)
+	}
+	return days, format, nil, nil
+}
+
+func (s postgresHistories) GetOfType(ctx context.Context, typeId int) ([]DbHistory, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT RegionId, ` + strings.Join(historyDayColumns, ", ") + ` FROM HistoryDay
+    WHERE TypeId = ? AND RegionId >= ?
+    ORDER BY RegionId, Time;
+  `
+	daysOfRegions, err := s.queryDays(timeoutCtx, selectQuery, typeId, regions.FirstRegionId)
+	if err != nil {
+		return nil, err
+	}
+
+	selectQuery = `
+  SELECT HistoryBlob, Format, RegionId FROM History
+    WHERE TypeId = ? AND RegionId >= ? AND Format <> ?`
+	rows, err := db.Query(timeoutCtx, selectQuery, typeId, regions.FirstRegionId, HistoryFormatCorrupted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make([]DbHistory, 0)
+	corrupted := make([]historyMarket, 0)
+	for rows.Next() {
+		var h = DbHistory{TypeId: typeId}
+		var blob []byte
+		var format int
+		err = rows.Scan(&blob, &format, &h.RegionId)
+		if err != nil {
+			return nil, err
+		}
+		if format == HistoryFormatDays {
+			days := daysOfRegions[h.RegionId]
+			if days == nil {
+				days = make([]DbHistoryDay, 0)
+			}
+			h.History, err = json.Marshal(days)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			h.History, err = DecodeHistory(format, blob)
+			if err != nil {
+				log.Printf("Histories: can't decode the history of type %d in region %d: %v", typeId, h.RegionId, err)
+				corrupted = append(corrupted, historyMarket{typeId: typeId, regionId: h.RegionId, format: format})
+				continue
+			}
+		}
+		histories = append(histories, h)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = markCorrupted(timeoutCtx, db, corrupted)
+	if err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+// Days by region of a query selecting the region and the day columns
+func (s postgresHistories) queryDays(ctx context.Context, query string, args ...any) (map[int][]DbHistoryDay, error) {
+	rows, err := s.a.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daysOfRegions := make(map[int][]DbHistoryDay)
+	for rows.Next() {
+		var regionId int
+		var t int64
+		var d DbHistoryDay
+		err = rows.Scan(
+			&regionId,
+			&t,
+			&d.Average,
+			&d.Average5d,
+			&d.Average20d,
+			&d.Highest,
+			&d.Lowest,
+			&d.OrderCount,
+			&d.Volume,
+			&d.DonchianTop,
+			&d.DonchianBottom,
+			&d.Filled,
+		)
+		if err != nil {
+			return nil, err
+		}
+		d.Date = time.Unix(t, 0).UTC().Format(historyDateLayout)
+		daysOfRegions[regionId] = append(daysOfRegions[regionId], d)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return daysOfRegions, nil
+}
+
+// Move the History rows written before HistoryDay to HistoryDay. The rows that
+// fail to decode are marked corrupted.
+func (s postgresHistories) Compact(ctx context.Context, limit int) (int, error) {
+	db := s.a.DB
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	selectQuery := `
+  SELECT TypeId, RegionId, HistoryBlob, Format FROM History
+    WHERE Format IN (0, 1)
+    LIMIT ?;
+  `
+	rows, err := db.Query(timeoutCtx, selectQuery, limit)
+	if err != nil {
+		return 0, err
+	}
+	markets := make([]historyMarket, 0, limit)
+	corrupted := make([]historyMarket, 0)
+	for rows.Next() {
+		var m historyMarket
+		var blob []byte
+		err = rows.Scan(&m.typeId, &m.regionId, &blob, &m.format)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		m.days, err = DecodeHistoryDays(m.format, blob)
+		if err != nil {
+			log.Printf("Histories: can't compact the history of type %d in region %d: %v", m.typeId, m.regionId, err)
+			corrupted = append(corrupted, m)
+			continue
+		}
+		markets = append(markets, m)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	err = markCorrupted(timeoutCtx, db, corrupted)
+	if err != nil {
+		return 0, err
+	}
+	err = s.writeDays(timeoutCtx, markets, true)
+	if err != nil {
+		return 0, err
+	}
+
+	var left int
+	err = db.QueryRow(timeoutCtx, "SELECT COUNT(*) FROM History WHERE Format IN (0, 1)").Scan(&left)
+	if err != nil {
+		return 0, err
+	}
+
+	return left, nil
+}
+
+// The days format has no blob, its bytes are the size of the HistoryDay rows
+func (s postgresHistories) Sizes(ctx context.Context) ([]HistorySize, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	sizes, err := sqliteHistories{a: s.a}.Sizes(timeoutCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var daysBytes int64
+	err = s.a.DB.QueryRow(timeoutCtx, "SELECT COALESCE(SUM(pg_column_size(d.*)), 0) FROM HistoryDay d").Scan(&daysBytes)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sizes {
+		if sizes[i].Format == HistoryFormatDays {
+			sizes[i].Bytes = daysBytes
+		}
+	}
+
+	return sizes, nil
+}
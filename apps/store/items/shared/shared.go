@@ -1,8 +1,11 @@
-// This struct definitions are separated in a separate module so that they can
-// be access by the metrics module
+// This struct definitions and the history helpers are separated in a separate
+// module so that they can be access by the metrics and anomalies modules
 
 package shared
 
+// PLEX is traded on a single market shared by all the regions
+const PlexTypeId = 44992
+
 type DbOrder struct {
 	Duration     int
 	IsBuyOrder   bool
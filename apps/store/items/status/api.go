@@ -0,0 +1,167 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/histories"
+	"github.com/raph5/eve-market-browser/apps/store/items/orders"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/timerecord"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+// All times are epoch seconds and 0 when unknown
+type apiStatus struct {
+	Time      int64                   `json:"time"`
+	Orders    apiOrdersStatus         `json:"orders"`
+	Histories apiHistoriesStatus      `json:"histories"`
+	Workers   map[string]workerStatus `json:"workers"`
+	Esi       apiEsiStatus            `json:"esi"`
+	DbSize    int64                   `json:"dbSize"` // Bytes
+}
+
+type apiOrdersStatus struct {
+	LastRefresh int64              `json:"lastRefresh"`
+	NextRefresh int64              `json:"nextRefresh"`
+	Regions     []apiRegionRefresh `json:"regions"`
+}
+
+type apiRegionRefresh struct {
+	RegionId    int   `json:"regionId"`
+	LastRefresh int64 `json:"lastRefresh"`
+}
+
+type apiHistoriesStatus struct {
+	LastRefresh int64                  `json:"lastRefresh"`
+	NextRefresh int64                  `json:"nextRefresh"`
+	Run         *histories.RunProgress `json:"run"`
+}
+
+type apiEsiStatus struct {
+	ErrorLimitRemain int   `json:"errorLimitRemain"` // -1 if unknown
+	ErrorLimitReset  int64 `json:"errorLimitReset"`
+	TimeoutUntil     int64 `json:"timeoutUntil"`
+}
+
+// Serve /status. The errors of the workers can tell about the host, only
+// their time is served.
+func CreateHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return createHandler(ctx, a, false)
+}
+
+// Serve /status with the errors of the workers
+// WARN: only serve it on the unix socket
+func CreateAdminHandler(ctx context.Context, a *app.App) http.HandlerFunc {
+	return createHandler(ctx, a, true)
+}
+
+func createHandler(ctx context.Context, a *app.App, withErrors bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		status, err := getStatus(timeoutCtx, a, withErrors)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		err = json.NewEncoder(w).Encode(status)
+		if err != nil {
+			log.Printf("Internal server error: %v", err)
+			http.Error(w, "Internal server error", 500)
+			return
+		}
+	}
+}
+
+func getStatus(ctx context.Context, a *app.App, withErrors bool) (*apiStatus, error) {
+	status := apiStatus{
+		Time:    a.Now().Unix(),
+		Workers: getWorkerStatuses(a),
+	}
+	if !withErrors {
+		for name, w := range status.Workers {
+			w.LastError = ""
+			status.Workers[name] = w
+		}
+	}
+
+	lastRefresh, err := orders.LastRefresh(ctx, a, 0)
+	if err != nil {
+		return nil, err
+	}
+	status.Orders.LastRefresh = unix(lastRefresh)
+	expiration, err := timerecord.Get(ctx, a, "OrdersExpiration")
+	if err != nil {
+		return nil, err
+	}
+	status.Orders.NextRefresh = unix(expiration)
+	status.Orders.Regions = make([]apiRegionRefresh, 0, len(regions.Regions))
+	for _, regionId := range regions.Regions {
+		lastRefresh, err = orders.LastRefresh(ctx, a, regionId)
+		if err != nil {
+			return nil, err
+		}
+		status.Orders.Regions = append(status.Orders.Regions, apiRegionRefresh{
+			RegionId:    regionId,
+			LastRefresh: unix(lastRefresh),
+		})
+	}
+
+	lastRefresh, err = histories.LastRefresh(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	status.Histories.LastRefresh = unix(lastRefresh)
+	expiration, err = timerecord.Get(ctx, a, "HistoriesExpiration")
+	if err != nil {
+		return nil, err
+	}
+	status.Histories.NextRefresh = unix(expiration)
+	status.Histories.Run, err = histories.LastRunProgress(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	remain, reset := a.Esi.ErrorBudget()
+	status.Esi = apiEsiStatus{
+		ErrorLimitRemain: remain,
+		ErrorLimitReset:  unix(reset),
+		TimeoutUntil:     unix(a.Esi.Timeout()),
+	}
+
+	status.DbSize, err = dbGetSize(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// Endpoints of the package for the versioned api
+func Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	return []openapi.Operation{
+		{
+			Path:     "/status",
+			Summary:  "Freshness of the data and health of the workers",
+			Response: apiStatus{},
+			Handler:  CreateHandler(ctx, a),
+		},
+	}
+}
@@ -0,0 +1,16 @@
+package status
+
+import (
+	"context"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+// Size of the database in bytes
+func dbGetSize(ctx context.Context, a *app.App) (int64, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return a.DB.Size(timeoutCtx)
+}
@@ -0,0 +1,65 @@
+// The status of the store served on /status: freshness of the data, progress
+// of the history run, last errors of the workers, esi error budget and
+// database size.
+
+package status
+
+import (
+	"sync"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+const (
+	OrdersWorker    = "orders"
+	HistoriesWorker = "histories"
+)
+
+type workerStatus struct {
+	LastSuccess   int64  `json:"lastSuccess"`             // Epoch Seconds, 0 if never
+	LastError     string `json:"lastError,omitempty"`     // only on the unix socket
+	LastErrorTime int64  `json:"lastErrorTime,omitempty"` // Epoch Seconds
+}
+
+type workers struct {
+	mu       sync.Mutex
+	statuses map[string]workerStatus
+}
+
+func getWorkers(a *app.App) *workers {
+	return app.State(a, "status", func() *workers {
+		return &workers{statuses: make(map[string]workerStatus)}
+	})
+}
+
+// Record the error of a worker, it stays in the status until the next error
+func ReportError(a *app.App, worker string, err error) {
+	ws := getWorkers(a)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	w := ws.statuses[worker]
+	w.LastError = err.Error()
+	w.LastErrorTime = a.Now().Unix()
+	ws.statuses[worker] = w
+}
+
+// Record the successful iteration of a worker
+func ReportSuccess(a *app.App, worker string) {
+	ws := getWorkers(a)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	w := ws.statuses[worker]
+	w.LastSuccess = a.Now().Unix()
+	ws.statuses[worker] = w
+}
+
+func getWorkerStatuses(a *app.App) map[string]workerStatus {
+	ws := getWorkers(a)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	copy := make(map[string]workerStatus, len(ws.statuses))
+	for name, w := range ws.statuses {
+		copy[name] = w
+	}
+	return copy
+}
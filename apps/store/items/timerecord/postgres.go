@@ -0,0 +1,6 @@
+package timerecord
+
+// NOTE: the upsert of the time records runs as is on postgres
+type postgresRecords struct {
+	sqliteRecords
+}
@@ -6,15 +6,39 @@ import (
 	"errors"
 	"time"
 
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
 )
 
-func Set(ctx context.Context, key string, expTime time.Time) error {
-	db := ctx.Value("db").(*database.DB)
+// Repository of the time records
+type recordStore interface {
+	Set(ctx context.Context, key string, t time.Time) error
+	// Zero time if the key has no record
+	Get(ctx context.Context, key string) (time.Time, error)
+}
+
+func storeOf(a *app.App) recordStore {
+	return database.ForDriver[recordStore](a.DB, sqliteRecords{a: a}, postgresRecords{sqliteRecords{a: a}})
+}
+
+func Set(ctx context.Context, a *app.App, key string, expTime time.Time) error {
+	return storeOf(a).Set(ctx, key, expTime)
+}
+
+func Get(ctx context.Context, a *app.App, key string) (time.Time, error) {
+	return storeOf(a).Get(ctx, key)
+}
+
+type sqliteRecords struct {
+	a *app.App
+}
+
+func (s sqliteRecords) Set(ctx context.Context, key string, expTime time.Time) error {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
-	insertQuery := "INSERT OR REPLACE INTO TimeRecord VALUES (?,?)"
+	insertQuery := `INSERT INTO TimeRecord VALUES (?,?) ON CONFLICT ("Key") DO UPDATE SET Time = excluded.Time`
 	_, err := db.Exec(timeoutCtx, insertQuery, key, expTime.Unix())
 	if err != nil {
 		return err
@@ -23,8 +47,8 @@ func Set(ctx context.Context, key string, expTime time.Time) error {
 	return nil
 }
 
-func Get(ctx context.Context, key string) (time.Time, error) {
-	db := ctx.Value("db").(*database.DB)
+func (s sqliteRecords) Get(ctx context.Context, key string) (time.Time, error) {
+	db := s.a.DB
 	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
 
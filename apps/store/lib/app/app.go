@@ -0,0 +1,76 @@
+// The app holds the dependencies and the state of the store. It is passed
+// explicitly to the packages so that a test can build an isolated store with a
+// temporary database and a fake esi, and so that two stores can run in the
+// same process.
+
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
+)
+
+type App struct {
+	DB  *database.DB
+	Esi *esi.Client
+	// Settings read at startup, the settings reloaded on SIGHUP are read with
+	// Current
+	Config config.Config
+	Clock  func() time.Time
+
+	mu      sync.Mutex
+	current config.Config
+	states  map[string]any
+}
+
+func New(db *database.DB, esiClient *esi.Client, cfg config.Config) *App {
+	return &App{
+		DB:      db,
+		Esi:     esiClient,
+		Config:  cfg,
+		Clock:   time.Now,
+		current: cfg,
+	}
+}
+
+func (a *App) Now() time.Time {
+	return a.Clock()
+}
+
+// The running config
+func (a *App) Current() config.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current
+}
+
+// Take the settings that are safe to change while the store runs from
+// reloaded, see config.Reload
+func (a *App) Reload(reloaded config.Config) config.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.current = a.current.Reload(reloaded)
+	return a.current
+}
+
+// State of the package pkg, like its caches or its subscribers. It is created
+// with init on the first call.
+// NOTE: the state is kept on the app rather than in package variables so that
+// two stores don't share it
+func State[T any](a *App, pkg string, init func() *T) *T {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s, ok := a.states[pkg]; ok {
+		return s.(*T)
+	}
+	if a.states == nil {
+		a.states = make(map[string]any)
+	}
+	s := init()
+	a.states[pkg] = s
+	return s
+}
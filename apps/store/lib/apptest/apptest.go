@@ -0,0 +1,80 @@
+// Stores for the tests of the packages, each with its own temporary database
+
+package apptest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
+	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
+)
+
+const testTimeout = time.Minute
+
+type Options struct {
+	Config *config.Config // config.Default() if nil
+	DbPath string         // a file of a temporary directory if empty
+}
+
+// Store with the default config and a sqlite database in a temporary
+// directory. The context is canceled at the end of the test.
+func New(t testing.TB) (*app.App, context.Context) {
+	return NewWithOptions(t, Options{})
+}
+
+func NewWithOptions(t testing.TB, opts Options) (*app.App, context.Context) {
+	cfg := config.Default()
+	if opts.Config != nil {
+		cfg = *opts.Config
+	}
+	dbPath := opts.DbPath
+	if dbPath == "" {
+		dbPath = filepath.Join(t.TempDir(), "db.sqlite")
+	}
+
+	db, err := database.Init(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return app.New(db, esi.NewClient(esi.Options{}), cfg), ctx
+}
+
+// Stores of each database the repositories are tested against, by driver.
+// Postgres is tested when STORE_TEST_POSTGRES is set, like
+// postgres://store@localhost/store_test?sslmode=disable
+// WARN: the tables of the postgres database are cleared
+func Databases(t testing.TB, tables ...string) (map[string]*app.App, context.Context) {
+	sqlite, ctx := New(t)
+	stores := map[string]*app.App{"sqlite": sqlite}
+
+	dsn := os.Getenv("STORE_TEST_POSTGRES")
+	if dsn == "" {
+		return stores, ctx
+	}
+	postgres, err := database.OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(postgres.Close)
+	_, err = postgres.Migrate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		_, err = postgres.Exec(ctx, "DELETE FROM "+table)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stores["postgres"] = app.New(postgres, esi.NewClient(esi.Options{}), config.Default())
+	return stores, ctx
+}
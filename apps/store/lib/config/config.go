@@ -0,0 +1,266 @@
+// The store is configured by a toml file, see store.example.toml, and by the
+// command line flags that override it.
+//
+// The settings of the Schedule, the downloaded regions and the log level are
+// safe to change while the store runs, they are reloaded from the file on
+// SIGHUP and read with Current. The other settings are only read at startup.
+
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+type Config struct {
+	// info or error, with error the progress messages of the workers are not
+	// logged
+	LogLevel string `toml:"log_level"`
+	// sqlite or postgres
+	DbDriver string `toml:"db_driver"`
+	// Path of the sqlite database or postgres connection string
+	Db           string `toml:"db"`
+	MarketGroups string `toml:"market_groups"`
+
+	Servers   Servers                 `toml:"servers"`
+	Workers   Workers                 `toml:"workers"`
+	Schedule  Schedule                `toml:"schedule"`
+	Regions   Regions                 `toml:"regions"`
+	Esi       Esi                     `toml:"esi"`
+	Histories Histories               `toml:"histories"`
+	Metrics   Metrics                 `toml:"metrics"`
+	Api       Api                     `toml:"api"`
+	Backups   Backups                 `toml:"backups"`
+	Secrets   map[string]SecretSource `toml:"secrets"`
+}
+
+type Servers struct {
+	Socket         bool   `toml:"socket"`
+	SocketPath     string `toml:"socket_path"`
+	Tcp            bool   `toml:"tcp"`
+	TcpPort        int    `toml:"tcp_port"`
+	Grpc           bool   `toml:"grpc"`
+	GrpcSocketPath string `toml:"grpc_socket_path"`
+	Victoria       bool   `toml:"victoria"`
+}
+
+type Workers struct {
+	Orders     bool `toml:"orders"`
+	Histories  bool `toml:"histories"`
+	Metrics    bool `toml:"metrics"`
+	Structures bool `toml:"structures"`
+	Backups    bool `toml:"backups"`
+}
+
+type Schedule struct {
+	OrdersInterval   time.Duration `toml:"orders_interval"`
+	OrdersBackoff    time.Duration `toml:"orders_backoff"`
+	HistoriesTime    string        `toml:"histories_time"` // HH:MM local time
+	HistoriesBackoff time.Duration `toml:"histories_backoff"`
+	BackupsInterval  time.Duration `toml:"backups_interval"`
+}
+
+type Regions struct {
+	// Regions whose orders are downloaded, all the regions if empty
+	Downloaded []int `toml:"downloaded"`
+	// Json file of the region groups, the default groups if empty
+	Groups string `toml:"groups"`
+}
+
+type Esi struct {
+	UserAgent             string        `toml:"user_agent"`
+	MaxConcurrentRequests int           `toml:"max_concurrent_requests"`
+	RequestTimeout        time.Duration `toml:"request_timeout"`
+}
+
+type Histories struct {
+	SkipFilled bool `toml:"skip_filled"`
+	// Days
+	MarketWeeklyAfter int `toml:"market_weekly_after"`
+	MarketRetireAfter int `toml:"market_retire_after"`
+	MarketMaxNotFound int `toml:"market_max_not_found"`
+	// Consecutive 404 history responses after which a market is retired
+	MarketRetireNotFound int `toml:"market_retire_not_found"`
+}
+
+type Metrics struct {
+	Estimators []string `toml:"estimators"`
+	Hubs       []int    `toml:"hubs"`   // the default hubs if empty
+	Basket     []int    `toml:"basket"` // the default basket if empty
+	// Days
+	IntradayRetention int `toml:"intraday_retention"`
+}
+
+type Api struct {
+	KeyRequired bool    `toml:"key_required"`
+	IpRate      float64 `toml:"ip_rate"`
+	IpBurst     int     `toml:"ip_burst"`
+	KeyRate     float64 `toml:"key_rate"`
+	KeyBurst    int     `toml:"key_burst"`
+	// Addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers give the ip of the clients
+	TrustedProxies []string `toml:"trusted_proxies"`
+}
+
+type Backups struct {
+	Dir  string `toml:"dir"`
+	Keep int    `toml:"keep"` // all the snapshots are kept if 0
+}
+
+// A secret is read from an environment variable or from a file
+type SecretSource struct {
+	Env  string `toml:"env"`
+	File string `toml:"file"`
+}
+
+func Default() Config {
+	return Config{
+		LogLevel: "info",
+		DbDriver: "sqlite",
+		Db:       "./data.db",
+		Servers: Servers{
+			Socket:         true,
+			SocketPath:     "/tmp/emb.sock",
+			TcpPort:        7562,
+			GrpcSocketPath: "/tmp/emb-grpc.sock",
+			Victoria:       true,
+		},
+		Workers: Workers{
+			Orders:     true,
+			Histories:  true,
+			Structures: true,
+		},
+		Schedule: Schedule{
+			OrdersInterval:   10 * time.Minute,
+			OrdersBackoff:    2 * time.Minute,
+			HistoriesTime:    "11:15",
+			HistoriesBackoff: 5 * time.Minute,
+			BackupsInterval:  24 * time.Hour,
+		},
+		Esi: Esi{
+			UserAgent:             "evemarketbrowser.com - contact me at raphguyader@gmail.com",
+			MaxConcurrentRequests: 10,
+			RequestTimeout:        7 * time.Second,
+		},
+		Histories: Histories{
+			MarketWeeklyAfter:    30,
+			MarketRetireAfter:    180,
+			MarketMaxNotFound:    7,
+			MarketRetireNotFound: 14,
+		},
+		Metrics: Metrics{
+			Estimators:        []string{"top5pct", "median5", "minisk"},
+			IntradayRetention: 30,
+		},
+		Api: Api{
+			IpRate:   10,
+			IpBurst:  50,
+			KeyRate:  50,
+			KeyBurst: 200,
+		},
+		Backups: Backups{
+			Dir:  "./backups",
+			Keep: 7,
+		},
+	}
+}
+
+// Decode the toml file at path over cfg, the settings missing from the file
+// keep their value
+func Load(path string, cfg *Config) error {
+	md, err := toml.DecodeFile(path, cfg)
+	if err != nil {
+		return fmt.Errorf("decode config file: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return fmt.Errorf("unknown config keys: %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.LogLevel != "info" && cfg.LogLevel != "error" {
+		return fmt.Errorf("log_level must be info or error")
+	}
+	if cfg.DbDriver != "sqlite" && cfg.DbDriver != "postgres" {
+		return fmt.Errorf("db_driver must be sqlite or postgres")
+	}
+	if cfg.DbDriver != "sqlite" && cfg.Workers.Backups {
+		return fmt.Errorf("workers.backups requires the sqlite db_driver")
+	}
+	if cfg.Schedule.OrdersInterval <= 0 || cfg.Schedule.OrdersBackoff <= 0 || cfg.Schedule.HistoriesBackoff <= 0 || cfg.Schedule.BackupsInterval <= 0 {
+		return fmt.Errorf("schedule durations must be positive")
+	}
+	_, err := time.Parse("15:04", cfg.Schedule.HistoriesTime)
+	if err != nil {
+		return fmt.Errorf("schedule.histories_time must be of format HH:MM")
+	}
+	if cfg.Esi.MaxConcurrentRequests <= 0 || cfg.Esi.RequestTimeout <= 0 {
+		return fmt.Errorf("esi max_concurrent_requests and request_timeout must be positive")
+	}
+	for _, proxy := range cfg.Api.TrustedProxies {
+		_, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			_, err = netip.ParseAddr(proxy)
+		}
+		if err != nil {
+			return fmt.Errorf("api.trusted_proxies: %s is not an address nor a CIDR range", proxy)
+		}
+	}
+	if cfg.Backups.Keep < 0 {
+		return fmt.Errorf("backups.keep must not be negative")
+	}
+	for name, s := range cfg.Secrets {
+		if (s.Env == "") == (s.File == "") {
+			return fmt.Errorf("secret %s must have either env or file", name)
+		}
+	}
+	return nil
+}
+
+// Hour and minute of schedule.histories_time
+// NOTE: the config must be valid
+func (s *Schedule) HistoriesClock() (hour int, minute int) {
+	t, _ := time.Parse("15:04", s.HistoriesTime)
+	return t.Hour(), t.Minute()
+}
+
+// Read the secrets from their sources
+func (cfg *Config) ReadSecrets() (map[string]string, error) {
+	secrets := make(map[string]string, len(cfg.Secrets))
+	for name, s := range cfg.Secrets {
+		if s.Env != "" {
+			value, ok := os.LookupEnv(s.Env)
+			if !ok {
+				return nil, fmt.Errorf("secret %s: environment variable %s not set", name, s.Env)
+			}
+			secrets[name] = value
+		} else {
+			value, err := os.ReadFile(s.File)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %w", name, err)
+			}
+			secrets[name] = strings.TrimSpace(string(value))
+		}
+	}
+	return secrets, nil
+}
+
+// The config with the settings that are safe to change while the store runs
+// taken from reloaded, the other settings of reloaded are ignored
+func (cfg Config) Reload(reloaded Config) Config {
+	cfg.LogLevel = reloaded.LogLevel
+	cfg.Schedule = reloaded.Schedule
+	cfg.Regions.Downloaded = slices.Clone(reloaded.Regions.Downloaded)
+	return cfg
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExample(t *testing.T) {
+	cfg := Config{}
+	err := Load("../../store.example.toml", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the example documents the defaults
+	defaults := Default()
+	defaults.Regions.Downloaded = []int{}
+	defaults.Metrics.Hubs = []int{}
+	defaults.Metrics.Basket = []int{}
+	defaults.Api.TrustedProxies = []string{}
+	defaults.Secrets = map[string]SecretSource{}
+	if !reflect.DeepEqual(cfg, defaults) {
+		t.Errorf("the example is not the default config:\n%+v\n%+v", cfg, defaults)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.toml")
+	secretPath := filepath.Join(dir, "secret")
+	os.WriteFile(secretPath, []byte("file-secret\n"), 0600)
+	t.Setenv("TEST_SECRET", "env-secret")
+	os.WriteFile(path, []byte(`
+log_level = "error"
+[schedule]
+orders_interval = "5m"
+[secrets]
+a = { env = "TEST_SECRET" }
+b = { file = "`+secretPath+`" }
+`), 0600)
+
+	cfg := Default()
+	err := Load(path, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogLevel != "error" || cfg.Schedule.OrdersInterval != 5*time.Minute || cfg.Schedule.OrdersBackoff != 2*time.Minute {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	secrets, err := cfg.ReadSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets["a"] != "env-secret" || secrets["b"] != "file-secret" {
+		t.Errorf("unexpected secrets %v", secrets)
+	}
+
+	os.WriteFile(path, []byte("[schedule]\norder_interval = \"5m\"\n"), 0600)
+	err = Load(path, &cfg)
+	if err == nil {
+		t.Error("expected an error for the unknown key")
+	}
+}
+
+func TestReload(t *testing.T) {
+	reloaded := Default()
+	reloaded.LogLevel = "error"
+	reloaded.Db = "other.db"
+	reloaded.Schedule.HistoriesTime = "12:30"
+	cfg := Default().Reload(reloaded)
+	if cfg.LogLevel != "error" || cfg.Db != Default().Db {
+		t.Errorf("expected only the safe settings to be reloaded, got %+v", cfg)
+	}
+	hour, minute := cfg.Schedule.HistoriesClock()
+	if hour != 12 || minute != 30 {
+		t.Errorf("got %d:%d, want 12:30", hour, minute)
+	}
+}
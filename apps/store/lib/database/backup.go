@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The postgres databases are backed up with pg_dump
+var ErrSqliteOnly = errors.New("only available with the sqlite database, use pg_dump with postgres")
+
+// Write a consistent copy of the database to path with VACUUM INTO. The copy
+// is made through a read connection, the writes go on during the copy.
+// NOTE: the wal grows during the copy as it can't be checkpointed past the
+// read transaction
+// WARN: path must not exist
+func (db *DB) VacuumInto(ctx context.Context, path string) error {
+	if db.driver != Sqlite {
+		return ErrSqliteOnly
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		panic("DB: your are required to wrap your database call in context with a deadline")
+	}
+
+	start := time.Now()
+	query := "VACUUM INTO ?"
+	_, err := db.read.ExecContext(ctx, query, path)
+	reportRequest(err, query, time.Since(start))
+	return err
+}
+
+// Run PRAGMA integrity_check on the database file at path
+func CheckIntegrity(ctx context.Context, path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	var result string
+	err = conn.QueryRowContext(timeoutCtx, "PRAGMA integrity_check").Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check of %s: %s", path, result)
+	}
+	return nil
+}
+
+var ErrInUse = errors.New("the database is used by another process, stop the store first")
+
+// Check that no process has the database file at path open by taking an
+// exclusive lock on it. It succeeds if the file does not exist.
+func CheckUnused(ctx context.Context, path string) error {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_locking_mode=EXCLUSIVE&_busy_timeout=0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err = conn.ExecContext(timeoutCtx, "BEGIN EXCLUSIVE; ROLLBACK;")
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		return ErrInUse
+	}
+	return err
+}
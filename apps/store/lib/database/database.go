@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -17,9 +18,19 @@ import (
 	"github.com/raph5/eve-market-browser/apps/store/lib/victoria"
 )
 
+type Driver string
+
+const (
+	Sqlite   Driver = "sqlite"
+	Postgres Driver = "postgres"
+)
+
+// The queries are written for sqlite, with ? placeholders. They are rewritten
+// for postgres, see postgres.go.
 type DB struct {
-	write *sql.DB
-	read  *sql.DB
+	driver Driver
+	write  *sql.DB
+	read   *sql.DB
 }
 
 type dbRow struct {
@@ -29,100 +40,49 @@ type dbRow struct {
 }
 
 type dbTx struct {
-	start time.Time
-	tx    *sql.Tx
+	driver Driver
+	start  time.Time
+	tx     *sql.Tx
 }
 
+// Transaction of Begin, for the functions shared by the implementations of a
+// repository
+type Tx = dbTx
+
 type dbStmt struct {
-	read  bool
-	query string
-	stmt  *sql.Stmt
+	driver Driver
+	read   bool
+	query  string
+	stmt   *sql.Stmt
 }
 
+// Open the sqlite database and apply the pending migrations
 func Init(dbPath string) (*DB, error) {
-	dbWrite, err := sql.Open("sqlite3", dbPath+"?_txlock=immediate")
+	db, err := Open(dbPath)
 	if err != nil {
 		return nil, err
 	}
-	dbWrite.SetMaxOpenConns(1)
+	_, err = db.Migrate(context.Background(), false)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating: %w", err)
+	}
+	return db, nil
+}
 
-	dbRead, err := sql.Open("sqlite3", dbPath)
+// Open the sqlite database without touching its schema
+func Open(dbPath string) (*DB, error) {
+	dbWrite, err := sql.Open("sqlite3", dbPath+"?_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
-	dbRead.SetMaxOpenConns(4)
+	dbWrite.SetMaxOpenConns(1)
 
-	createTablesAndIndexs := `
-  CREATE TABLE IF NOT EXISTS "Order" (
-    Id INTEGER PRIMARY KEY,
-    RegionId INTEGER,
-    Duration INTEGER,
-    IsBuyOrder INTEGER,
-    Issued TEXT,
-    LocationId INTEGER,
-    MinVolume INTEGER,
-    Price REAL,
-    Range TEXT,
-    SystemId INTEGER,
-    TypeId INTEGER,
-    VolumeRemain INTEGER,
-    VolumeTotal INTEGER
-  );
-  CREATE INDEX IF NOT EXISTS OrderTypeIndex ON "Order" (TypeId);
-  CREATE INDEX IF NOT EXISTS OrderTypeRegionIndex ON "Order" (TypeId, RegionId);
-
-  CREATE TABLE IF NOT EXISTS Location (
-    Id INTEGER PRIMARY KEY,
-    SystemId INTEGER,  -- For now I dont need to mark it as a foriegn key
-    Name TEXT,
-    Security REAL
-  );
-  CREATE INDEX IF NOT EXISTS LocationIndex ON Location (Id);
-
-  CREATE TABLE IF NOT EXISTS History (
-    TypeId INTEGER,
-    RegionId INTEGER,
-    HistoryJson TEXT,
-    PRIMARY KEY (TypeId, RegionId)
-  );
-  CREATE INDEX IF NOT EXISTS HistoryTypeIndex ON History (TypeId);
-  CREATE INDEX IF NOT EXISTS HistoryTypeRegionIndex ON History (TypeId, RegionId);
-
-  CREATE TABLE IF NOT EXISTS ActiveMarket (
-    TypeId INTEGER,
-    RegionId INTEGER,
-    PRIMARY KEY (TypeId, RegionId)
-  );
-
-  CREATE TABLE IF NOT EXISTS HotTypeMetric (
-    TypeId INTEGER,
-    RegionId INTEGER,
-    Time INTEGER,  -- Epoch Seconds
-    BuyPrice REAL,
-    SellPrice REAL
-    -- No primary key here as data integrity is not a big concern
-  );
-  -- Removed for fast inserts
-  -- CREATE INDEX IF NOT EXISTS HotTypeMetricTypeIndex ON HotTypeMetric (TypeId, RegionId, Time DESC);
-  CREATE TABLE IF NOT EXISTS DayTypeMetric (
-    TypeId INTEGER,
-    RegionId INTEGER,
-    Date TEXT,  -- YYYY-MM-DD (https://sqlite.org/lang_datefunc.html#time_values)
-    BuyPrice REAL,
-    SellPrice REAL,
-    Volume INTERGER
-    -- PRIMARY KEY (Date, TypeId) removed by fear of a slow down
-  );
-  CREATE INDEX IF NOT EXISTS DayTypeMetricTypeIndex ON DayTypeMetric (TypeId, RegionId, Date DESC);
-
-  CREATE TABLE IF NOT EXISTS TimeRecord (
-    "Key" TEXT PRIMARY KEY,
-    Time INTEGER  -- Epoch Seconds
-  );`
-	_, err = dbWrite.Exec(createTablesAndIndexs)
+	dbRead, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, err
 	}
+	dbRead.SetMaxOpenConns(4)
 
 	pargmaConfig := `
   PRAGMA journal_mode = WAL;
@@ -139,21 +99,55 @@ func Init(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{write: dbWrite, read: dbRead}, nil
+	return &DB{driver: Sqlite, write: dbWrite, read: dbRead}, nil
 }
 
 func (db *DB) Close() {
-	db.read.Close()
+	if db.read != db.write {
+		db.read.Close()
+	}
 	db.write.Close()
 }
 
+func (db *DB) Driver() Driver {
+	return db.driver
+}
+
+// Implementation of a repository for the driver of db. The repositories keep
+// their postgres specific queries in their postgres implementation.
+func ForDriver[T any](db *DB, sqlite T, postgres T) T {
+	if db.driver == Postgres {
+		return postgres
+	}
+	return sqlite
+}
+
+// Size of the database in bytes
+func (db *DB) Size(ctx context.Context) (int64, error) {
+	if db.driver == Postgres {
+		var size int64
+		err := db.QueryRow(ctx, "SELECT pg_database_size(current_database())").Scan(&size)
+		if err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+
+	var pageCount, pageSize int64
+	err := db.QueryRow(ctx, "SELECT page_count, page_size FROM pragma_page_count(), pragma_page_size()").Scan(&pageCount, &pageSize)
+	if err != nil {
+		return 0, err
+	}
+	return pageCount * pageSize, nil
+}
+
 func (db *DB) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
 	if _, ok := ctx.Deadline(); !ok {
 		panic("DB: your are required to wrap your database call in context with a deadline")
 	}
 
 	start := time.Now()
-	rows, err := db.read.QueryContext(ctx, query, args...)
+	rows, err := db.read.QueryContext(ctx, rebind(db.driver, query), convertArgs(db.driver, args)...)
 	reportRequest(err, query, time.Since(start))
 	return rows, err
 }
@@ -164,7 +158,7 @@ func (db *DB) QueryRow(ctx context.Context, query string, args ...any) *dbRow {
 	}
 
 	start := time.Now()
-	row := db.read.QueryRowContext(ctx, query, args...)
+	row := db.read.QueryRowContext(ctx, rebind(db.driver, query), convertArgs(db.driver, args)...)
 	return &dbRow{row: row, query: query, duration: time.Since(start)}
 }
 
@@ -180,25 +174,37 @@ func (db *DB) Exec(ctx context.Context, query string, args ...any) (sql.Result,
 	}
 
 	start := time.Now()
-	result, err := db.write.ExecContext(ctx, query, args...)
+	result, err := db.write.ExecContext(ctx, rebind(db.driver, query), convertArgs(db.driver, args)...)
 	reportRequest(err, query, time.Since(start))
 	return result, err
 }
 
+// Run a write query with a RETURNING clause
+// NOTE: LastInsertId is not supported by postgres
+func (db *DB) ExecReturning(ctx context.Context, query string, args ...any) *dbRow {
+	if _, ok := ctx.Deadline(); !ok {
+		panic("DB: your are required to wrap your database call in context with a deadline")
+	}
+
+	start := time.Now()
+	row := db.write.QueryRowContext(ctx, rebind(db.driver, query), convertArgs(db.driver, args)...)
+	return &dbRow{row: row, query: query, duration: time.Since(start)}
+}
+
 func (db *DB) PrepareRead(ctx context.Context, query string) (*dbStmt, error) {
-	stmt, err := db.read.PrepareContext(ctx, query)
+	stmt, err := db.read.PrepareContext(ctx, rebind(db.driver, query))
 	if err != nil {
 		return nil, err
 	}
-	return &dbStmt{stmt: stmt, query: query, read: true}, nil
+	return &dbStmt{driver: db.driver, stmt: stmt, query: query, read: true}, nil
 }
 
 func (db *DB) PrepareWrite(ctx context.Context, query string) (*dbStmt, error) {
-	stmt, err := db.write.PrepareContext(ctx, query)
+	stmt, err := db.write.PrepareContext(ctx, rebind(db.driver, query))
 	if err != nil {
 		return nil, err
 	}
-	return &dbStmt{stmt: stmt, query: query, read: false}, nil
+	return &dbStmt{driver: db.driver, stmt: stmt, query: query, read: false}, nil
 }
 
 func (dbStmt *dbStmt) Close() error {
@@ -214,7 +220,7 @@ func (dbStmt *dbStmt) Query(ctx context.Context, args ...any) (*sql.Rows, error)
 	}
 
 	start := time.Now()
-	rows, err := dbStmt.stmt.QueryContext(ctx, args...)
+	rows, err := dbStmt.stmt.QueryContext(ctx, convertArgs(dbStmt.driver, args)...)
 	reportRequest(err, dbStmt.query, time.Since(start))
 	return rows, err
 }
@@ -228,7 +234,7 @@ func (dbStmt *dbStmt) QueryRow(ctx context.Context, args ...any) *dbRow {
 	}
 
 	start := time.Now()
-	row := dbStmt.stmt.QueryRowContext(ctx, args...)
+	row := dbStmt.stmt.QueryRowContext(ctx, convertArgs(dbStmt.driver, args)...)
 	return &dbRow{row: row, duration: time.Since(start), query: dbStmt.query}
 }
 
@@ -241,7 +247,7 @@ func (dbStmt *dbStmt) Exec(ctx context.Context, args ...any) (sql.Result, error)
 	}
 
 	start := time.Now()
-	result, err := dbStmt.stmt.ExecContext(ctx, args...)
+	result, err := dbStmt.stmt.ExecContext(ctx, convertArgs(dbStmt.driver, args)...)
 	reportRequest(err, dbStmt.query, time.Since(start))
 	return result, err
 }
@@ -253,7 +259,7 @@ func (db *DB) Begin(ctx context.Context) (*dbTx, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &dbTx{tx: tx, start: start}, nil
+	return &dbTx{driver: db.driver, tx: tx, start: start}, nil
 }
 
 func (dbTx *dbTx) Rollback() error {
@@ -274,25 +280,34 @@ func (dbTx *dbTx) Exec(ctx context.Context, query string, args ...any) (sql.Resu
 	}
 
 	start := time.Now()
-	result, err := dbTx.tx.ExecContext(ctx, query, args...)
+	result, err := dbTx.tx.ExecContext(ctx, rebind(dbTx.driver, query), convertArgs(dbTx.driver, args)...)
 	reportRequest(err, query, time.Since(start))
 	return result, err
 }
 
 func (dbTx *dbTx) PrepareRead(ctx context.Context, query string) (*dbStmt, error) {
-	stmt, err := dbTx.tx.PrepareContext(ctx, query)
+	stmt, err := dbTx.tx.PrepareContext(ctx, rebind(dbTx.driver, query))
 	if err != nil {
 		return nil, err
 	}
-	return &dbStmt{stmt: stmt, query: query, read: true}, nil
+	return &dbStmt{driver: dbTx.driver, stmt: stmt, query: query, read: true}, nil
 }
 
 func (dbTx *dbTx) PrepareWrite(ctx context.Context, query string) (*dbStmt, error) {
-	stmt, err := dbTx.tx.PrepareContext(ctx, query)
+	stmt, err := dbTx.tx.PrepareContext(ctx, rebind(dbTx.driver, query))
 	if err != nil {
 		return nil, err
 	}
-	return &dbStmt{stmt: stmt, query: query, read: false}, nil
+	return &dbStmt{driver: dbTx.driver, stmt: stmt, query: query, read: false}, nil
+}
+
+// Placeholders of an IN list of n values like (?,?,?). The empty list, that
+// postgres rejects, is replaced by an empty select that matches nothing.
+func InList(n int) string {
+	if n == 0 {
+		return "(SELECT 0 WHERE 1 = 0)"
+	}
+	return "(" + strings.Repeat("?,", n-1) + "?)"
 }
 
 func reportRequest(err error, query string, duration time.Duration) {
@@ -0,0 +1,821 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A schema change. Migrations are applied in order of version, each in its own
+// transaction, and recorded in the SchemaVersion table.
+// WARN: never edit a migration that was released, add a new one
+type Migration struct {
+	Version  int
+	Name     string
+	Sql      string // Sql for sqlite, nothing to do if empty
+	Postgres string // Sql for postgres, nothing to do if empty
+}
+
+type MigrationState struct {
+	Migration
+	Applied time.Time // zero if pending
+}
+
+// NOTE: migrations rebuilding big tables can take a while
+const migrationTimeout = time.Hour
+
+const createSchemaVersion = `
+  CREATE TABLE IF NOT EXISTS SchemaVersion (
+    Version INTEGER PRIMARY KEY,
+    Name TEXT,
+    Applied BIGINT  -- Epoch Seconds
+  );`
+
+var migrations = []Migration{
+	{
+		// NOTE: the tables are created if not exists because the databases
+		// created before the migrations already have them. The same goes for the
+		// tables of the migrations up to 13.
+		Version: 1,
+		Name:    "initial schema",
+		Sql: `
+  CREATE TABLE IF NOT EXISTS "Order" (
+    Id INTEGER PRIMARY KEY,
+    RegionId INTEGER,
+    Duration INTEGER,
+    IsBuyOrder INTEGER,
+    Issued TEXT,
+    LocationId INTEGER,
+    MinVolume INTEGER,
+    Price REAL,
+    Range TEXT,
+    SystemId INTEGER,
+    TypeId INTEGER,
+    VolumeRemain INTEGER,
+    VolumeTotal INTEGER
+  );
+  CREATE INDEX IF NOT EXISTS OrderTypeIndex ON "Order" (TypeId);
+  CREATE INDEX IF NOT EXISTS OrderTypeRegionIndex ON "Order" (TypeId, RegionId);
+
+  CREATE TABLE IF NOT EXISTS Location (
+    Id INTEGER PRIMARY KEY,
+    SystemId INTEGER,  -- For now I dont need to mark it as a foriegn key
+    Name TEXT,
+    Security REAL
+  );
+  CREATE INDEX IF NOT EXISTS LocationIndex ON Location (Id);
+
+  CREATE TABLE IF NOT EXISTS History (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    HistoryJson TEXT,
+    PRIMARY KEY (TypeId, RegionId)
+  );
+  CREATE INDEX IF NOT EXISTS HistoryTypeIndex ON History (TypeId);
+  CREATE INDEX IF NOT EXISTS HistoryTypeRegionIndex ON History (TypeId, RegionId);
+
+  CREATE TABLE IF NOT EXISTS ActiveMarket (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    PRIMARY KEY (TypeId, RegionId)
+  );
+
+  CREATE TABLE IF NOT EXISTS HotTypeMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Time INTEGER,  -- Epoch Seconds
+    BuyPrice REAL,
+    SellPrice REAL
+    -- No primary key here as data integrity is not a big concern
+  );
+  -- Removed for fast inserts
+  -- CREATE INDEX IF NOT EXISTS HotTypeMetricTypeIndex ON HotTypeMetric (TypeId, RegionId, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayTypeMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD (https://sqlite.org/lang_datefunc.html#time_values)
+    BuyPrice REAL,
+    SellPrice REAL,
+    Volume INTERGER
+    -- PRIMARY KEY (Date, TypeId) removed by fear of a slow down
+  );
+  CREATE INDEX IF NOT EXISTS DayTypeMetricTypeIndex ON DayTypeMetric (TypeId, RegionId, Date DESC);
+
+  CREATE TABLE IF NOT EXISTS TimeRecord (
+    "Key" TEXT PRIMARY KEY,
+    Time INTEGER  -- Epoch Seconds
+  );`,
+	},
+	{
+		Version: 2,
+		Name:    "history runs",
+		Sql: `
+  CREATE TABLE IF NOT EXISTS HistoryRun (
+    Date TEXT PRIMARY KEY,  -- YYYY-MM-DD
+    Started INTEGER,  -- Epoch Seconds
+    ChunkCount INTEGER,
+    CompletedChunks INTEGER,
+    Done INTEGER
+  );
+  -- Markets of the current history run in download order
+  CREATE TABLE IF NOT EXISTS HistoryRunMarket (
+    Position INTEGER PRIMARY KEY,
+    TypeId INTEGER,
+    RegionId INTEGER
+  );
+  CREATE TABLE IF NOT EXISTS HistoryRunFailure (
+    Date TEXT,  -- YYYY-MM-DD
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Error TEXT,
+    PRIMARY KEY (Date, TypeId, RegionId)
+  );`,
+	},
+	{
+		Version: 3,
+		Name:    "active market states",
+		Sql: `
+  -- Lifecycle of the active markets, see the activemarkets package
+  CREATE TABLE IF NOT EXISTS ActiveMarketState (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    LastSeen INTEGER,  -- Epoch Seconds, last time the market had orders
+    LastHistory INTEGER,  -- Epoch Seconds, last day with volume in the history
+    NotFoundCount INTEGER,  -- Consecutive 404 or 400 history responses
+    Status TEXT,  -- active, weekly or retired
+    Override TEXT,  -- Status forced by an admin, NULL if none
+    PRIMARY KEY (TypeId, RegionId)
+  );`,
+	},
+	{
+		Version: 4,
+		Name:    "active market values",
+		Sql: `
+  CREATE TABLE IF NOT EXISTS ActiveMarketValue (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    TradedValue REAL,  -- Isk traded on the last day of the history
+    PRIMARY KEY (TypeId, RegionId)
+  );`,
+	},
+	{
+		Version: 5,
+		Name:    "DayTypeMetric date index",
+		Sql: `
+  CREATE INDEX IF NOT EXISTS DayTypeMetricDateIndex ON DayTypeMetric (Date, RegionId);`,
+	},
+	{
+		// NOTE: the databases created by the first /intraday endpoint have an
+		// index on HotTypeMetric
+		Version: 6,
+		Name:    "hourly type metrics",
+		Sql: `
+  -- NOTE: no index on HotTypeMetric for fast inserts, the /intraday endpoint
+  -- reads HourTypeMetric
+  DROP INDEX IF EXISTS HotTypeMetricTypeIndex;
+  CREATE TABLE IF NOT EXISTS HourTypeMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Time INTEGER,  -- Epoch Seconds of the start of the hour
+    BuyPrice REAL,  -- Average of the hot data points of the hour
+    SellPrice REAL,
+    PRIMARY KEY (TypeId, RegionId, Time)
+  );`,
+	},
+	{
+		Version: 7,
+		Name:    "price estimates",
+		Sql: `
+  -- Prices of the non best estimators of the metrics package, stored apart
+  -- from HotTypeMetric and DayTypeMetric to leave them untouched
+  CREATE TABLE IF NOT EXISTS HotTypeEstimate (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Time INTEGER,  -- Epoch Seconds
+    Estimator TEXT,
+    BuyPrice REAL,
+    SellPrice REAL
+  );
+  CREATE INDEX IF NOT EXISTS HotTypeEstimateTypeIndex ON HotTypeEstimate (TypeId, RegionId, Estimator, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayTypeEstimate (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD
+    Estimator TEXT,
+    BuyPrice REAL,
+    SellPrice REAL
+  );
+  CREATE INDEX IF NOT EXISTS DayTypeEstimateTypeIndex ON DayTypeEstimate (TypeId, RegionId, Estimator, Date DESC);
+  CREATE INDEX IF NOT EXISTS DayTypeEstimateDateIndex ON DayTypeEstimate (Date);`,
+	},
+	{
+		Version: 8,
+		Name:    "location metrics",
+		Sql: `
+  -- LocationId is a station id or a security band (1 highsec, 2 lowsec,
+  -- 3 nullsec) of the metrics package
+  CREATE TABLE IF NOT EXISTS HotLocationMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    LocationId INTEGER,
+    Time INTEGER,  -- Epoch Seconds
+    BuyPrice REAL,
+    SellPrice REAL
+  );
+  CREATE INDEX IF NOT EXISTS HotLocationMetricTypeIndex ON HotLocationMetric (TypeId, RegionId, LocationId, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayLocationMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    LocationId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD
+    BuyPrice REAL,
+    SellPrice REAL
+  );
+  CREATE INDEX IF NOT EXISTS DayLocationMetricTypeIndex ON DayLocationMetric (TypeId, RegionId, LocationId, Date DESC);
+  CREATE INDEX IF NOT EXISTS DayLocationMetricDateIndex ON DayLocationMetric (Date);`,
+	},
+	{
+		Version: 9,
+		Name:    "global metrics",
+		Sql: `
+  -- RegionId 0 is the whole market
+  CREATE TABLE IF NOT EXISTS HotGlobalMetric (
+    RegionId INTEGER,
+    Time INTEGER,  -- Epoch Seconds
+    BuyValue REAL,  -- ISK on buy orders
+    SellValue REAL,  -- ISK on sell orders
+    BuyOrderCount INTEGER,
+    SellOrderCount INTEGER,
+    ActiveTypes INTEGER,  -- Types with at least one order
+    PriceIndex REAL  -- 0 if not available
+  );
+  CREATE INDEX IF NOT EXISTS HotGlobalMetricRegionIndex ON HotGlobalMetric (RegionId, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayGlobalMetric (
+    RegionId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD
+    BuyValue REAL,  -- Averages of the hot global metrics of the day
+    SellValue REAL,
+    BuyOrderCount REAL,
+    SellOrderCount REAL,
+    ActiveTypes REAL,
+    PriceIndex REAL,
+    TradedValue REAL,  -- ISK traded during the day according to the histories
+    PRIMARY KEY (RegionId, Date)
+  );`,
+	},
+	{
+		Version: 10,
+		Name:    "screener",
+		Sql: `
+  -- Moves of the last history day of each history, replaced at each global
+  -- histories computation
+  CREATE TABLE IF NOT EXISTS Screener (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD
+    Average REAL,
+    Volume INTEGER,
+    TradedValue REAL,
+    DayChange REAL,
+    WeekChange REAL,
+    VolumeRatio REAL,
+    Breakout INTEGER,  -- 1 upward, -1 downward, 0 none
+    PRIMARY KEY (TypeId, RegionId)
+  );
+  CREATE INDEX IF NOT EXISTS ScreenerRegionIndex ON Screener (RegionId, TradedValue);`,
+	},
+	{
+		Version: 11,
+		Name:    "anomalies",
+		Sql: `
+  -- Suspicious market behaviours, see the anomalies package
+  CREATE TABLE IF NOT EXISTS Anomaly (
+    Kind TEXT,  -- price-spike, buy-above-history, sell-wipe or buy-wipe
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Time INTEGER,  -- Epoch Seconds
+    Value REAL,
+    Reference REAL,
+    Volume INTEGER,
+    UNIQUE (Kind, TypeId, RegionId, Time)
+  );
+  CREATE INDEX IF NOT EXISTS AnomalyTimeIndex ON Anomaly (Time);
+  CREATE INDEX IF NOT EXISTS AnomalyTypeRegionIndex ON Anomaly (TypeId, RegionId, Time);`,
+	},
+	{
+		Version: 12,
+		Name:    "alert rules",
+		Sql: `
+  -- Watch rules checked after every orders download, see the alerts package
+  CREATE TABLE IF NOT EXISTS AlertRule (
+    Id INTEGER PRIMARY KEY AUTOINCREMENT,
+    Kind TEXT,  -- sell-below, buy-above or spread-above
+    TypeId INTEGER,
+    RegionId INTEGER,
+    LocationId INTEGER,  -- 0 for the whole region
+    Threshold REAL,
+    Webhook TEXT,
+    Cooldown INTEGER,  -- Seconds
+    LastTriggered INTEGER,  -- Epoch Seconds, 0 if never delivered
+    LastValue REAL
+  );`,
+	},
+	{
+		Version: 13,
+		Name:    "api keys",
+		Sql: `
+  CREATE TABLE IF NOT EXISTS ApiKey (
+    Name TEXT PRIMARY KEY,
+    Hash TEXT UNIQUE,  -- hex sha256 of the key, the key itself is not stored
+    Rate REAL,  -- Requests per second, 0 for the default rate
+    Burst INTEGER,  -- 0 for the default burst
+    Created INTEGER  -- Epoch Seconds
+  );`,
+	},
+	{
+		// NOTE: INTERGER already had the integer affinity (it contains INT),
+		// the data is kept as is. The postgres schema of migration 15 has the
+		// right type.
+		Version: 14,
+		Name:    "fix DayTypeMetric Volume type",
+		Sql: `
+  CREATE TABLE DayTypeMetricFixed (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Date TEXT,  -- YYYY-MM-DD (https://sqlite.org/lang_datefunc.html#time_values)
+    BuyPrice REAL,
+    SellPrice REAL,
+    Volume INTEGER
+  );
+  INSERT INTO DayTypeMetricFixed (TypeId, RegionId, Date, BuyPrice, SellPrice, Volume)
+  SELECT TypeId, RegionId, Date, BuyPrice, SellPrice, Volume FROM DayTypeMetric;
+  DROP TABLE DayTypeMetric;
+  ALTER TABLE DayTypeMetricFixed RENAME TO DayTypeMetric;
+  CREATE INDEX DayTypeMetricTypeIndex ON DayTypeMetric (TypeId, RegionId, Date DESC);
+  CREATE INDEX DayTypeMetricDateIndex ON DayTypeMetric (Date, RegionId);`,
+	},
+	{
+		// NOTE: the postgres databases start with the schema of the sqlite
+		// database at this version
+		Version: 15,
+		Name:    "postgres schema",
+		Postgres: `
+  CREATE TABLE IF NOT EXISTS "Order" (
+    Id BIGINT PRIMARY KEY,
+    RegionId BIGINT,
+    Duration BIGINT,
+    IsBuyOrder BIGINT,
+    Issued TEXT,
+    LocationId BIGINT,
+    MinVolume BIGINT,
+    Price DOUBLE PRECISION,
+    Range TEXT,
+    SystemId BIGINT,
+    TypeId BIGINT,
+    VolumeRemain BIGINT,
+    VolumeTotal BIGINT
+  );
+  CREATE INDEX IF NOT EXISTS OrderTypeIndex ON "Order" (TypeId);
+  CREATE INDEX IF NOT EXISTS OrderTypeRegionIndex ON "Order" (TypeId, RegionId);
+
+  CREATE TABLE IF NOT EXISTS Location (
+    Id BIGINT PRIMARY KEY,
+    SystemId BIGINT,
+    Name TEXT,
+    Security DOUBLE PRECISION
+  );
+  CREATE INDEX IF NOT EXISTS LocationIndex ON Location (Id);
+
+  CREATE TABLE IF NOT EXISTS History (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    HistoryJson BYTEA,
+    PRIMARY KEY (TypeId, RegionId)
+  );
+  CREATE INDEX IF NOT EXISTS HistoryTypeIndex ON History (TypeId);
+  CREATE INDEX IF NOT EXISTS HistoryTypeRegionIndex ON History (TypeId, RegionId);
+
+  -- Moves of the last history day of each history, replaced at each global
+  -- histories computation
+  CREATE TABLE IF NOT EXISTS Screener (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Date TEXT,  -- YYYY-MM-DD
+    Average DOUBLE PRECISION,
+    Volume BIGINT,
+    TradedValue DOUBLE PRECISION,
+    DayChange DOUBLE PRECISION,
+    WeekChange DOUBLE PRECISION,
+    VolumeRatio DOUBLE PRECISION,
+    Breakout BIGINT,  -- 1 upward, -1 downward, 0 none
+    PRIMARY KEY (TypeId, RegionId)
+  );
+  CREATE INDEX IF NOT EXISTS ScreenerRegionIndex ON Screener (RegionId, TradedValue);
+
+  -- Suspicious market behaviours, see the anomalies package
+  CREATE TABLE IF NOT EXISTS Anomaly (
+    Kind TEXT,  -- price-spike, buy-above-history, sell-wipe or buy-wipe
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Time BIGINT,  -- Epoch Seconds
+    Value DOUBLE PRECISION,
+    Reference DOUBLE PRECISION,
+    Volume BIGINT,
+    UNIQUE (Kind, TypeId, RegionId, Time)
+  );
+  CREATE INDEX IF NOT EXISTS AnomalyTimeIndex ON Anomaly (Time);
+  CREATE INDEX IF NOT EXISTS AnomalyTypeRegionIndex ON Anomaly (TypeId, RegionId, Time);
+
+  -- Watch rules checked after every orders download, see the alerts package
+  CREATE TABLE IF NOT EXISTS AlertRule (
+    Id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    Kind TEXT,  -- sell-below, buy-above or spread-above
+    TypeId BIGINT,
+    RegionId BIGINT,
+    LocationId BIGINT,  -- 0 for the whole region
+    Threshold DOUBLE PRECISION,
+    Webhook TEXT,
+    Cooldown BIGINT,  -- Seconds
+    LastTriggered BIGINT,  -- Epoch Seconds, 0 if never delivered
+    LastValue DOUBLE PRECISION
+  );
+
+  CREATE TABLE IF NOT EXISTS ApiKey (
+    Name TEXT PRIMARY KEY,
+    Hash TEXT UNIQUE,  -- hex sha256 of the key, the key itself is not stored
+    Rate DOUBLE PRECISION,  -- Requests per second, 0 for the default rate
+    Burst BIGINT,  -- 0 for the default burst
+    Created BIGINT  -- Epoch Seconds
+  );
+
+  CREATE TABLE IF NOT EXISTS ActiveMarket (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    PRIMARY KEY (TypeId, RegionId)
+  );
+
+  CREATE TABLE IF NOT EXISTS ActiveMarketState (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    LastSeen BIGINT,  -- Epoch Seconds, last time the market had orders
+    LastHistory BIGINT,  -- Epoch Seconds, last day with volume in the history
+    NotFoundCount BIGINT,  -- Consecutive 404 or 400 history responses
+    Status TEXT,  -- active, weekly or retired
+    Override TEXT,  -- Status forced by an admin, NULL if none
+    PRIMARY KEY (TypeId, RegionId)
+  );
+
+  CREATE TABLE IF NOT EXISTS ActiveMarketValue (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    TradedValue DOUBLE PRECISION,  -- Isk traded on the last day of the history
+    PRIMARY KEY (TypeId, RegionId)
+  );
+
+  CREATE TABLE IF NOT EXISTS HistoryRun (
+    Date TEXT PRIMARY KEY,  -- YYYY-MM-DD
+    Started BIGINT,  -- Epoch Seconds
+    ChunkCount BIGINT,
+    CompletedChunks BIGINT,
+    Done BIGINT
+  );
+  -- Markets of the current history run in download order
+  CREATE TABLE IF NOT EXISTS HistoryRunMarket (
+    Position BIGINT PRIMARY KEY,
+    TypeId BIGINT,
+    RegionId BIGINT
+  );
+  CREATE TABLE IF NOT EXISTS HistoryRunFailure (
+    Date TEXT,  -- YYYY-MM-DD
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Error TEXT,
+    PRIMARY KEY (Date, TypeId, RegionId)
+  );
+
+  CREATE TABLE IF NOT EXISTS HotTypeMetric (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Time BIGINT,  -- Epoch Seconds
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION
+    -- No primary key here as data integrity is not a big concern
+  );
+  CREATE TABLE IF NOT EXISTS HourTypeMetric (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Time BIGINT,  -- Epoch Seconds of the start of the hour
+    BuyPrice DOUBLE PRECISION,  -- Average of the hot data points of the hour
+    SellPrice DOUBLE PRECISION,
+    PRIMARY KEY (TypeId, RegionId, Time)
+  );
+  CREATE TABLE IF NOT EXISTS DayTypeMetric (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Date TEXT,  -- YYYY-MM-DD
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION,
+    Volume BIGINT
+  );
+  CREATE INDEX IF NOT EXISTS DayTypeMetricTypeIndex ON DayTypeMetric (TypeId, RegionId, Date DESC);
+  CREATE INDEX IF NOT EXISTS DayTypeMetricDateIndex ON DayTypeMetric (Date, RegionId);
+  -- Prices of the non best estimators of the metrics package, stored apart
+  -- from HotTypeMetric and DayTypeMetric to leave them untouched
+  CREATE TABLE IF NOT EXISTS HotTypeEstimate (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Time BIGINT,  -- Epoch Seconds
+    Estimator TEXT,
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION
+  );
+  CREATE INDEX IF NOT EXISTS HotTypeEstimateTypeIndex ON HotTypeEstimate (TypeId, RegionId, Estimator, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayTypeEstimate (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    Date TEXT,  -- YYYY-MM-DD
+    Estimator TEXT,
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION
+  );
+  CREATE INDEX IF NOT EXISTS DayTypeEstimateTypeIndex ON DayTypeEstimate (TypeId, RegionId, Estimator, Date DESC);
+  CREATE INDEX IF NOT EXISTS DayTypeEstimateDateIndex ON DayTypeEstimate (Date);
+  -- LocationId is a station id or a security band (1 highsec, 2 lowsec,
+  -- 3 nullsec) of the metrics package
+  CREATE TABLE IF NOT EXISTS HotLocationMetric (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    LocationId BIGINT,
+    Time BIGINT,  -- Epoch Seconds
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION
+  );
+  CREATE INDEX IF NOT EXISTS HotLocationMetricTypeIndex ON HotLocationMetric (TypeId, RegionId, LocationId, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayLocationMetric (
+    TypeId BIGINT,
+    RegionId BIGINT,
+    LocationId BIGINT,
+    Date TEXT,  -- YYYY-MM-DD
+    BuyPrice DOUBLE PRECISION,
+    SellPrice DOUBLE PRECISION
+  );
+  CREATE INDEX IF NOT EXISTS DayLocationMetricTypeIndex ON DayLocationMetric (TypeId, RegionId, LocationId, Date DESC);
+  CREATE INDEX IF NOT EXISTS DayLocationMetricDateIndex ON DayLocationMetric (Date);
+  -- RegionId 0 is the whole market
+  CREATE TABLE IF NOT EXISTS HotGlobalMetric (
+    RegionId BIGINT,
+    Time BIGINT,  -- Epoch Seconds
+    BuyValue DOUBLE PRECISION,  -- ISK on buy orders
+    SellValue DOUBLE PRECISION,  -- ISK on sell orders
+    BuyOrderCount BIGINT,
+    SellOrderCount BIGINT,
+    ActiveTypes BIGINT,  -- Types with at least one order
+    PriceIndex DOUBLE PRECISION  -- 0 if not available
+  );
+  CREATE INDEX IF NOT EXISTS HotGlobalMetricRegionIndex ON HotGlobalMetric (RegionId, Time DESC);
+  CREATE TABLE IF NOT EXISTS DayGlobalMetric (
+    RegionId BIGINT,
+    Date TEXT,  -- YYYY-MM-DD
+    BuyValue DOUBLE PRECISION,  -- Averages of the hot global metrics of the day
+    SellValue DOUBLE PRECISION,
+    BuyOrderCount DOUBLE PRECISION,
+    SellOrderCount DOUBLE PRECISION,
+    ActiveTypes DOUBLE PRECISION,
+    PriceIndex DOUBLE PRECISION,
+    TradedValue DOUBLE PRECISION,  -- ISK traded during the day according to the histories
+    PRIMARY KEY (RegionId, Date)
+  );
+
+  CREATE TABLE IF NOT EXISTS TimeRecord (
+    "Key" TEXT PRIMARY KEY,
+    Time BIGINT  -- Epoch Seconds
+  );
+
+  -- The time series of the metrics are hypertables with TimescaleDB
+  DO $$
+  BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') THEN
+      PERFORM create_hypertable('HotTypeMetric', 'time', chunk_time_interval => 86400);
+      PERFORM create_hypertable('HourTypeMetric', 'time', chunk_time_interval => 7 * 86400);
+      PERFORM create_hypertable('HotTypeEstimate', 'time', chunk_time_interval => 86400);
+      PERFORM create_hypertable('HotLocationMetric', 'time', chunk_time_interval => 86400);
+      PERFORM create_hypertable('HotGlobalMetric', 'time', chunk_time_interval => 7 * 86400);
+    END IF;
+  END $$;`,
+	},
+	{
+		// NOTE: the json rows are compressed lazily by the histories worker, see
+		// items/shared/historyblob.go
+		Version: 16,
+		Name:    "compressed history blobs",
+		Sql: `
+  ALTER TABLE History RENAME COLUMN HistoryJson TO HistoryBlob;
+  ALTER TABLE History ADD COLUMN Format INTEGER NOT NULL DEFAULT 0;  -- 0 json, 1 zstd columns
+  CREATE INDEX HistoryJsonIndex ON History (TypeId, RegionId) WHERE Format = 0;`,
+		Postgres: `
+  ALTER TABLE History RENAME COLUMN HistoryJson TO HistoryBlob;
+  ALTER TABLE History ADD COLUMN Format INTEGER NOT NULL DEFAULT 0;  -- 0 json, 1 zstd columns
+  CREATE INDEX HistoryJsonIndex ON History (TypeId, RegionId) WHERE Format = 0;`,
+	},
+	{
+		// NOTE: sqlite keeps a blob per market in History. On postgres the
+		// History rows of Format 2 have their days in HistoryDay, the older
+		// rows are moved to it by the histories compaction.
+		Version: 17,
+		Name:    "postgres history days",
+		Postgres: `
+  CREATE TABLE HistoryDay (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Time BIGINT,  -- Epoch Seconds of the day
+    Average DOUBLE PRECISION,
+    Average5d DOUBLE PRECISION,
+    Average20d DOUBLE PRECISION,
+    Highest DOUBLE PRECISION,
+    Lowest DOUBLE PRECISION,
+    OrderCount BIGINT,
+    Volume BIGINT,
+    DonchianTop DOUBLE PRECISION,
+    DonchianBottom DOUBLE PRECISION,
+    Filled INTEGER,
+    PRIMARY KEY (TypeId, RegionId, Time)
+  );
+
+  DO $$
+  BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') THEN
+      PERFORM create_hypertable('HistoryDay', 'time', chunk_time_interval => 30 * 86400);
+    END IF;
+  END $$;`,
+	},
+}
+
+// Apply the pending migrations. In dry run mode the sqlite migrations are
+// applied to a temporary copy of the database, each in its own transaction as
+// in a real run, and the postgres migrations are applied in a single
+// transaction that is rolled back. Returns the pending migrations.
+func (db *DB) Migrate(ctx context.Context, dryRun bool) ([]Migration, error) {
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Migration, 0)
+	for _, s := range states {
+		if s.Applied.IsZero() {
+			pending = append(pending, s.Migration)
+		}
+	}
+	if len(pending) == 0 {
+		return pending, nil
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, migrationTimeout)
+	defer cancel()
+
+	if !dryRun {
+		err = db.applyMigrations(timeoutCtx, pending, true)
+		if err != nil {
+			return nil, err
+		}
+		return pending, nil
+	}
+
+	// NOTE: postgres has transactional ddl, the rolled back transaction sees
+	// the same schema as the real run
+	if db.driver == Postgres {
+		tx, err := db.Begin(timeoutCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		for _, m := range pending {
+			err = applyMigration(timeoutCtx, tx, m)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pending, nil
+	}
+
+	dir, err := os.MkdirTemp("", "store-migrate-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	copyPath := filepath.Join(dir, "db.sqlite")
+	err = db.VacuumInto(timeoutCtx, copyPath)
+	if err != nil {
+		return nil, fmt.Errorf("copying the database: %w", err)
+	}
+	dbCopy, err := Open(copyPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dbCopy.Close()
+	err = dbCopy.applyMigrations(timeoutCtx, pending, false)
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// Apply each migration in its own transaction
+func (db *DB) applyMigrations(ctx context.Context, migrations []Migration, verbose bool) error {
+	for _, m := range migrations {
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		err = applyMigration(ctx, tx, m)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		if verbose {
+			log.Printf("Database: applied migration %d (%s)", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, tx *dbTx, m Migration) error {
+	migrationSql := m.Sql
+	if tx.driver == Postgres {
+		migrationSql = m.Postgres
+	}
+
+	// NOTE: not using tx.Exec to keep the migrations out of the request metrics
+	_, err := tx.tx.ExecContext(ctx, createSchemaVersion)
+	if err != nil {
+		return err
+	}
+	if migrationSql != "" {
+		_, err = tx.tx.ExecContext(ctx, migrationSql)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	_, err = tx.tx.ExecContext(
+		ctx,
+		rebind(tx.driver, "INSERT INTO SchemaVersion VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now().Unix(),
+	)
+	return err
+}
+
+// State of every migration known by the store
+func (db *DB) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	applied := make(map[int]int64)
+	existsQuery := "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'SchemaVersion')"
+	if db.driver == Postgres {
+		existsQuery = "SELECT to_regclass('schemaversion') IS NOT NULL"
+	}
+	var exists bool
+	err := db.QueryRow(timeoutCtx, existsQuery).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := db.Query(timeoutCtx, "SELECT Version, Applied FROM SchemaVersion")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			var appliedTime int64
+			err = rows.Scan(&version, &appliedTime)
+			if err != nil {
+				return nil, err
+			}
+			applied[version] = appliedTime
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].Migration = m
+		if appliedTime, ok := applied[m.Version]; ok {
+			states[i].Applied = time.Unix(appliedTime, 0)
+			delete(applied, m.Version)
+		}
+	}
+	for version := range applied {
+		return nil, fmt.Errorf("the database is at schema version %d, unknown to this store", version)
+	}
+
+	return states, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a database created before the migrations
+	_, err = db.Exec(ctx, `
+  CREATE TABLE DayTypeMetric (
+    TypeId INTEGER,
+    RegionId INTEGER,
+    Date TEXT,
+    BuyPrice REAL,
+    SellPrice REAL,
+    Volume INTERGER
+  );
+  INSERT INTO DayTypeMetric VALUES (34, 10000002, '2024-01-01', 4.5, 5.5, 1000);
+  CREATE TABLE ApiKey (Name TEXT PRIMARY KEY, Hash TEXT UNIQUE, Rate REAL, Burst INTEGER, Created INTEGER);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := db.Migrate(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Fatalf("dry run: expected %d pending migrations, got %d", len(migrations), len(pending))
+	}
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !states[0].Applied.IsZero() {
+		t.Fatal("dry run applied a migration")
+	}
+	var tables int
+	err = db.QueryRow(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 2 {
+		t.Fatalf("dry run changed the schema, %d tables", tables)
+	}
+
+	_, err = db.Migrate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = db.Migrate(ctx, false)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending migration, got %v %v", pending, err)
+	}
+
+	var volume int
+	var columnType string
+	err = db.QueryRow(ctx, "SELECT Volume, typeof(Volume) FROM DayTypeMetric WHERE TypeId = 34").Scan(&volume, &columnType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 1000 || columnType != "integer" {
+		t.Errorf("expected the volume 1000 to be kept as integer, got %d %s", volume, columnType)
+	}
+	err = db.QueryRow(ctx, "SELECT type FROM pragma_table_info('DayTypeMetric') WHERE name = 'Volume'").Scan(&columnType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columnType != "INTEGER" {
+		t.Errorf("expected the Volume column to be INTEGER, got %s", columnType)
+	}
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"INSERT INTO Location VALUES (?,?,?,?)", "INSERT INTO Location VALUES ($1,$2,$3,$4)"},
+		{`SELECT '?', "?" FROM "Order" WHERE Id = ?`, `SELECT '?', "?" FROM "Order" WHERE Id = $1`},
+		{"SELECT ? -- why ?\n, ?", "SELECT $1 -- why ?\n, $2"},
+	}
+	for _, test := range tests {
+		if got := rebind(Sqlite, test.query); got != test.query {
+			t.Errorf("sqlite query rewritten to %q", got)
+		}
+		got := rebind(Postgres, test.query)
+		if got != test.want {
+			t.Errorf("rebind(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestInList(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = db.Exec(ctx, "INSERT INTO Location VALUES (?,?,?,?)", 60003760, "Jita IV - Moon 4", 30000142, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ids := range [][]any{{}, {1, 60003760}} {
+		var count int
+		query := "SELECT COUNT(*) FROM Location WHERE Id IN " + InList(len(ids))
+		err = db.QueryRow(ctx, query, ids...).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(ids)/2 {
+			t.Errorf("%d locations in %v", count, ids)
+		}
+	}
+}
+
+func TestInArray(t *testing.T) {
+	db, err := Init(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = db.Exec(ctx, "INSERT INTO Location VALUES (?,?,?,?)", 60003760, "Jita IV - Moon 4", 30000142, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more ids than sqlite accepts parameters
+	many := make([]int, 40000)
+	for i := range many {
+		many[i] = 60000000 + i
+	}
+	for _, ids := range [][]int{nil, {1}, many} {
+		var count int
+		cond, arg := db.InArray(ids)
+		err = db.QueryRow(ctx, "SELECT COUNT(*) FROM Location WHERE Id "+cond, arg).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(ids) / len(many); count != want {
+			t.Errorf("%d locations in %d ids, want %d", count, len(ids), want)
+		}
+	}
+}
+
+// Runs the migrations against the database of STORE_TEST_POSTGRES, like
+// postgres://store@localhost/store_test?sslmode=disable
+func TestPostgresMigrate(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("STORE_TEST_POSTGRES is not set")
+	}
+	db, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err = db.Migrate(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, err := db.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range states {
+		if s.Applied.IsZero() {
+			t.Errorf("migration %d is pending", s.Version)
+		}
+	}
+}
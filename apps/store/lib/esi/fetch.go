@@ -44,25 +44,76 @@ type jsonSsoResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-const esiRoot = "https://esi.evetech.net/latest"
-const requestTimeout = 7 * time.Second
 const DateLayout = "2006-01-02"
-const MaxConcurrentRequests = 10
 
 var ErrNoTrailsLeft = errors.New("No trails left")
 var ErrImplicitTimeout = errors.New("Esi implicit timeout")
 var ErrErrorRateTimeout = errors.New("Esi error rate timeout")
 var ErrExplicitTimeout = errors.New("Esi explicit timeout")
 
-var semaphore = sem.New(MaxConcurrentRequests)
-var esiTimeout time.Time
-var esiTimeoutMu sync.Mutex
-// NOTE: I should wrap that into a mutex right?
-var accessToken string
-var accessTokenExpiry time.Time
+type Options struct {
+	Root                  string // https://esi.evetech.net/latest if empty
+	SsoUrl                string // https://login.eveonline.com/v2/oauth/token if empty
+	UserAgent             string
+	MaxConcurrentRequests int           // 10 if 0
+	RequestTimeout        time.Duration // 7s if 0
+	// ssoClientId, ssoClientSecret and ssoRefreshToken are required by the
+	// authenticated requests
+	Secrets *secret.SecretManager
+}
+
+type semaphore interface {
+	AcquireWithContext(ctx context.Context, priority int) (int, error)
+	Release(thread int)
+}
+
+// A client holds the state of the connection to the esi: the timeouts, the
+// error budget and the sso token
+type Client struct {
+	opts       Options
+	semaphore  semaphore
+	httpClient *http.Client
+
+	timeoutMu  sync.Mutex
+	esiTimeout time.Time
+
+	errorLimitMu     sync.Mutex
+	errorLimitRemain int // -1 until esi sends the header
+	errorLimitReset  time.Time
+
+	tokenMu           sync.Mutex
+	accessToken       string
+	accessTokenExpiry time.Time
+}
+
+func NewClient(opts Options) *Client {
+	if opts.Root == "" {
+		opts.Root = "https://esi.evetech.net/latest"
+	}
+	if opts.SsoUrl == "" {
+		opts.SsoUrl = "https://login.eveonline.com/v2/oauth/token"
+	}
+	if opts.MaxConcurrentRequests == 0 {
+		opts.MaxConcurrentRequests = 10
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = 7 * time.Second
+	}
+	return &Client{
+		opts:             opts,
+		semaphore:        sem.New(opts.MaxConcurrentRequests),
+		httpClient:       &http.Client{Timeout: opts.RequestTimeout},
+		errorLimitRemain: -1,
+	}
+}
+
+func (c *Client) MaxConcurrentRequests() int {
+	return c.opts.MaxConcurrentRequests
+}
 
 func EsiFetch[T any](
 	ctx context.Context,
+	c *Client,
 	method string,
 	uri string,
 	body any,
@@ -79,7 +130,7 @@ func EsiFetch[T any](
 	// Init retry function that will be called in case the request fail
 	retry := func(fallbackErr error) (EsiResponse[T], error) {
 		reportEsiRequest("retry")
-		retryResponse, retryErr := EsiFetch[T](ctx, method, uri, body, authenticated, priority, trails-1)
+		retryResponse, retryErr := EsiFetch[T](ctx, c, method, uri, body, authenticated, priority, trails-1)
 		if errors.Is(retryErr, ErrNoTrailsLeft) {
 			return EsiResponse[T]{}, fmt.Errorf("no trails left: %w", fallbackErr)
 		}
@@ -88,16 +139,16 @@ func EsiFetch[T any](
 
 	// Require premission from the semaphore
 	timeoutCtx, cancel := context.WithTimeout(ctx, 15*time.Minute)
-	thread, err := semaphore.AcquireWithContext(timeoutCtx, priority)
+	thread, err := c.semaphore.AcquireWithContext(timeoutCtx, priority)
 	cancel() // cancel context if AcquireWithContext end before timeout
 	if err != nil {
 		return EsiResponse[T]{}, fmt.Errorf("semaphore: %w", err)
 	}
-	defer semaphore.Release(thread)
+	defer c.semaphore.Release(thread)
 
 	// Wait for the api to be clear of any timeout
 	timeoutCtx, cancel = context.WithTimeout(ctx, 15*time.Minute)
-	err = clearEsiTimeout(timeoutCtx)
+	err = c.clearEsiTimeout(timeoutCtx)
 	cancel()
 	if err != nil {
 		return EsiResponse[T]{}, fmt.Errorf("esi timeout clearing: %w", err)
@@ -111,14 +162,14 @@ func EsiFetch[T any](
 			return EsiResponse[T]{}, fmt.Errorf("body mashalling: %w", err)
 		}
 	}
-	request, err := http.NewRequestWithContext(ctx, method, esiRoot+uri, bytes.NewBuffer(jsonBody))
+	request, err := http.NewRequestWithContext(ctx, method, c.opts.Root+uri, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return EsiResponse[T]{}, fmt.Errorf("new request: %w", err)
 	}
 	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", "evemarketbrowser.com - contact me at raphguyader@gmail.com")
+	request.Header.Set("User-Agent", c.opts.UserAgent)
 	if authenticated {
-		token, err := acquireSSOToken(ctx)
+		token, err := c.acquireSSOToken(ctx)
 		if err != nil {
 			return EsiResponse[T]{}, fmt.Errorf("acquire SSO token: %w", err)
 		}
@@ -126,11 +177,8 @@ func EsiFetch[T any](
 	}
 
 	// Run the request
-	client := &http.Client{
-		Timeout: requestTimeout,
-	}
-	response, err := client.Do(request)
-	semaphore.Release(thread)
+	response, err := c.httpClient.Do(request)
+	c.semaphore.Release(thread)
 	if err != nil {
 		if errors.Is(err, ctx.Err()) {
 			reportEsiRequest("failure")
@@ -139,10 +187,11 @@ func EsiFetch[T any](
 		return retry(fmt.Errorf("http request: %w", err))
 	}
 	defer response.Body.Close()
+	c.recordErrorLimit(response.Header)
 
 	// Implicit timeout
 	if response.StatusCode == 503 || response.StatusCode == 500 {
-		declareEsiTimeout(20 * time.Second)
+		c.declareEsiTimeout(20 * time.Second)
 
 		log.Printf("Esi fetch: 20s implicit esi timeout %d", response.StatusCode)
 		reportEsiError(response.StatusCode, "")
@@ -168,7 +217,7 @@ func EsiFetch[T any](
 				timeout = time.Duration(secs) * time.Second
 			}
 		}
-		declareEsiTimeout(timeout)
+		c.declareEsiTimeout(timeout)
 
 		log.Printf("Esi fetch: %fs request rate timeout", timeout.Seconds())
 		reportEsiError(response.StatusCode, "")
@@ -188,7 +237,7 @@ func EsiFetch[T any](
 		} else {
 			timeout = time.Duration(secs) * time.Second
 		}
-		declareEsiTimeout(timeout)
+		c.declareEsiTimeout(timeout)
 
 		log.Printf("Esi fetch: %fs error rate timeout", timeout.Seconds())
 		reportEsiError(response.StatusCode, "")
@@ -213,7 +262,7 @@ func EsiFetch[T any](
 		} else {
 			timeout = time.Duration(timeoutError.Timeout) * time.Second
 		}
-		declareEsiTimeout(timeout)
+		c.declareEsiTimeout(timeout)
 
 		log.Printf("Esi fetch: %fs explicit esi timeout", timeout.Seconds())
 		reportEsiError(response.StatusCode, timeoutError.Error)
@@ -261,19 +310,20 @@ func EsiFetch[T any](
 	return esiResponse, nil
 }
 
-func acquireSSOToken(ctx context.Context) (string, error) {
-	sm := ctx.Value("sm").(*secret.SecretManager)
-	clientId := sm.Get("ssoClientId")
-	clientSecret := sm.Get("ssoClientSecret")
-	refreshToken := sm.Get("ssoRefreshToken")
+func (c *Client) acquireSSOToken(ctx context.Context) (string, error) {
+	clientId := c.opts.Secrets.Get("ssoClientId")
+	clientSecret := c.opts.Secrets.Get("ssoClientSecret")
+	refreshToken := c.opts.Secrets.Get("ssoRefreshToken")
 
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
 	now := time.Now()
-	if now.Before(accessTokenExpiry) {
-		return accessToken, nil
+	if now.Before(c.accessTokenExpiry) {
+		return c.accessToken, nil
 	}
 
 	// Create the request
-	url := "https://login.eveonline.com/v2/oauth/token"
+	url := c.opts.SsoUrl
 	var body bytes.Buffer
 	fmt.Fprintf(&body, "grant_type=refresh_token&refresh_token=%s", refreshToken)
 	request, err := http.NewRequestWithContext(ctx, "POST", url, &body)
@@ -281,14 +331,11 @@ func acquireSSOToken(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("new request: %w", err)
 	}
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	request.Header.Set("User-Agent", "evemarketbrowser.com - contact me at raphguyader@gmail.com")
+	request.Header.Set("User-Agent", c.opts.UserAgent)
 	request.Header.Set("Authorization", createBasicAuthHeader(clientId, clientSecret))
 
 	// Run the request
-	client := &http.Client{
-		Timeout: requestTimeout,
-	}
-	response, err := client.Do(request)
+	response, err := c.httpClient.Do(request)
 	if err != nil {
 		return "", fmt.Errorf("http request: %w", err)
 	}
@@ -307,13 +354,13 @@ func acquireSSOToken(ctx context.Context) (string, error) {
 		log.Panicf("unexpected sso repseonse: %v", ssoResponse)
 	}
 
-	accessToken = ssoResponse.AccessToken
-	accessTokenExpiry = now.Add(time.Duration(ssoResponse.ExpiresIn) * time.Second)
-	if time.Now().After(accessTokenExpiry) {
+	c.accessToken = ssoResponse.AccessToken
+	c.accessTokenExpiry = now.Add(time.Duration(ssoResponse.ExpiresIn) * time.Second)
+	if time.Now().After(c.accessTokenExpiry) {
 		log.Panic("hummmm")
 	}
 
-	return accessToken, nil
+	return c.accessToken, nil
 }
 
 func createBasicAuthHeader(user string, password string) string {
@@ -321,12 +368,10 @@ func createBasicAuthHeader(user string, password string) string {
 	return "Basic " + base64.StdEncoding.EncodeToString([]byte(payload))
 }
 
-func clearEsiTimeout(ctx context.Context) error {
+func (c *Client) clearEsiTimeout(ctx context.Context) error {
 	// This function need to be called in a timeout context
 	for {
-		esiTimeoutMu.Lock()
-		esiTimeoutCopy := esiTimeout
-		esiTimeoutMu.Unlock()
+		esiTimeoutCopy := c.Timeout()
 		if time.Now().After(esiTimeoutCopy) {
 			return nil
 		}
@@ -343,10 +388,40 @@ func clearEsiTimeout(ctx context.Context) error {
 	}
 }
 
-func declareEsiTimeout(duration time.Duration) {
-	esiTimeoutMu.Lock()
-	esiTimeout = time.Now().Add(duration)
-	esiTimeoutMu.Unlock()
+func (c *Client) declareEsiTimeout(duration time.Duration) {
+	c.timeoutMu.Lock()
+	c.esiTimeout = time.Now().Add(duration)
+	c.timeoutMu.Unlock()
+}
+
+// Time until which the requests are paused by an esi timeout
+func (c *Client) Timeout() time.Time {
+	c.timeoutMu.Lock()
+	defer c.timeoutMu.Unlock()
+	return c.esiTimeout
+}
+
+func (c *Client) recordErrorLimit(header http.Header) {
+	remain, err := strconv.Atoi(header.Get("X-Esi-Error-Limit-Remain"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.Atoi(header.Get("X-Esi-Error-Limit-Reset"))
+	if err != nil {
+		return
+	}
+	c.errorLimitMu.Lock()
+	c.errorLimitRemain = remain
+	c.errorLimitReset = time.Now().Add(time.Duration(reset) * time.Second)
+	c.errorLimitMu.Unlock()
+}
+
+// Errors left before esi blocks the store and time at which the budget resets
+// as of the last esi response. remain is -1 if unknown.
+func (c *Client) ErrorBudget() (remain int, reset time.Time) {
+	c.errorLimitMu.Lock()
+	defer c.errorLimitMu.Unlock()
+	return c.errorLimitRemain, c.errorLimitReset
 }
 
 func (e *EsiError) Error() string {
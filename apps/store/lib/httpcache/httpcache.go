@@ -0,0 +1,67 @@
+// Helpers for the caching and freshness headers of the api responses
+
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set the Last-Modified and X-Data-Age headers, X-Data-Age is the age of the
+// data in seconds. Nothing is set if lastModified is zero.
+func SetFreshness(w http.ResponseWriter, lastModified time.Time) {
+	if lastModified.IsZero() {
+		return
+	}
+	age := max(0, int64(time.Since(lastModified).Seconds()))
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Data-Age", strconv.FormatInt(age, 10))
+}
+
+// Set Cache-Control so that the response is cached until the next expected
+// refresh. The response must be revalidated if the refresh is late or unknown.
+// A private response is only cached by the client, shared caches would serve
+// it to the requests without api key.
+func SetMaxAge(w http.ResponseWriter, nextRefresh time.Time, private bool) {
+	maxAge := int64(time.Until(nextRefresh).Seconds())
+	if nextRefresh.IsZero() || maxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, maxAge))
+}
+
+// Set the ETag header and respond 304 if the request If-None-Match matches
+// etag. Returns true if the response was sent.
+func NotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if !matchETag(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	// 304 must not carry the representation headers
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Weak comparison as required for If-None-Match
+func matchETag(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetFreshness(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetFreshness(w, time.Time{})
+	if w.Header().Get("Last-Modified") != "" || w.Header().Get("X-Data-Age") != "" {
+		t.Fatalf("expected no header for a zero time, got %v", w.Header())
+	}
+
+	lastModified := time.Now().Add(-90 * time.Second)
+	w = httptest.NewRecorder()
+	SetFreshness(w, lastModified)
+	if got := w.Header().Get("Last-Modified"); got != lastModified.UTC().Format(http.TimeFormat) {
+		t.Errorf("unexpected Last-Modified %q", got)
+	}
+	if got := w.Header().Get("X-Data-Age"); got != "90" && got != "89" && got != "91" {
+		t.Errorf("unexpected X-Data-Age %q", got)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := `"1700000000"`
+
+	tests := []struct {
+		ifNoneMatch string
+		notModified bool
+	}{
+		{"", false},
+		{etag, true},
+		{"W/" + etag, true},
+		{`"other", ` + etag, true},
+		{`"other"`, false},
+		{"*", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/history", nil)
+		if test.ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", test.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		got := NotModified(w, r, etag)
+		if got != test.notModified {
+			t.Errorf("If-None-Match %q: got %v, want %v", test.ifNoneMatch, got, test.notModified)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %q: ETag not set", test.ifNoneMatch)
+		}
+		if got && w.Code != 304 {
+			t.Errorf("If-None-Match %q: got status %d, want 304", test.ifNoneMatch, w.Code)
+		}
+	}
+}
+
+func TestSetMaxAge(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetMaxAge(w, time.Now().Add(-time.Minute), false)
+	if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("expected no-cache for a late refresh, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	SetMaxAge(w, time.Now().Add(10*time.Minute+time.Second), false)
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=600" {
+		t.Errorf("unexpected Cache-Control %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	SetMaxAge(w, time.Now().Add(10*time.Minute+time.Second), true)
+	if got := w.Header().Get("Cache-Control"); got != "private, max-age=600" {
+		t.Errorf("unexpected Cache-Control with api keys %q", got)
+	}
+}
@@ -0,0 +1,102 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+var errorCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	429: "too_many_requests",
+	500: "internal_error",
+	503: "unavailable",
+}
+
+// Turn the plain text errors sent with http.Error by the handlers into json
+// error objects
+func JSONErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status != 0 {
+			writeJSONError(w, ew.status, ew.body.String(), ew.param)
+		}
+	})
+}
+
+// Respond 400 to the invalid param name with the plain text error
+// Bad request: param "name" message
+// Behind JSONErrors, the param name is given in the json error.
+func ParamError(w http.ResponseWriter, name string, message string) {
+	if ew, ok := w.(*errorWriter); ok {
+		ew.param = name
+	}
+	http.Error(w, fmt.Sprintf(`Bad request: param "%s" %s`, name, message), 400)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, text string, param string) {
+	message := strings.TrimSpace(text)
+	message = strings.TrimPrefix(message, "Bad request: ")
+	e := apiError{Code: errorCodes[status], Message: message, Parameter: param}
+	if e.Code == "" {
+		e.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+	}
+
+	w.Header().Del("X-Content-Type-Options")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// Buffer the body of the error responses, other responses are passed through
+type errorWriter struct {
+	http.ResponseWriter
+	status int    // 0 if the response is not an error
+	param  string // invalid param of a ParamError
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (ew *errorWriter) WriteHeader(status int) {
+	if ew.wrote || ew.status != 0 {
+		return
+	}
+	if status >= 400 {
+		ew.status = status
+		return
+	}
+	ew.wrote = true
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *errorWriter) Write(b []byte) (int, error) {
+	if ew.status != 0 {
+		return ew.body.Write(b)
+	}
+	ew.wrote = true
+	return ew.ResponseWriter.Write(b)
+}
+
+// Needed by the server-sent events
+func (ew *errorWriter) Flush() {
+	if ew.status != 0 {
+		return
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		ew.wrote = true
+		f.Flush()
+	}
+}
@@ -0,0 +1,253 @@
+// Minimal OpenAPI 3 description of the api. The endpoints are described by
+// Operations declared next to their handlers, and the response schemas are
+// derived from the handler types by reflection so that the document can't
+// drift from what the handlers return.
+
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type Param struct {
+	Name        string
+	Description string
+	Type        string // integer, number or string
+	Required    bool
+	Enum        []string
+	// Extra validation run by Handler, nil to only check the type and the enum
+	Validate func(value string) error
+}
+
+type Operation struct {
+	Path    string
+	Summary string
+	Params  []Param
+	// Value of the type returned by the handler, nil for no json body
+	Response any
+	// application/json if empty
+	ContentType string
+	Handler     http.HandlerFunc
+}
+
+// Wrap the operation handler with the validation of its query params. The
+// errors are sent with ParamError like the handlers do.
+func Handler(op Operation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+		query := r.URL.Query()
+		for _, p := range op.Params {
+			err := p.check(query.Get(p.Name))
+			if err != nil {
+				ParamError(w, p.Name, err.Error())
+				return
+			}
+		}
+		op.Handler(w, r)
+	}
+}
+
+func (p *Param) check(value string) error {
+	if value == "" {
+		if p.Required {
+			return fmt.Errorf("is required")
+		}
+		return nil
+	}
+	switch p.Type {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("is invalid integer")
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("is invalid number")
+		}
+	}
+	if len(p.Enum) > 0 {
+		found := false
+		for _, e := range p.Enum {
+			found = found || e == value
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s", strings.Join(p.Enum, ", "))
+		}
+	}
+	if p.Validate != nil {
+		return p.Validate(value)
+	}
+	return nil
+}
+
+// Build the OpenAPI document of the operations, served under prefix
+func Document(title string, version string, prefix string, ops []Operation) map[string]any {
+	b := schemaBuilder{schemas: make(map[string]any), types: make(map[string]reflect.Type)}
+	paths := make(map[string]any, len(ops))
+	for _, op := range ops {
+		params := make([]any, 0, len(op.Params))
+		for _, p := range op.Params {
+			schema := map[string]any{"type": p.Type}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      schema,
+			})
+		}
+
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success := map[string]any{"description": "Success"}
+		if op.Response != nil {
+			success["content"] = map[string]any{
+				contentType: map[string]any{"schema": b.schemaOf(reflect.TypeOf(op.Response))},
+			}
+		} else if contentType != "application/json" {
+			success["content"] = map[string]any{contentType: map[string]any{"schema": map[string]any{"type": "string"}}}
+		}
+		errorResponse := func(description string) any {
+			return map[string]any{
+				"description": description,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+				},
+			}
+		}
+
+		paths[prefix+op.Path] = map[string]any{
+			"get": map[string]any{
+				"summary":    op.Summary,
+				"parameters": params,
+				"responses": map[string]any{
+					"200": success,
+					"400": errorResponse("Invalid parameter"),
+					"404": errorResponse("Not found"),
+					"500": errorResponse("Internal server error"),
+				},
+			},
+		}
+	}
+
+	b.schemas["Error"] = map[string]any{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": map[string]any{
+			"code":      map[string]any{"type": "string"},
+			"message":   map[string]any{"type": "string"},
+			"parameter": map[string]any{"type": "string"},
+		},
+	}
+
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       map[string]any{"title": title, "version": version},
+		"paths":      paths,
+		"components": map[string]any{"schemas": b.schemas},
+	}
+}
+
+type schemaBuilder struct {
+	schemas map[string]any
+	types   map[string]reflect.Type
+}
+
+// Named structs are added to the schemas and referenced
+func (b *schemaBuilder) schemaOf(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := b.schemaOf(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := schemaName(t)
+		if other, ok := b.types[name]; ok && other != t {
+			// same name in two packages
+			name = capitalize(path.Base(t.PkgPath())) + name
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := b.types[name]; ok {
+			return ref
+		}
+		b.types[name] = t // registered before the fields for recursive types
+		b.schemas[name] = b.structSchema(t)
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		jsonName, omitempty := jsonField(f)
+		if jsonName == "-" {
+			continue
+		}
+		properties[jsonName] = b.schemaOf(f.Type)
+		if !omitempty {
+			required = append(required, jsonName)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Schema names are the type names without the api or db prefix, capitalized
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(t.Name(), "api"), "db")
+	return capitalize(name)
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func jsonField(f reflect.StructField) (name string, omitempty bool) {
+	tag := f.Tag.Get("json")
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(options, "omitempty")
+}
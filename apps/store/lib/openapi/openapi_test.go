@@ -0,0 +1,150 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+type apiPoint struct {
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+	Note  string  `json:"note,omitempty"`
+}
+
+type apiSeries struct {
+	TypeId int        `json:"typeId"`
+	Points []apiPoint `json:"points"`
+	Next   *apiSeries `json:"next"`
+	hidden int
+}
+
+func TestDocument(t *testing.T) {
+	ops := []Operation{{
+		Path:     "/series",
+		Summary:  "A series",
+		Params:   []Param{{Name: "type", Type: "integer", Required: true}},
+		Response: []apiSeries{},
+	}}
+	doc := Document("test", "1", "/v1", ops)
+
+	// the document must be valid json
+	_, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := doc["paths"].(map[string]any)
+	if _, ok := paths["/v1/series"]; !ok {
+		t.Fatalf("missing path /v1/series in %v", paths)
+	}
+	schemas := doc["components"].(map[string]any)["schemas"].(map[string]any)
+	series, ok := schemas["Series"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing Series schema in %v", schemas)
+	}
+	properties := series["properties"].(map[string]any)
+	if len(properties) != 3 {
+		t.Errorf("expected the 3 json fields of apiSeries, got %v", properties)
+	}
+	point := schemas["Point"].(map[string]any)
+	if !slices.Equal(point["required"].([]string), []string{"time", "price"}) {
+		t.Errorf("expected omitempty fields to be optional, got %v", point["required"])
+	}
+	if properties["points"].(map[string]any)["items"].(map[string]any)["$ref"] != "#/components/schemas/Point" {
+		t.Errorf("expected points to reference Point, got %v", properties["points"])
+	}
+}
+
+func TestHandler(t *testing.T) {
+	op := Operation{
+		Path: "/series",
+		Params: []Param{
+			{Name: "type", Type: "integer", Required: true},
+			{Name: "order", Type: "string", Enum: []string{"asc", "desc"}},
+			{Name: "region", Type: "integer", Validate: func(v string) error {
+				if v != "10000002" {
+					return fmt.Errorf("is not a known region")
+				}
+				return nil
+			}},
+		},
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		},
+	}
+	handler := JSONErrors(Handler(op))
+
+	tests := []struct {
+		query     string
+		status    int
+		parameter string
+	}{
+		{"?type=34", 200, ""},
+		{"", 400, "type"},
+		{"?type=abc", 400, "type"},
+		{"?type=34&order=up", 400, "order"},
+		{"?type=34&region=1", 400, "region"},
+		{"?type=34&region=10000002&order=asc", 200, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/series"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("%q: got status %d, want %d", test.query, w.Code, test.status)
+			continue
+		}
+		if test.status == 200 {
+			continue
+		}
+		var e apiError
+		err := json.Unmarshal(w.Body.Bytes(), &e)
+		if err != nil {
+			t.Errorf("%q: invalid json error %q", test.query, w.Body.String())
+			continue
+		}
+		if e.Code != "bad_request" || e.Parameter != test.parameter || e.Message == "" {
+			t.Errorf("%q: unexpected error %+v", test.query, e)
+		}
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	handler := JSONErrors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal server error", 500)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var e apiError
+	err := json.Unmarshal(w.Body.Bytes(), &e)
+	if err != nil || e != (apiError{Code: "internal_error", Message: "Internal server error"}) {
+		t.Errorf("unexpected error %q", w.Body.String())
+	}
+}
+
+func TestJSONErrorsParam(t *testing.T) {
+	// only the ParamError errors name a param, not the messages quoting one
+	handler := JSONErrors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("typed") != "" {
+			ParamError(w, "estimator", `is not available with param "location"`)
+			return
+		}
+		http.Error(w, `Bad request: resolution 10m is only available with param "estimator"`, 400)
+	}))
+
+	for query, parameter := range map[string]string{"?typed=1": "estimator", "": ""} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/"+query, nil))
+		var e apiError
+		err := json.Unmarshal(w.Body.Bytes(), &e)
+		if err != nil || w.Code != 400 || e.Parameter != parameter {
+			t.Errorf("%q: unexpected error %d %q", query, w.Code, w.Body.String())
+		}
+	}
+}
@@ -26,3 +26,7 @@ func Init(secretJson []byte) (*SecretManager, error) {
 	}
 	return &sm, nil
 }
+
+func New(secrets map[string]string) *SecretManager {
+	return &SecretManager{secret: secrets}
+}
@@ -2,72 +2,169 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"syscall"
 
+	"github.com/raph5/eve-market-browser/apps/store/items/activemarkets"
+	"github.com/raph5/eve-market-browser/apps/store/items/alerts"
+	"github.com/raph5/eve-market-browser/apps/store/items/anomalies"
+	"github.com/raph5/eve-market-browser/apps/store/items/apikeys"
+	"github.com/raph5/eve-market-browser/apps/store/items/backups"
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
 	"github.com/raph5/eve-market-browser/apps/store/items/histories"
 	"github.com/raph5/eve-market-browser/apps/store/items/locations"
+	"github.com/raph5/eve-market-browser/apps/store/items/marketgroups"
+	"github.com/raph5/eve-market-browser/apps/store/items/metrics"
 	"github.com/raph5/eve-market-browser/apps/store/items/orders"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/status"
 	"github.com/raph5/eve-market-browser/apps/store/items/systems"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/config"
 	"github.com/raph5/eve-market-browser/apps/store/lib/database"
+	"github.com/raph5/eve-market-browser/apps/store/lib/esi"
 	"github.com/raph5/eve-market-browser/apps/store/lib/secret"
 	"github.com/raph5/eve-market-browser/apps/store/lib/victoria"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	// Init logger
 	log.SetFlags(log.LstdFlags)
 
-	// Flags
-	var historiesEnabled, ordersEnabled, metricsEnabled, structuresEnabled, unixSocketEnabled, tcpEnabled, victoriaEnabled bool
-	var socketPath, dbPath, secrets string
-	var tcpPort int
-	flag.BoolVar(&historiesEnabled, "history", true, "Enable histories update")
-	flag.BoolVar(&ordersEnabled, "order", true, "Enable orders update")
-	flag.BoolVar(&metricsEnabled, "metric", false, "Enable metrics update")
-	flag.BoolVar(&structuresEnabled, "structure", true, "Enable fetching of public player structures (requires ssoClientId, ssoClientSecret and ssoRefreshToken)")
-	flag.BoolVar(&unixSocketEnabled, "socket", true, "Enable unix socket server")
-	flag.BoolVar(&tcpEnabled, "tcp", false, "Enable tcp server")
-	flag.BoolVar(&victoriaEnabled, "victoria", true, "Enable victoria metric server")
-	flag.StringVar(&socketPath, "socket-path", "/tmp/emb.sock", "Path for the socket of the unix socket server")
-	flag.StringVar(&dbPath, "db", "./data.db", "Path sqlite database")
-	flag.StringVar(&secrets, "secrets", "{}", "Json string containing the secrets in foramt {key: value}")
-	flag.IntVar(&tcpPort, "tcp-port", 7562, "Tcp server port")
+	// Config, the flags override the config file
+	cfg := config.Default()
+	var configPath, secrets string
+	flag.StringVar(&configPath, "config", "", "Path to a toml config file (see store.example.toml)")
+	flag.StringVar(&secrets, "secrets", "{}", "Json string containing the secrets in foramt {key: value}. Prefer the secrets of the config file as the command line is visible to all users")
+	registerFlags(&cfg)
 	flag.Parse()
+	if configPath != "" {
+		err := config.Load(configPath, &cfg)
+		if err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+		flag.Parse()
+	}
+	err := cfg.Validate()
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if flag.NArg() > 0 && flag.Arg(0) != "apikey" && flag.Arg(0) != "migrate" && flag.Arg(0) != "backup" {
+		log.Fatalf("Unknown command: %s", flag.Arg(0))
+	}
 
 	// Init secret manager
-	sm, err := secret.Init([]byte(secrets))
+	secretsMap := make(map[string]string)
+	err = json.Unmarshal([]byte(secrets), &secretsMap)
+	if err != nil {
+		log.Fatalf("Invalid secrets: %v", err)
+	}
+	configSecrets, err := cfg.ReadSecrets()
 	if err != nil {
 		log.Fatalf("Invalid secrets: %v", err)
 	}
+	for name, value := range configSecrets {
+		secretsMap[name] = value
+	}
+	sm := secret.New(secretsMap)
 
-	// Check if secrets are set
-	if structuresEnabled {
+	// Check if secrets are set, the commands don't need them
+	if cfg.Workers.Structures && flag.NArg() == 0 {
 		_ = sm.Get("ssoClientId")
 		_ = sm.Get("ssoClientSecret")
 		_ = sm.Get("ssoRefreshToken")
 	}
 
-	// Init database
-	db, err := database.Init(dbPath)
+	// Check metric estimators
+	for _, e := range cfg.Metrics.Estimators {
+		if !metrics.ValidEstimator(e) {
+			log.Fatalf("Invalid metric estimator: %s", e)
+		}
+	}
+	// Check downloaded regions
+	err = regions.CheckDownloaded(cfg.Regions.Downloaded)
+	if err != nil {
+		log.Fatalf("Invalid downloaded regions: %v", err)
+	}
+
+	// The restore replaces the database file, it runs before it is opened
+	if flag.Arg(0) == "backup" && flag.Arg(1) == "restore" {
+		err = runBackupRestoreCommand(cfg, flag.Args()[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Init database, the migrations are applied after the migrate command
+	var db *database.DB
+	if cfg.DbDriver == "postgres" {
+		db, err = database.OpenPostgres(cfg.Db)
+	} else {
+		db, err = database.Open(cfg.Db)
+	}
 	if err != nil {
 		log.Fatalf("Can't start up the database: %v", err)
 	}
 	defer db.Close()
 
-	// Init context
+	// Init app
+	esiClient := esi.NewClient(esi.Options{
+		UserAgent:             cfg.Esi.UserAgent,
+		MaxConcurrentRequests: cfg.Esi.MaxConcurrentRequests,
+		RequestTimeout:        cfg.Esi.RequestTimeout,
+		Secrets:               sm,
+	})
+	a := app.New(db, esiClient, cfg)
 	ctx, cancel := context.WithCancel(context.Background())
-	ctx = context.WithValue(ctx, "db", db)
-	ctx = context.WithValue(ctx, "sm", sm)
-	ctx = context.WithValue(ctx, "structuresEnabled", structuresEnabled)
-	ctx = context.WithValue(ctx, "metricsEnabled", metricsEnabled)
+
+	// Commands
+	if flag.Arg(0) == "migrate" {
+		err = runMigrateCommand(ctx, a, flag.Args()[1:])
+		cancel()
+		if err != nil {
+			db.Close()
+			log.Fatal(err)
+		}
+		return
+	}
+	_, err = db.Migrate(ctx, false)
+	if err != nil {
+		cancel()
+		db.Close()
+		log.Fatalf("Can't migrate the database: %v", err)
+	}
+	if flag.Arg(0) == "apikey" {
+		err = runApiKeyCommand(ctx, a, flag.Args()[1:])
+		cancel()
+		if err != nil {
+			db.Close()
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "backup" {
+		err = runBackupCommand(ctx, a, flag.Args()[1:])
+		cancel()
+		if err != nil {
+			db.Close()
+			log.Fatal(err)
+		}
+		return
+	}
+
 	exitCh := make(chan os.Signal, 1)
 	signal.Notify(exitCh, syscall.SIGINT, syscall.SIGTERM)
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
 
 	// Init systems
 	err = systems.Init()
@@ -75,38 +172,92 @@ func main() {
 		log.Printf("Impossible to initialize systems: %v", err)
 	}
 
+	// Init region groups
+	if cfg.Regions.Groups != "" {
+		err = regions.LoadGroups(a, cfg.Regions.Groups)
+		if err != nil {
+			log.Fatalf("Invalid region groups: %v", err)
+		}
+	}
+
+	// Init market groups
+	marketGroupsPath := cfg.MarketGroups
+	if marketGroupsPath == "" && os.Getenv("ESI_CACHE") != "" {
+		marketGroupsPath = filepath.Join(os.Getenv("ESI_CACHE"), "market-group.json")
+	}
+	if marketGroupsPath != "" {
+		err = marketgroups.Load(marketGroupsPath)
+		if err != nil {
+			log.Printf("Impossible to load market groups: %v", err)
+		}
+	}
+
 	// Init locations
-	err = locations.Init(ctx)
+	err = locations.Init(ctx, a)
 	if err != nil {
 		log.Printf("Impossible to initialize locations: %v", err)
 	}
 
 	// Mux handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/order", orders.CreateHandler(ctx))
-	mux.HandleFunc("/history", histories.CreateHandler(ctx))
+	mux.HandleFunc("/order", orders.CreateHandler(ctx, a))
+	mux.HandleFunc("/history", histories.CreateHandler(ctx, a))
+	mux.HandleFunc("/screener", histories.CreateScreenerHandler(ctx, a))
+	mux.HandleFunc("/anomalies", anomalies.CreateHandler(ctx, a))
+	mux.HandleFunc("/events", events.CreateHandler(ctx, a))
+	mux.HandleFunc("/status", status.CreateHandler(ctx, a))
+	mux.Handle("/v1/", createV1Handler(ctx, a))
+	mux.HandleFunc("/stats", metrics.CreateItemStatsHandler(ctx, a))
+	mux.HandleFunc("/stats/range", metrics.CreateItemStatsRangeHandler(ctx, a))
+	mux.HandleFunc("/stats/top", metrics.CreateTopTypesHandler(ctx, a))
+	mux.HandleFunc("/intraday", metrics.CreateIntradayHandler(ctx, a))
+	mux.HandleFunc("/global", metrics.CreateGlobalHandler(ctx, a))
+	mux.HandleFunc("/global/now", metrics.CreateGlobalNowHandler(ctx, a))
+
+	// Admin mux handler, only served on the unix socket
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/", mux)
+	adminMux.HandleFunc("/activemarkets", activemarkets.CreateAdminHandler(ctx, a))
+	adminMux.HandleFunc("/alerts", alerts.CreateAdminHandler(ctx, a))
+	adminMux.HandleFunc("/backups", backups.CreateAdminHandler(ctx, a))
+	adminMux.HandleFunc("/status", status.CreateAdminHandler(ctx, a))
+
+	// gRPC server
+	grpcServer := grpc.NewServer()
+	orders.RegisterGrpc(ctx, a, grpcServer)
+	histories.RegisterGrpc(ctx, a, grpcServer)
+	metrics.RegisterGrpc(ctx, a, grpcServer)
 
 	// Start workers and servers
 	var mainWg sync.WaitGroup
-	if unixSocketEnabled {
+	if cfg.Servers.Socket {
 		mainWg.Add(1)
 		go func() {
-			runUnixSocketServer(ctx, mux, socketPath)
+			runUnixSocketServer(ctx, adminMux, cfg.Servers.SocketPath)
 			log.Print("Unix socket server stopped")
 			mainWg.Done()
 			cancel()
 		}()
 	}
-	if tcpEnabled {
+	if cfg.Servers.Tcp {
 		mainWg.Add(1)
 		go func() {
-			runTcpServer(ctx, mux, tcpPort)
+			runTcpServer(ctx, apikeys.Middleware(ctx, a, mux), cfg.Servers.TcpPort)
 			log.Print("Tcp server stopped")
 			mainWg.Done()
 			cancel()
 		}()
 	}
-	if victoriaEnabled {
+	if cfg.Servers.Grpc {
+		mainWg.Add(1)
+		go func() {
+			runGrpcServer(ctx, grpcServer, cfg.Servers.GrpcSocketPath)
+			log.Print("gRPC server stopped")
+			mainWg.Done()
+			cancel()
+		}()
+	}
+	if cfg.Servers.Victoria {
 		mainWg.Add(1)
 		go func() {
 			victoria.RunVictoriaServer(ctx)
@@ -115,33 +266,69 @@ func main() {
 			cancel()
 		}()
 	}
-	if ordersEnabled {
+	if cfg.Workers.Orders {
 		mainWg.Add(1)
 		go func() {
-			runOrdersHoardling(ctx)
+			runOrdersHoardling(ctx, a)
 			log.Print("Order worker stopped")
 			mainWg.Done()
 			cancel()
 		}()
 	}
-	if historiesEnabled {
+	if cfg.Workers.Histories {
 		mainWg.Add(1)
 		go func() {
-			runHistoriesHoardling(ctx)
+			runHistoriesHoardling(ctx, a)
 			log.Print("History worker stopped")
 			mainWg.Done()
 			cancel()
 		}()
 	}
+	if cfg.Workers.Backups {
+		mainWg.Add(1)
+		go func() {
+			runBackupsHoardling(ctx, a)
+			log.Print("Backup worker stopped")
+			mainWg.Done()
+			cancel()
+		}()
+	}
 	log.Print("Store is up")
 
-	// Handle store shutdown
-	select {
-	case <-exitCh:
-		log.Print("Stopping the store...")
-		cancel()
-	case <-ctx.Done():
+	// Handle config reloads and store shutdown
+	for ctx.Err() == nil {
+		select {
+		case <-reloadCh:
+			reloadConfig(a, configPath)
+		case <-exitCh:
+			log.Print("Stopping the store...")
+			cancel()
+		case <-ctx.Done():
+		}
 	}
 	mainWg.Wait()
 	log.Print("Store stopped")
 }
+
+// Reload the settings of the config file that are safe to change while the
+// store runs
+func reloadConfig(a *app.App, configPath string) {
+	if configPath == "" {
+		log.Print("Config reload: no config file")
+		return
+	}
+	reloaded := config.Default()
+	err := config.Load(configPath, &reloaded)
+	if err == nil {
+		err = reloaded.Validate()
+	}
+	if err == nil {
+		err = regions.CheckDownloaded(reloaded.Regions.Downloaded)
+	}
+	if err != nil {
+		log.Printf("Config reload error: %v", err)
+		return
+	}
+	a.Reload(reloaded)
+	log.Print("Config reloaded")
+}
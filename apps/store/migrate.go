@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+)
+
+const migrateUsage = `usage:
+  store [flags] migrate status
+  store [flags] migrate up [-dry-run]`
+
+// Inspect and apply the database migrations. The store applies the pending
+// migrations at startup anyway.
+func runMigrateCommand(ctx context.Context, a *app.App, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		states, err := a.DB.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+
+	case "up":
+		var dryRun bool
+		flags := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+		flags.BoolVar(&dryRun, "dry-run", false, "Apply the migrations to a temporary copy of the database, in a rolled back transaction with postgres")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		if flags.NArg() != 0 {
+			return errors.New(migrateUsage)
+		}
+		pending, err := a.DB.Migrate(ctx, dryRun)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Println("The database is up to date")
+		}
+		for _, m := range pending {
+			if dryRun {
+				fmt.Printf("Would apply migration %d (%s)\n", m.Version, m.Name)
+			} else {
+				fmt.Printf("Applied migration %d (%s)\n", m.Version, m.Name)
+			}
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -8,13 +8,15 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
-func runTcpServer(ctx context.Context, mux *http.ServeMux, port int) {
+func runTcpServer(ctx context.Context, handler http.Handler, port int) {
 	errCh := make(chan error)
 	server := &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
-		Handler: mux,
+		Handler: handler,
 	}
 
 	go func() {
@@ -92,3 +94,55 @@ func runUnixSocketServer(ctx context.Context, mux *http.ServeMux, socketPath str
 
 	log.Print("Unix socket server: not listening")
 }
+
+func runGrpcServer(ctx context.Context, server *grpc.Server, socketPath string) {
+	_, err := os.Stat(socketPath)
+	if err == nil {
+		err = os.Remove(socketPath)
+		if err != nil {
+			log.Printf("gRPC server error: %v", err)
+			return
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		log.Printf("gRPC server error: %v", err)
+		return
+	}
+	defer listener.Close()
+
+	errCh := make(chan error)
+	go func() {
+		log.Printf("gRPC server: listening on %s", socketPath)
+		err := server.Serve(listener)
+		if err != nil {
+			errCh <- err
+			return
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+	case err = <-errCh:
+		log.Printf("gRPC server error: %v", err)
+	}
+
+	err = os.Remove(socketPath)
+	if err != nil {
+		log.Printf("gRPC server error: %v", err)
+	}
+	// NOTE: GracefulStop waits for the order streams, they end with ctx
+	stoppedCh := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stoppedCh)
+	}()
+	select {
+	case <-stoppedCh:
+	case <-time.After(5 * time.Second):
+		server.Stop()
+	}
+
+	log.Print("gRPC server: not listening")
+}
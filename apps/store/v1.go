@@ -0,0 +1,108 @@
+// The versioned api served under /v1. Its routes are built from the
+// operations declared by the items packages so that the OpenAPI document at
+// /v1/openapi.json always matches them. Unlike the legacy routes, the errors
+// are json objects and the type and region params are checked against the
+// known ids.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/raph5/eve-market-browser/apps/store/items/anomalies"
+	"github.com/raph5/eve-market-browser/apps/store/items/events"
+	"github.com/raph5/eve-market-browser/apps/store/items/histories"
+	"github.com/raph5/eve-market-browser/apps/store/items/marketgroups"
+	"github.com/raph5/eve-market-browser/apps/store/items/metrics"
+	"github.com/raph5/eve-market-browser/apps/store/items/orders"
+	"github.com/raph5/eve-market-browser/apps/store/items/regions"
+	"github.com/raph5/eve-market-browser/apps/store/items/status"
+	"github.com/raph5/eve-market-browser/apps/store/lib/app"
+	"github.com/raph5/eve-market-browser/apps/store/lib/openapi"
+)
+
+func v1Operations(ctx context.Context, a *app.App) []openapi.Operation {
+	ops := make([]openapi.Operation, 0, 16)
+	ops = append(ops, orders.Operations(ctx, a)...)
+	ops = append(ops, histories.Operations(ctx, a)...)
+	ops = append(ops, metrics.Operations(ctx, a)...)
+	ops = append(ops, anomalies.Operations(ctx, a)...)
+	ops = append(ops, events.Operations(ctx, a)...)
+	ops = append(ops, status.Operations(ctx, a)...)
+
+	for i := range ops {
+		for j := range ops[i].Params {
+			p := &ops[i].Params[j]
+			switch p.Name {
+			case "type":
+				p.Validate = validateType
+			case "region":
+				p.Validate = createRegionValidator(a)
+			}
+		}
+	}
+	return ops
+}
+
+func createV1Handler(ctx context.Context, a *app.App) http.Handler {
+	ops := v1Operations(ctx, a)
+	mux := http.NewServeMux()
+	for _, op := range ops {
+		mux.HandleFunc(op.Path, openapi.Handler(op))
+	}
+
+	document, err := json.Marshal(openapi.Document("EVE Market Browser store", "1", "/v1", ops))
+	if err != nil {
+		log.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Endpoint not found", 404)
+	})
+
+	return http.StripPrefix("/v1", openapi.JSONErrors(mux))
+}
+
+func validateType(value string) error {
+	typeId, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("is invalid integer")
+	}
+	ok, err := marketgroups.IsMarketType(typeId)
+	if errors.Is(err, marketgroups.ErrNotLoaded) {
+		// the types can't be checked without the market groups
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("is not a known market type")
+	}
+	return nil
+}
+
+// 0 is the global market and the region groups have synthetic ids
+func createRegionValidator(a *app.App) func(value string) error {
+	return func(value string) error {
+		regionId, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("is invalid integer")
+		}
+		if regionId == 0 || regions.IsRegion(regionId) {
+			return nil
+		}
+		for _, g := range regions.Groups(a) {
+			if g.Id == regionId {
+				return nil
+			}
+		}
+		return fmt.Errorf("is not a known region")
+	}
+}
@@ -28,10 +28,11 @@ export interface HistoryDay {
   average20d: number,
   highest: number,
   lowest: number,
-  orderCount: number,
+  orderCount: number,  // -1 on the filled days
   volume: number,
   donchianTop: number,
-  donchianBottom: number
+  donchianBottom: number,
+  filled?: boolean
 }
 
 export interface Order {
@@ -91,7 +91,7 @@ export class Volume implements Object2d {
       this.tooltipHtml = `
         ${formatDate(new Date(date))}<br>
         Volume : ${formatInt(volume)}<br>
-        Orders : ${formatInt(orderCount)}
+        Orders : ${orderCount < 0 ? "unknown" : formatInt(orderCount)}
       `
     }
     else {